@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // LoginHandlerFunc
@@ -36,6 +37,8 @@ var (
 		"GET /language",
 		"GET /langmsgs",
 		"GET /captcha",
+		"GET /healthz",
+		"GET /readyz",
 		regexp.MustCompile("GET /assets"),
 	}
 	ExpiresSeconds = 86400
@@ -125,8 +128,14 @@ func DecodedContext(c *gin.Context) (sign *SignContext, err error) {
 	sign = &SignContext{Token: token, Lang: ParseLang(c)}
 	// 解析UID
 	var secret SignSecret
-	if err = DB().Where(&SignSecret{Token: token}).Find(&secret).Error; err != nil {
-		return
+	if v, ok := DefaultL1Cache.Get(signSecretCacheKey(token)); ok {
+		secret = v.(SignSecret)
+	} else {
+		if err = DB().Where(&SignSecret{Token: token}).Find(&secret).Error; err != nil {
+			return
+		}
+		// 登出状态依赖跨节点失效消息，使用较短的过期时间限制消息丢失时的影响
+		DefaultL1Cache.Set(signSecretCacheKey(token), secret, time.Duration(C().DefaultGetInt("l1Cache:signSecretTTL", 5))*time.Second)
 	}
 	sign.UID = secret.UID
 	// 验证令牌
@@ -151,6 +160,10 @@ func DecodedContext(c *gin.Context) (sign *SignContext, err error) {
 	return
 }
 
+func signSecretCacheKey(token string) string {
+	return fmt.Sprintf("sign_secret_%s", token)
+}
+
 // EncodedToken
 func EncodedToken(claims jwt.MapClaims, secret string) (signed string, err error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
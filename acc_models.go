@@ -30,6 +30,27 @@ type SignSecret struct {
 	Type       string    `name:"令牌类型"`
 }
 
+// AfterSave
+func (s *SignSecret) AfterSave(tx *gorm.DB) (err error) {
+	invalidateSignSecret(s.Token)
+	return
+}
+
+// AfterDelete
+func (s *SignSecret) AfterDelete(tx *gorm.DB) (err error) {
+	invalidateSignSecret(s.Token)
+	return
+}
+
+// invalidateSignSecret 令牌为空时（如按条件批量修改）清除全部令牌密钥缓存
+func invalidateSignSecret(token string) {
+	if token != "" {
+		InvalidateL1(signSecretCacheKey(token))
+	} else {
+		InvalidateL1Prefix(signSecretCacheKey(""))
+	}
+}
+
 // SignContext
 type SignContext struct {
 	Token    string
@@ -39,6 +60,8 @@ type SignContext struct {
 	SubDocID uint
 	Payload  jwt.MapClaims
 	Secret   *SignSecret
+	// task 由任务队列恢复的入队用户身份，不含令牌及密钥
+	task bool
 }
 
 // IsValid
@@ -46,6 +69,10 @@ func (s *SignContext) IsValid() (ret bool) {
 	if s == nil {
 		return
 	}
+	// 入队时已校验登录状态，重试时令牌可能已过期
+	if s.task {
+		return s.UID != 0
+	}
 	if err := s.Payload.Valid(); err == nil && s.Token != "" && s.UID != 0 && s.Secret != nil {
 		ret = true
 	}
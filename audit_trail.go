@@ -0,0 +1,460 @@
+package kuu
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// RouteNameKey 当前请求的路由名称（RouteInfo.Name）
+var RouteNameKey = "RouteName"
+
+// AuditTrail 结构化审计记录，按ID顺序组成哈希链
+type AuditTrail struct {
+	ID            uint      `gorm:"primary_key"`
+	UUID          string    `name:"审计ID（UUID）" gorm:"unique_index"`
+	Time          time.Time `name:"操作时间" gorm:"precision:6"`
+	Action        string    `name:"操作类型" enum:"AuditType"`
+	ModelName     string    `name:"模型名称" sql:"index"`
+	TableName     string    `name:"表名"`
+	PrimaryKeys   string    `name:"影响数据主键" gorm:"type:text"`
+	ChangedFields string    `name:"变更字段" gorm:"type:text"`
+	UID           uint      `name:"操作人ID" sql:"index"`
+	Username      string    `name:"操作人账号"`
+	RealName      string    `name:"操作人姓名"`
+	ActOrgID      uint      `name:"当前组织ID"`
+	ActOrgCode    string    `name:"当前组织编码"`
+	RouteName     string    `name:"接口名称"`
+	RequestID     string    `name:"请求ID"`
+	RequestMethod string    `name:"请求方法"`
+	RequestPath   string    `name:"请求接口"`
+	RequestIP     string    `name:"调用IP"`
+	SQL           string    `name:"SQL" gorm:"type:text"`
+	SQLVars       string    `name:"SQL参数" gorm:"type:text"`
+	PrevHash      string    `name:"上一条记录哈希"`
+	Hash          string    `name:"记录哈希"`
+}
+
+// IgnoreLog
+func (a *AuditTrail) IgnoreLog() {}
+
+var auditSecretWarnOnce sync.Once
+
+// auditSecret 哈希密钥，未配置时无法防止篡改后重新计算整条哈希链
+func auditSecret() []byte {
+	secret := C().GetString("audit:secret")
+	if secret == "" {
+		auditSecretWarnOnce.Do(func() {
+			WARN("audit:secret is not configured, audit trail hashes can be recomputed by anyone with database access")
+		})
+	}
+	return []byte(secret)
+}
+
+// ComputeHash 使用audit:secret计算记录的HMAC-SHA256，内容包含上一条记录的哈希
+func (a *AuditTrail) ComputeHash() string {
+	content := strings.Join([]string{
+		a.PrevHash,
+		a.UUID,
+		fmt.Sprintf("%d", a.Time.UnixNano()),
+		a.Action,
+		a.ModelName,
+		a.TableName,
+		a.PrimaryKeys,
+		a.ChangedFields,
+		fmt.Sprintf("%d", a.UID),
+		a.Username,
+		a.RealName,
+		fmt.Sprintf("%d", a.ActOrgID),
+		a.ActOrgCode,
+		a.RouteName,
+		a.RequestID,
+		a.RequestMethod,
+		a.RequestPath,
+		a.RequestIP,
+		a.SQL,
+		a.SQLVars,
+	}, "\x1f")
+	mac := hmac.New(sha256.New, auditSecret())
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuditVerifyResult
+type AuditVerifyResult struct {
+	Valid    bool
+	Total    int
+	BrokenID uint   `json:",omitempty"`
+	Reason   string `json:",omitempty"`
+}
+
+// GetRoutineRouteName
+func GetRoutineRouteName() string {
+	if c := GetRoutineRequestContext(); c != nil {
+		return c.GetString(RouteNameKey)
+	}
+	return ""
+}
+
+// withRouteName 记录当前请求的路由名称，用于审计
+func withRouteName(name string, handler HandlerFunc) HandlerFunc {
+	return func(c *Context) {
+		c.Set(RouteNameKey, name)
+		handler(c)
+	}
+}
+
+// auditPasswordFields 返回需脱敏的字段名
+func auditPasswordFields(meta *Metadata) map[string]bool {
+	passwords := make(map[string]bool)
+	if meta != nil {
+		for _, field := range meta.Fields {
+			if field.IsPassword {
+				passwords[field.Code] = true
+			}
+		}
+	}
+	return passwords
+}
+
+// auditSQLVars 序列化SQL参数，与ChangedFields一致地对密码字段的值脱敏
+func auditSQLVars(scope *gorm.Scope, meta *Metadata) string {
+	passwords := auditPasswordFields(meta)
+	if len(passwords) == 0 || len(scope.SQLVars) == 0 {
+		return JSONStringify(scope.SQLVars, false)
+	}
+	var secrets []interface{}
+	if !IsNil(scope.Value) && indirectValue(scope.Value).Kind() == reflect.Struct {
+		for _, field := range scope.Fields() {
+			if passwords[field.Name] && !field.IsBlank {
+				secrets = append(secrets, field.Field.Interface())
+			}
+		}
+	}
+	if attrs, ok := scope.InstanceGet("gorm:update_attrs"); ok {
+		if values, ok := attrs.(map[string]interface{}); ok {
+			for key, value := range values {
+				if field, ok := scope.FieldByName(key); ok {
+					key = field.Name
+				}
+				if passwords[key] {
+					secrets = append(secrets, value)
+				}
+			}
+		}
+	}
+	vars := make([]interface{}, len(scope.SQLVars))
+	for i, v := range scope.SQLVars {
+		vars[i] = v
+		for _, secret := range secrets {
+			if reflect.DeepEqual(v, secret) {
+				vars[i] = "******"
+				break
+			}
+		}
+	}
+	return JSONStringify(vars, false)
+}
+
+func auditChangedFields(scope *gorm.Scope, meta *Metadata, auditType string) map[string]interface{} {
+	passwords := auditPasswordFields(meta)
+	changes := make(map[string]interface{})
+	set := func(name string, value interface{}) {
+		if passwords[name] {
+			value = "******"
+		}
+		changes[name] = value
+	}
+	switch auditType {
+	case AuditTypeCreate:
+		if IsNil(scope.Value) || indirectValue(scope.Value).Kind() != reflect.Struct {
+			return nil
+		}
+		for _, field := range scope.Fields() {
+			if field.IsNormal && !field.IsIgnored && !field.IsBlank {
+				set(field.Name, field.Field.Interface())
+			}
+		}
+	case AuditTypeUpdate:
+		if attrs, ok := scope.InstanceGet("gorm:update_attrs"); ok {
+			if values, ok := attrs.(map[string]interface{}); ok {
+				for key, value := range values {
+					if field, ok := scope.FieldByName(key); ok {
+						key = field.Name
+					}
+					set(key, value)
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// NewAuditTrail 创建结构化审计记录并写入缓存，由AuditPersistJob持久化
+func NewAuditTrail(scope *gorm.Scope, auditType string) {
+	if !C().DefaultGetBool("audit:trail", true) {
+		return
+	}
+	var (
+		meta  = Meta(scope.Value)
+		trail = AuditTrail{
+			UUID:      uuid.NewV4().String(),
+			Time:      time.Now().Truncate(time.Microsecond), // 数据库最多保存到微秒，截断后读回时哈希一致
+			Action:    auditType,
+			TableName: scope.TableName(),
+			SQL:       scope.SQL,
+		}
+	)
+	trail.SQLVars = auditSQLVars(scope, meta)
+	if meta != nil {
+		trail.ModelName = meta.Name
+	}
+	if !IsNil(scope.Value) && indirectValue(scope.Value).Kind() == reflect.Struct {
+		if field := scope.PrimaryField(); field != nil && !field.IsBlank {
+			trail.PrimaryKeys = JSONStringify([]interface{}{field.Field.Interface()}, false)
+		}
+	}
+	if changes := auditChangedFields(scope, meta, auditType); len(changes) > 0 {
+		trail.ChangedFields = JSONStringify(changes, false)
+	}
+	if desc := GetRoutinePrivilegesDesc(); desc != nil {
+		trail.UID = desc.UID
+		trail.ActOrgID = desc.ActOrgID
+		trail.ActOrgCode = desc.ActOrgCode
+		if user := GetUserFromCache(desc.UID); user.ID != 0 {
+			trail.Username = user.Username
+			trail.RealName = user.Name
+		}
+	}
+	if c := GetRoutineRequestContext(); c != nil && c.Request != nil {
+		trail.RouteName = c.GetString(RouteNameKey)
+		trail.RequestID = GetRequestID(c.Context)
+		trail.RequestMethod = c.Request.Method
+		trail.RequestPath = c.Request.URL.Path
+		trail.RequestIP = c.ClientIP()
+	}
+	// 键名以纳秒时间戳开头，保证持久化时按操作顺序组链
+	key := BuildKey("audit", fmt.Sprintf("%019d", trail.Time.UnixNano()), trail.UUID)
+	SetCacheString(key, JSONStringify(&trail, false))
+}
+
+// AuditPersistJob 按顺序为缓存中的审计记录计算哈希链并批量写入数据库
+func AuditPersistJob() {
+	// 哈希链依赖写入顺序，多实例同时写入会导致链断裂
+	lock, err := AcquireLock("audit_persist", time.Minute, 10*time.Second)
+	if err != nil {
+		WARN("Persist audit trails skipped: %s", err.Error())
+		return
+	}
+	stop := keepLockAlive(lock, time.Minute)
+	defer func() {
+		stop()
+		_ = lock.Unlock()
+	}()
+
+	data := HasPrefixCache(BuildKey("audit"), 5000)
+	if len(data) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	err = WithTransaction(func(tx *gorm.DB) error {
+		var last AuditTrail
+		if err := tx.Order("id desc").Limit(1).Find(&last).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		prevHash := last.Hash
+		for _, key := range keys {
+			var trail AuditTrail
+			if err := JSONParse(data[key], &trail); err != nil || trail.UUID == "" {
+				continue
+			}
+			trail.ID = 0
+			trail.PrevHash = prevHash
+			trail.Hash = trail.ComputeHash()
+			if err := tx.Create(&trail).Error; err != nil {
+				return err
+			}
+			prevHash = trail.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		ERROR("Persist audit trails failed: %s", err.Error())
+		return
+	}
+	DelCache(keys...)
+}
+
+// VerifyAuditChain 校验哈希链，start和end为零值时不限制时间范围
+func VerifyAuditChain(start, end time.Time) (result AuditVerifyResult, err error) {
+	var (
+		lastID   uint
+		prevHash string
+		first    = true
+	)
+	result.Valid = true
+	for {
+		var list []AuditTrail
+		db := auditRangeDB(DB(), start, end).Where("id > ?", lastID).Order("id asc").Limit(1000)
+		if err = db.Find(&list).Error; err != nil {
+			return
+		}
+		if len(list) == 0 {
+			break
+		}
+		for _, item := range list {
+			result.Total++
+			lastID = item.ID
+			// 区间内第一条记录的PrevHash作为校验起点
+			if !first && item.PrevHash != prevHash {
+				result.Valid = false
+				result.BrokenID = item.ID
+				result.Reason = "previous hash mismatch"
+				return
+			}
+			first = false
+			if item.ComputeHash() != item.Hash {
+				result.Valid = false
+				result.BrokenID = item.ID
+				result.Reason = "hash mismatch"
+				return
+			}
+			prevHash = item.Hash
+		}
+	}
+	return
+}
+
+func auditRangeDB(db *gorm.DB, start, end time.Time) *gorm.DB {
+	if !start.IsZero() {
+		db = db.Where("time >= ?", start)
+	}
+	if !end.IsZero() {
+		db = db.Where("time < ?", end)
+	}
+	return db
+}
+
+// parseAuditRange 解析start、end参数，支持2006-01-02和RFC3339格式，仅有日期时end包含当天
+func parseAuditRange(c *Context) (start, end time.Time, err error) {
+	parse := func(value string, isEnd bool) (time.Time, error) {
+		if value == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+			if isEnd {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, value)
+	}
+	if start, err = parse(c.Query("start"), false); err != nil {
+		return
+	}
+	end, err = parse(c.Query("end"), true)
+	return
+}
+
+// AuditExportRoute
+var AuditExportRoute = RouteInfo{
+	Name:   "导出审计记录",
+	Method: "GET",
+	Path:   "/audit/export",
+	HandlerFunc: func(c *Context) {
+		start, end, err := parseAuditRange(c)
+		if err != nil {
+			c.STDErr(c.L("audit_export_failed", "Export audit trails failed"), err)
+			return
+		}
+		format := strings.ToLower(c.DefaultQuery("format", "csv"))
+		fileName := fmt.Sprintf("audit_%s.%s", time.Now().Format("20060102150405"), format)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+		var (
+			lastID  uint
+			writer  *csv.Writer
+			written bool
+		)
+		switch format {
+		case "json":
+			c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+		default:
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			writer = csv.NewWriter(c.Writer)
+			_ = writer.Write([]string{"ID", "UUID", "Time", "Action", "ModelName", "TableName", "PrimaryKeys", "ChangedFields",
+				"UID", "Username", "RealName", "ActOrgID", "ActOrgCode", "RouteName", "RequestID", "RequestMethod", "RequestPath",
+				"RequestIP", "PrevHash", "Hash"})
+		}
+		c.Status(http.StatusOK)
+		for {
+			var list []AuditTrail
+			db := auditRangeDB(DB(), start, end).Where("id > ?", lastID).Order("id asc").Limit(1000)
+			if err := db.Find(&list).Error; err != nil {
+				ERROR("Export audit trails failed: %s", err.Error())
+				break
+			}
+			if len(list) == 0 {
+				break
+			}
+			for _, item := range list {
+				lastID = item.ID
+				written = true
+				if writer != nil {
+					_ = writer.Write([]string{
+						fmt.Sprintf("%d", item.ID), item.UUID, item.Time.Format(time.RFC3339), item.Action, item.ModelName,
+						item.TableName, item.PrimaryKeys, item.ChangedFields, fmt.Sprintf("%d", item.UID), item.Username,
+						item.RealName, fmt.Sprintf("%d", item.ActOrgID), item.ActOrgCode, item.RouteName, item.RequestID,
+						item.RequestMethod, item.RequestPath, item.RequestIP, item.PrevHash, item.Hash,
+					})
+				} else {
+					_, _ = c.Writer.WriteString(JSONStringify(&item, false) + "\n")
+				}
+			}
+			if writer != nil {
+				writer.Flush()
+			}
+		}
+		if writer != nil {
+			writer.Flush()
+		}
+		if !written {
+			c.Writer.WriteHeaderNow()
+		}
+	},
+}
+
+// AuditVerifyRoute
+var AuditVerifyRoute = RouteInfo{
+	Name:   "校验审计记录",
+	Method: "GET",
+	Path:   "/audit/verify",
+	HandlerFunc: func(c *Context) {
+		start, end, err := parseAuditRange(c)
+		if err != nil {
+			c.STDErr(c.L("audit_verify_failed", "Verify audit trails failed"), err)
+			return
+		}
+		result, err := VerifyAuditChain(start, end)
+		if err != nil {
+			c.STDErr(c.L("audit_verify_failed", "Verify audit trails failed"), err)
+			return
+		}
+		c.STD(result)
+	},
+}
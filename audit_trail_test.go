@@ -0,0 +1,87 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditTrailComputeHash(t *testing.T) {
+	now := time.Now()
+	first := AuditTrail{UUID: "a", Time: now, Action: AuditTypeCreate, ModelName: "User", PrimaryKeys: "[1]"}
+	first.Hash = first.ComputeHash()
+	second := AuditTrail{UUID: "b", Time: now, Action: AuditTypeUpdate, ModelName: "User", PrimaryKeys: "[1]", PrevHash: first.Hash}
+	second.Hash = second.ComputeHash()
+
+	if first.Hash == "" || first.Hash == second.Hash {
+		t.Fatal("hash should be non-empty and unique")
+	}
+	if first.ComputeHash() != first.Hash {
+		t.Fatal("hash should be stable")
+	}
+	tampered := second
+	tampered.ChangedFields = `{"Name":"x"}`
+	if tampered.ComputeHash() == second.Hash {
+		t.Fatal("tampered record should change hash")
+	}
+	relinked := second
+	relinked.PrevHash = "other"
+	if relinked.ComputeHash() == second.Hash {
+		t.Fatal("previous hash should be part of the hash")
+	}
+}
+
+type auditTestUser struct {
+	ID       uint
+	Username string
+	Password string
+}
+
+func TestAuditSQLVarsMasksPasswords(t *testing.T) {
+	db := newTestDB(t, nil, nil)
+	meta := &Metadata{Fields: []MetadataField{
+		{Code: "Username"},
+		{Code: "Password", IsPassword: true},
+	}}
+
+	scope := db.NewScope(&auditTestUser{Username: "admin", Password: "hashed"})
+	scope.SQLVars = []interface{}{"admin", "hashed"}
+	if vars := auditSQLVars(scope, meta); vars != `["admin","******"]` {
+		t.Fatalf("unexpected vars of create: %s", vars)
+	}
+
+	scope = db.NewScope(&auditTestUser{ID: 1})
+	scope.InstanceSet("gorm:update_attrs", map[string]interface{}{"password": "changed"})
+	scope.SQLVars = []interface{}{"changed", 1}
+	if vars := auditSQLVars(scope, meta); vars != `["******",1]` {
+		t.Fatalf("unexpected vars of update: %s", vars)
+	}
+}
+
+func TestAuditTrailHashRealName(t *testing.T) {
+	trail := AuditTrail{UUID: "a", Time: time.Now(), UID: 1, Username: "admin", RealName: "Admin"}
+	hash := trail.ComputeHash()
+	trail.RealName = "Other"
+	if trail.ComputeHash() == hash {
+		t.Fatal("real name should be part of the hash")
+	}
+}
+
+func TestAuditTrailHashSecret(t *testing.T) {
+	defer setTestConfig("audit:secret", `"s1"`)()
+	trail := AuditTrail{UUID: "a", Time: time.Unix(100, 0), Action: AuditTypeCreate}
+	hash := trail.ComputeHash()
+
+	restore := setTestConfig("audit:secret", `"s2"`)
+	if trail.ComputeHash() == hash {
+		t.Error("hash should depend on the secret")
+	}
+	restore()
+	if trail.ComputeHash() != hash {
+		t.Error("hash should be stable with the same secret")
+	}
+
+	trail.Time = trail.Time.Add(time.Microsecond)
+	if trail.ComputeHash() == hash {
+		t.Error("sub-second time should be part of the hash")
+	}
+}
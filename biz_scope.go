@@ -34,6 +34,7 @@ type BizQueryResult struct {
 	Preload      string                 `json:"preload,omitempty"`
 	Sort         string                 `json:"sort,omitempty"`
 	Range        string                 `json:"range,omitempty"`
+	Trash        bool                   `json:"trash,omitempty"`
 	Page         int                    `json:"page,omitempty"`
 	Size         int                    `json:"size,omitempty"`
 	TotalRecords int                    `json:"totalrecords,omitempty"`
@@ -41,6 +42,11 @@ type BizQueryResult struct {
 	List         interface{}            `json:"list,omitempty"`
 }
 
+// BizRestCacheKeyInterface 查询缓存命中时不执行query钩子，钩子依赖的请求参数（如请求头）需通过BizRestCacheKey加入缓存键
+type BizRestCacheKeyInterface interface {
+	BizRestCacheKey(c *Context) string
+}
+
 type BizPreloadInterface interface {
 	BizPreloadHandlers() map[string]func(*gorm.DB) *gorm.DB
 }
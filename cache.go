@@ -3,6 +3,8 @@ package kuu
 import (
 	"encoding/binary"
 	"github.com/mojocn/base64Captcha"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -21,23 +23,129 @@ type Cache interface {
 	Incr(string) int
 	Del(...string)
 	Close()
+	// Expire 键不存在时返回false，ttl小于等于0时删除键
+	Expire(string, time.Duration) bool
+	// TTL 键不存在时返回CacheTTLMissing，未设置过期时间时返回CacheTTLPersistent
+	TTL(string) time.Duration
+	SetNX(string, string, ...time.Duration) bool
+	IncrBy(string, int) int
+	Decr(string) int
+	HSet(string, string, string)
+	HGet(string, string) string
+	HGetAll(string) map[string]string
+	SAdd(string, ...string)
+	SRem(string, ...string)
+	SMembers(string) []string
+	ZAdd(string, ...CacheZMember)
+	// ZRange 按分数升序返回下标区间内的成员，支持负数下标
+	ZRange(string, int, int) []string
+	MGet(...string) []string
+	MSet(map[string]string)
 }
 
+const (
+	// CacheTTLPersistent 键存在但未设置过期时间
+	CacheTTLPersistent time.Duration = -1
+	// CacheTTLMissing 键不存在
+	CacheTTLMissing time.Duration = -2
+)
+
+// CacheZMember 有序集合成员
+type CacheZMember struct {
+	Score  float64
+	Member string
+}
+
+// zrange 按分数（相同时按成员）升序排序后返回下标start至stop（包含）的成员，负数下标从末尾计算
+func zrange(list []CacheZMember, start, stop int) []string {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score == list[j].Score {
+			return list[i].Member < list[j].Member
+		}
+		return list[i].Score < list[j].Score
+	})
+	members := make([]string, 0)
+	n := len(list)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	for i := start; i <= stop; i++ {
+		members = append(members, list[i].Member)
+	}
+	return members
+}
+
+// CacheFactory 创建缓存实现
+type CacheFactory func() Cache
+
+var (
+	cacheBackends = map[string]CacheFactory{
+		"redis":  func() Cache { return NewCacheRedis() },
+		"bolt":   func() Cache { return NewCacheBolt() },
+		"memory": func() Cache { return NewCacheMemory() },
+	}
+	cacheBackendsMu sync.RWMutex
+)
+
 func init() {
+	// 自定义实现在应用代码中注册，此时可能尚未注册，将在RegisterCacheBackend时初始化
+	if factory := getCacheBackend(cacheBackendName()); factory != nil {
+		SetDefaultCache(factory())
+	}
+}
+
+// cacheBackendName 优先使用cache:backend，未配置时配置了redis则使用redis，否则使用bolt
+func cacheBackendName() string {
+	if name := C().GetString("cache:backend"); name != "" {
+		return name
+	}
 	if C().Has("redis") {
-		// 初始化redis
-		DefaultCache = NewCacheRedis()
-	} else {
-		// 初始化bolt
-		DefaultCache = NewCacheBolt()
+		return "redis"
 	}
-	// 初始化验证码存储器
-	if DefaultCache != nil {
-		base64Captcha.SetCustomStore(&captchaStore{})
+	return "bolt"
+}
+
+func getCacheBackend(name string) CacheFactory {
+	cacheBackendsMu.RLock()
+	defer cacheBackendsMu.RUnlock()
+	return cacheBackends[name]
+}
+
+// RegisterCacheBackend 注册缓存实现，cache:backend配置为该名称时用作DefaultCache
+func RegisterCacheBackend(name string, factory CacheFactory) {
+	cacheBackendsMu.Lock()
+	cacheBackends[name] = factory
+	cacheBackendsMu.Unlock()
+	if DefaultCache == nil && cacheBackendName() == name {
+		SetDefaultCache(factory())
 	}
 }
 
+// SetDefaultCache 替换DefaultCache，原有实现需自行关闭
+func SetDefaultCache(c Cache) {
+	unsubscribeL1Invalidation()
+	DefaultCache = c
+	if c == nil {
+		return
+	}
+	if v, ok := c.(*CacheRedis); ok {
+		subscribeL1Invalidation(v)
+	}
+	// 初始化验证码存储器
+	base64Captcha.SetCustomStore(&captchaStore{})
+}
+
 func releaseCacheDB() {
+	unsubscribeL1Invalidation()
 	if DefaultCache != nil {
 		DefaultCache.Close()
 	}
@@ -49,8 +157,9 @@ func itob(v int) []byte {
 	return b
 }
 
+// btoi 仅解析8字节大端序整数，其他长度返回0
 func btoi(b []byte) (v int) {
-	if b != nil {
+	if len(b) == 8 {
 		v = int(binary.BigEndian.Uint64(b))
 	}
 	return
@@ -67,6 +176,11 @@ func SetCacheString(key, val string, expiration ...time.Duration) {
 func GetCacheString(key string) (val string) {
 	if DefaultCache != nil {
 		val = DefaultCache.GetString(key)
+		if val != "" {
+			cacheRequestsTotal.Inc("hit")
+		} else {
+			cacheRequestsTotal.Inc("miss")
+		}
 	}
 	return
 }
@@ -94,6 +208,14 @@ func IncrCache(key string) (val int) {
 	return
 }
 
+// IncrByCache
+func IncrByCache(key string, step int) (val int) {
+	if DefaultCache != nil {
+		val = DefaultCache.IncrBy(key, step)
+	}
+	return
+}
+
 // HasPrefixCache
 func HasPrefixCache(key string, limit int) (val map[string]string) {
 	if DefaultCache != nil {
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/boltdb/bolt"
+	"math"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -11,47 +14,200 @@ import (
 type CacheBolt struct {
 	db                *bolt.DB
 	generalBucketName []byte
+	expiresBucketName []byte
+	hashBucketName    []byte
+	setBucketName     []byte
+	zsetBucketName    []byte
+	locker            *MemoryLocker
+	stop              chan struct{}
+	stopOnce          sync.Once
 }
 
-// NewCacheBolt
+// NewCacheBolt 文件路径为bolt:path，默认为工作目录下的cache.db
 func NewCacheBolt() *CacheBolt {
-	db, err := bolt.Open("cache.db", 0600, nil)
+	return newCacheBolt(C().DefaultGetString("bolt:path", "cache.db"))
+}
+
+func newCacheBolt(path string) *CacheBolt {
+	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		FATAL(err)
 	}
-	return &CacheBolt{db, []byte("general")}
+	c := &CacheBolt{
+		db:                db,
+		generalBucketName: []byte("general"),
+		expiresBucketName: []byte("expires"),
+		hashBucketName:    []byte("hash"),
+		setBucketName:     []byte("set"),
+		zsetBucketName:    []byte("zset"),
+		locker:            NewMemoryLocker(),
+		stop:              make(chan struct{}),
+	}
+	// bolt独占文件，仅支持单节点，fencing token持久化以保证重启后仍然递增
+	c.locker.tokenFunc = func(key string) int64 {
+		return int64(c.Incr(fmt.Sprintf("lock_fencing_%s", key)))
+	}
+	// 定期清理过期键（单位：秒，默认60，小于等于0表示不清理）
+	if interval := C().DefaultGetInt("bolt:evictInterval", 60); interval > 0 {
+		go c.evictLoop(time.Duration(interval) * time.Second)
+	}
+	return c
 }
 
-// SetString
-func (c *CacheBolt) SetString(key, val string, expiration ...time.Duration) {
+func (c *CacheBolt) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.evictExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// evictExpired 删除所有已过期的键
+func (c *CacheBolt) evictExpired() {
+	now := time.Now().UnixNano()
 	ERROR(c.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(c.generalBucketName)
-		if err != nil {
-			return err
+		expires := tx.Bucket(c.expiresBucketName)
+		if expires == nil {
+			return nil
+		}
+		var keys [][]byte
+		cursor := expires.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if btoi(v) <= int(now) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
 		}
-		return bucket.Put([]byte(key), []byte(val))
+		for _, key := range keys {
+			if err := c.deleteKey(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
 	}))
 }
 
-// GetString
-func (c *CacheBolt) GetString(key string) (val string) {
+// deleteKey 删除键的值、过期时间以及哈希、集合、有序集合数据
+func (c *CacheBolt) deleteKey(tx *bolt.Tx, key []byte) error {
+	for _, name := range [][]byte{c.generalBucketName, c.expiresBucketName} {
+		if bucket := tx.Bucket(name); bucket != nil {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range [][]byte{c.hashBucketName, c.setBucketName, c.zsetBucketName} {
+		if parent := tx.Bucket(name); parent != nil && parent.Bucket(key) != nil {
+			if err := parent.DeleteBucket(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// purgeExpired 写入前删除已过期的键，与redis的惰性删除一致
+func (c *CacheBolt) purgeExpired(tx *bolt.Tx, key []byte) error {
+	if c.expired(tx, key) {
+		return c.deleteKey(tx, key)
+	}
+	return nil
+}
+
+func (c *CacheBolt) exists(tx *bolt.Tx, key []byte) bool {
+	if c.expired(tx, key) {
+		return false
+	}
+	if bucket := tx.Bucket(c.generalBucketName); bucket != nil && bucket.Get(key) != nil {
+		return true
+	}
+	for _, name := range [][]byte{c.hashBucketName, c.setBucketName, c.zsetBucketName} {
+		if parent := tx.Bucket(name); parent != nil && parent.Bucket(key) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// expired 过期时间与值分开存储，未设置过期时间的键永不过期
+func (c *CacheBolt) expired(tx *bolt.Tx, key []byte) bool {
+	expires := tx.Bucket(c.expiresBucketName)
+	if expires == nil {
+		return false
+	}
+	v := expires.Get(key)
+	return v != nil && btoi(v) <= int(time.Now().UnixNano())
+}
+
+// put 写入值并同步过期时间，与redis的SET一致，未指定过期时间时清除原有过期时间
+func (c *CacheBolt) put(key string, val []byte, expiration []time.Duration) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return c.putTx(tx, []byte(key), val, expiration)
+	})
+}
+
+func (c *CacheBolt) putTx(tx *bolt.Tx, key, val []byte, expiration []time.Duration) error {
+	bucket, err := tx.CreateBucketIfNotExists(c.generalBucketName)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(key, val); err != nil {
+		return err
+	}
+	if len(expiration) > 0 && expiration[0] > 0 {
+		return c.setExpiration(tx, key, expiration[0])
+	}
+	if expires := tx.Bucket(c.expiresBucketName); expires != nil {
+		return expires.Delete(key)
+	}
+	return nil
+}
+
+func (c *CacheBolt) setExpiration(tx *bolt.Tx, key []byte, ttl time.Duration) error {
+	expires, err := tx.CreateBucketIfNotExists(c.expiresBucketName)
+	if err != nil {
+		return err
+	}
+	return expires.Put(key, itob(int(time.Now().Add(ttl).UnixNano())))
+}
+
+func (c *CacheBolt) get(key string) (val []byte) {
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			val = string(bucket.Get([]byte(key)))
+		if bucket != nil && !c.expired(tx, []byte(key)) {
+			val = bucket.Get([]byte(key))
 		}
 		return nil
 	}))
 	return
 }
 
+// SetString
+func (c *CacheBolt) SetString(key, val string, expiration ...time.Duration) {
+	defer startCacheSpan("bolt", "SetString", key).End()
+	ERROR(c.put(key, []byte(val), expiration))
+}
+
+// GetString
+func (c *CacheBolt) GetString(key string) (val string) {
+	defer startCacheSpan("bolt", "GetString", key).End()
+	return string(c.get(key))
+}
+
 func (c *CacheBolt) seek(seek []byte, limit int, f func(k, v []byte) bool) (values map[string]string) {
 	values = make(map[string]string)
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
 		if bucket != nil {
-			c := bucket.Cursor()
-			for k, v := c.Seek(seek); k != nil && f(k, v); k, v = c.Next() {
+			cursor := bucket.Cursor()
+			for k, v := cursor.Seek(seek); k != nil && f(k, v); k, v = cursor.Next() {
+				if c.expired(tx, k) {
+					continue
+				}
 				values[string(k)] = string(v)
 				if limit > 0 && len(values) >= limit {
 					break
@@ -65,6 +221,7 @@ func (c *CacheBolt) seek(seek []byte, limit int, f func(k, v []byte) bool) (valu
 
 // HasPrefix
 func (c *CacheBolt) HasPrefix(prefix string, limit int) (values map[string]string) {
+	defer startCacheSpan("bolt", "HasPrefix", prefix).End()
 	if len(prefix) == 0 {
 		return
 	}
@@ -76,6 +233,7 @@ func (c *CacheBolt) HasPrefix(prefix string, limit int) (values map[string]strin
 
 // HasSuffix
 func (c *CacheBolt) HasSuffix(suffix string, limit int) (values map[string]string) {
+	defer startCacheSpan("bolt", "HasSuffix", suffix).End()
 	if len(suffix) == 0 {
 		return
 	}
@@ -87,6 +245,7 @@ func (c *CacheBolt) HasSuffix(suffix string, limit int) (values map[string]strin
 
 // Contains
 func (c *CacheBolt) Contains(pattern string, limit int) (values map[string]string) {
+	defer startCacheSpan("bolt", "Contains", pattern).End()
 	if len(pattern) == 0 {
 		return
 	}
@@ -96,67 +255,344 @@ func (c *CacheBolt) Contains(pattern string, limit int) (values map[string]strin
 	})
 }
 
+// boltInt 解析十进制整数（与redis一致），兼容旧版本以8字节大端序存储的整数
+func boltInt(v []byte) int {
+	if n, err := strconv.Atoi(string(v)); err == nil {
+		return n
+	}
+	return btoi(v)
+}
+
 // SetInt
 func (c *CacheBolt) SetInt(key string, val int, expiration ...time.Duration) {
+	defer startCacheSpan("bolt", "SetInt", key).End()
+	ERROR(c.put(key, []byte(strconv.Itoa(val)), expiration))
+}
+
+// GetInt
+func (c *CacheBolt) GetInt(key string) (val int) {
+	defer startCacheSpan("bolt", "GetInt", key).End()
+	return boltInt(c.get(key))
+}
+
+// Incr
+func (c *CacheBolt) Incr(key string) (val int) {
+	return c.IncrBy(key, 1)
+}
+
+// IncrBy 计数保留原有过期时间，兼容旧版本存储在incr_<key>中的计数
+func (c *CacheBolt) IncrBy(key string, step int) (val int) {
+	defer startCacheSpan("bolt", "IncrBy", key).End()
 	ERROR(c.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if err := c.purgeExpired(tx, k); err != nil {
+			return err
+		}
 		bucket, err := tx.CreateBucketIfNotExists(c.generalBucketName)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(key), itob(val))
+		if v := bucket.Get(k); v != nil {
+			val = boltInt(v)
+		} else if legacyName := []byte(fmt.Sprintf("incr_%s", key)); tx.Bucket(legacyName) != nil {
+			val = int(tx.Bucket(legacyName).Sequence())
+			if err := tx.DeleteBucket(legacyName); err != nil {
+				return err
+			}
+		}
+		val += step
+		return bucket.Put(k, []byte(strconv.Itoa(val)))
 	}))
+	return
 }
 
-// GetInt
-func (c *CacheBolt) GetInt(key string) (val int) {
+// Decr
+func (c *CacheBolt) Decr(key string) int {
+	return c.IncrBy(key, -1)
+}
+
+// Del
+func (c *CacheBolt) Del(keys ...string) {
+	defer startCacheSpan("bolt", "Del", keys...).End()
+	if len(keys) == 0 {
+		return
+	}
+	ERROR(c.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if err := c.deleteKey(tx, []byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	return
+}
+
+// Expire
+func (c *CacheBolt) Expire(key string, ttl time.Duration) (ok bool) {
+	defer startCacheSpan("bolt", "Expire", key).End()
+	ERROR(c.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if ok = c.exists(tx, k); !ok {
+			return nil
+		}
+		if ttl <= 0 {
+			return c.deleteKey(tx, k)
+		}
+		return c.setExpiration(tx, k, ttl)
+	}))
+	return
+}
+
+// TTL
+func (c *CacheBolt) TTL(key string) (ttl time.Duration) {
+	defer startCacheSpan("bolt", "TTL", key).End()
+	ttl = CacheTTLMissing
+	ERROR(c.db.View(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if !c.exists(tx, k) {
+			return nil
+		}
+		ttl = CacheTTLPersistent
+		if expires := tx.Bucket(c.expiresBucketName); expires != nil {
+			if v := expires.Get(k); v != nil {
+				ttl = time.Duration(btoi(v) - int(time.Now().UnixNano()))
+			}
+		}
+		return nil
+	}))
+	return
+}
+
+// SetNX
+func (c *CacheBolt) SetNX(key, val string, expiration ...time.Duration) (ok bool) {
+	defer startCacheSpan("bolt", "SetNX", key).End()
+	ERROR(c.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if c.exists(tx, k) {
+			return nil
+		}
+		if err := c.deleteKey(tx, k); err != nil {
+			return err
+		}
+		ok = true
+		return c.putTx(tx, k, []byte(val), expiration)
+	}))
+	return
+}
+
+// MGet
+func (c *CacheBolt) MGet(keys ...string) (values []string) {
+	defer startCacheSpan("bolt", "MGet", keys...).End()
+	values = make([]string, len(keys))
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			val = btoi(bucket.Get([]byte(key)))
+		if bucket == nil {
+			return nil
+		}
+		for i, key := range keys {
+			if !c.expired(tx, []byte(key)) {
+				values[i] = string(bucket.Get([]byte(key)))
+			}
 		}
 		return nil
 	}))
 	return
 }
 
-// Incr
-func (c *CacheBolt) Incr(key string) (val int) {
+// MSet
+func (c *CacheBolt) MSet(values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	defer startCacheSpan("bolt", "MSet", keys...).End()
 	ERROR(c.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("incr_%s", key)))
+		for key, val := range values {
+			if err := c.putTx(tx, []byte(key), []byte(val), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// update 在键对应的子bucket中写入，子bucket为空时删除该键
+func (c *CacheBolt) update(parentName []byte, key string, f func(*bolt.Bucket) error) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if err := c.purgeExpired(tx, k); err != nil {
+			return err
+		}
+		parent, err := tx.CreateBucketIfNotExists(parentName)
 		if err != nil {
 			return err
 		}
-		v, err := bucket.NextSequence()
+		bucket, err := parent.CreateBucketIfNotExists(k)
 		if err != nil {
 			return err
 		}
-		val = int(v)
+		if err := f(bucket); err != nil {
+			return err
+		}
+		if k, _ := bucket.Cursor().First(); k == nil {
+			return c.deleteKey(tx, []byte(key))
+		}
+		return nil
+	})
+}
+
+// view 读取键对应的子bucket，键不存在或已过期时不调用f
+func (c *CacheBolt) view(parentName []byte, key string, f func(*bolt.Bucket)) {
+	ERROR(c.db.View(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if c.expired(tx, k) {
+			return nil
+		}
+		if parent := tx.Bucket(parentName); parent != nil {
+			if bucket := parent.Bucket(k); bucket != nil {
+				f(bucket)
+			}
+		}
 		return nil
 	}))
+}
+
+// HSet
+func (c *CacheBolt) HSet(key, field, val string) {
+	defer startCacheSpan("bolt", "HSet", key).End()
+	ERROR(c.update(c.hashBucketName, key, func(bucket *bolt.Bucket) error {
+		return bucket.Put([]byte(field), []byte(val))
+	}))
+}
+
+// HGet
+func (c *CacheBolt) HGet(key, field string) (val string) {
+	defer startCacheSpan("bolt", "HGet", key).End()
+	c.view(c.hashBucketName, key, func(bucket *bolt.Bucket) {
+		val = string(bucket.Get([]byte(field)))
+	})
 	return
 }
 
-// Del
-func (c *CacheBolt) Del(keys ...string) {
-	if len(keys) == 0 {
+// HGetAll
+func (c *CacheBolt) HGetAll(key string) (values map[string]string) {
+	defer startCacheSpan("bolt", "HGetAll", key).End()
+	values = make(map[string]string)
+	c.view(c.hashBucketName, key, func(bucket *bolt.Bucket) {
+		ERROR(bucket.ForEach(func(k, v []byte) error {
+			values[string(k)] = string(v)
+			return nil
+		}))
+	})
+	return
+}
+
+// SAdd
+func (c *CacheBolt) SAdd(key string, members ...string) {
+	defer startCacheSpan("bolt", "SAdd", key).End()
+	if len(members) == 0 {
 		return
 	}
-	ERROR(c.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			for _, key := range keys {
-				if err := bucket.Delete([]byte(key)); err != nil {
-					return err
-				}
+	ERROR(c.update(c.setBucketName, key, func(bucket *bolt.Bucket) error {
+		for _, member := range members {
+			if err := bucket.Put([]byte(member), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// SRem
+func (c *CacheBolt) SRem(key string, members ...string) {
+	defer startCacheSpan("bolt", "SRem", key).End()
+	if len(members) == 0 {
+		return
+	}
+	ERROR(c.update(c.setBucketName, key, func(bucket *bolt.Bucket) error {
+		for _, member := range members {
+			if err := bucket.Delete([]byte(member)); err != nil {
+				return err
 			}
 		}
 		return nil
 	}))
+}
+
+// SMembers
+func (c *CacheBolt) SMembers(key string) (members []string) {
+	defer startCacheSpan("bolt", "SMembers", key).End()
+	members = make([]string, 0)
+	c.view(c.setBucketName, key, func(bucket *bolt.Bucket) {
+		ERROR(bucket.ForEach(func(k, _ []byte) error {
+			members = append(members, string(k))
+			return nil
+		}))
+	})
 	return
 }
 
+// ZAdd
+func (c *CacheBolt) ZAdd(key string, members ...CacheZMember) {
+	defer startCacheSpan("bolt", "ZAdd", key).End()
+	if len(members) == 0 {
+		return
+	}
+	ERROR(c.update(c.zsetBucketName, key, func(bucket *bolt.Bucket) error {
+		for _, member := range members {
+			if err := bucket.Put([]byte(member.Member), itob(int(math.Float64bits(member.Score)))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// ZRange 按分数升序返回下标start至stop（包含）的成员，支持负数下标
+func (c *CacheBolt) ZRange(key string, start, stop int) []string {
+	defer startCacheSpan("bolt", "ZRange", key).End()
+	var list []CacheZMember
+	c.view(c.zsetBucketName, key, func(bucket *bolt.Bucket) {
+		ERROR(bucket.ForEach(func(k, v []byte) error {
+			list = append(list, CacheZMember{Member: string(k), Score: math.Float64frombits(uint64(btoi(v)))})
+			return nil
+		}))
+	})
+	return zrange(list, start, stop)
+}
+
+// TryLock
+func (c *CacheBolt) TryLock(key string, ttl time.Duration) (*Lock, error) {
+	lock, err := c.locker.TryLock(key, ttl)
+	if lock != nil {
+		lock.owner = c
+	}
+	return lock, err
+}
+
+// Unlock
+func (c *CacheBolt) Unlock(lock *Lock) error {
+	return c.locker.Unlock(lock)
+}
+
+// Refresh
+func (c *CacheBolt) Refresh(lock *Lock, ttl time.Duration) error {
+	return c.locker.Refresh(lock, ttl)
+}
+
+// Ping
+func (c *CacheBolt) Ping() error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
 // Close
 func (c *CacheBolt) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
 	if c.db != nil {
 		ERROR(c.db.Close())
 	}
@@ -0,0 +1,106 @@
+package kuu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func newTestCacheBolt(t *testing.T) (*CacheBolt, func()) {
+	dir, err := ioutil.TempDir("", "kuu-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCacheBolt(filepath.Join(dir, "cache.db"))
+	return c, func() {
+		c.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestCacheBoltExpiration(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	c.SetString("captcha_a", "1234", 50*time.Millisecond)
+	c.SetString("captcha_b", "5678")
+	c.SetInt("login_root_failed_times", 3, 50*time.Millisecond)
+	if c.GetString("captcha_a") != "1234" || c.GetInt("login_root_failed_times") != 3 {
+		t.Fatal("values should be readable before expiration")
+	}
+	time.Sleep(80 * time.Millisecond)
+	if v := c.GetString("captcha_a"); v != "" {
+		t.Errorf("expired string should be missing, got %q", v)
+	}
+	if v := c.GetInt("login_root_failed_times"); v != 0 {
+		t.Errorf("expired int should be missing, got %d", v)
+	}
+	if values := c.HasPrefix("captcha_", 0); len(values) != 1 || values["captcha_b"] != "5678" {
+		t.Errorf("expired keys should be skipped by scans: %v", values)
+	}
+
+	c.evictExpired()
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(c.generalBucketName).Get([]byte("captcha_a")) != nil {
+			t.Error("expired key should be evicted")
+		}
+		if tx.Bucket(c.expiresBucketName).Stats().KeyN != 0 {
+			t.Error("expiry metadata should be evicted")
+		}
+		return nil
+	})
+}
+
+func TestCacheBoltOverwriteClearsExpiration(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	c.SetString("foo", "bar", 50*time.Millisecond)
+	c.SetString("foo", "baz")
+	time.Sleep(80 * time.Millisecond)
+	if v := c.GetString("foo"); v != "baz" {
+		t.Errorf("overwrite without expiration should persist, got %q", v)
+	}
+	c.SetString("foo", "qux", time.Minute)
+	c.Del("foo")
+	c.SetString("foo", "bar")
+	if v := c.GetString("foo"); v != "bar" {
+		t.Errorf("unexpected value after delete: %q", v)
+	}
+}
+
+func TestCacheBoltLegacyIncr(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	_ = c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("incr_lock_fencing_job"))
+		if err != nil {
+			return err
+		}
+		return bucket.SetSequence(7)
+	})
+	if v := c.Incr("lock_fencing_job"); v != 8 {
+		t.Errorf("legacy counter should continue from 7, got %d", v)
+	}
+	if v := c.GetInt("lock_fencing_job"); v != 8 {
+		t.Errorf("counter should be readable with GetInt, got %d", v)
+	}
+}
+
+func TestCacheBoltLegacyBinaryInt(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	// 旧版本以8字节大端序存储整数
+	_ = c.put("legacy_int", itob(9), nil)
+	if v := c.GetInt("legacy_int"); v != 9 {
+		t.Errorf("legacy value should be readable with GetInt, got %d", v)
+	}
+	if v := c.Incr("legacy_int"); v != 10 {
+		t.Errorf("legacy value should continue from 9, got %d", v)
+	}
+	if v := c.GetString("legacy_int"); v != "10" {
+		t.Errorf("counter should be stored as a decimal string, got %q", v)
+	}
+}
@@ -0,0 +1,267 @@
+package kuu
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultL1Cache 进程内一级缓存，位于DefaultCache之前
+var DefaultL1Cache = NewL1Cache(C().DefaultGetInt("l1Cache:size", 10000), time.Duration(C().DefaultGetInt("l1Cache:ttl", 60))*time.Second)
+
+var (
+	l1NodeID     = uuid.NewV4().String()
+	l1PubSub     *redis.PubSub
+	l1PubSubLock sync.Mutex
+)
+
+// L1Cache 带过期时间的LRU缓存，固定条目不会被淘汰，在删除或到达各自的过期时间时失效
+type L1Cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	ll      *list.List
+	items   map[string]*list.Element
+	pinned  map[string]*l1Entry
+	version uint64
+}
+
+type l1Entry struct {
+	key      string
+	value    interface{}
+	expireAt time.Time
+}
+
+// l1Invalidation 跨节点失效消息
+type l1Invalidation struct {
+	Node     string
+	Keys     []string `json:",omitempty"`
+	Prefixes []string `json:",omitempty"`
+}
+
+// NewL1Cache size小于等于0时不限制条目数，ttl小于等于0时条目不过期
+func NewL1Cache(size int, ttl time.Duration) *L1Cache {
+	return &L1Cache{
+		size:   size,
+		ttl:    ttl,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+		pinned: make(map[string]*l1Entry),
+	}
+}
+
+// Get
+func (c *L1Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.pinned[key]; ok {
+		if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+			delete(c.pinned, key)
+			return nil, false
+		}
+		return entry.value, true
+	}
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*l1Entry)
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Set 未指定过期时间时使用默认过期时间
+func (c *L1Cache) Set(key string, value interface{}, expiration ...time.Duration) {
+	ttl := c.ttl
+	if len(expiration) > 0 && expiration[0] > 0 {
+		ttl = expiration[0]
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pinned, key)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*l1Entry)
+		entry.value = value
+		entry.expireAt = expireAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&l1Entry{key: key, value: value, expireAt: expireAt})
+	if c.size > 0 && c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Version 每次删除后递增，用于判断加载期间是否发生过失效
+func (c *L1Cache) Version() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Pin 写入固定条目，ttl小于等于0时不过期（跨节点失效消息丢失时一直使用旧值）；version之后发生过删除时放弃写入并返回false
+func (c *L1Cache) Pin(key string, value interface{}, version uint64, ttl time.Duration) bool {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != version {
+		return false
+	}
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.pinned[key] = &l1Entry{key: key, value: value, expireAt: expireAt}
+	return true
+}
+
+// Del
+func (c *L1Cache) Del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	for _, key := range keys {
+		delete(c.pinned, key)
+		if elem, ok := c.items[key]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// DelPrefix
+func (c *L1Cache) DelPrefix(prefixes ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	for key := range c.pinned {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				delete(c.pinned, key)
+				break
+			}
+		}
+	}
+	for key, elem := range c.items {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				c.removeElement(elem)
+				break
+			}
+		}
+	}
+}
+
+// Len
+func (c *L1Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len() + len(c.pinned)
+}
+
+// Purge
+func (c *L1Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.pinned = make(map[string]*l1Entry)
+}
+
+func (c *L1Cache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*l1Entry).key)
+}
+
+// InvalidateL1 删除本节点的一级缓存，并通过redis通知其他节点
+func InvalidateL1(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	DefaultL1Cache.Del(keys...)
+	publishL1Invalidation(&l1Invalidation{Node: l1NodeID, Keys: keys})
+}
+
+// InvalidateL1Prefix 按前缀删除本节点的一级缓存，并通过redis通知其他节点
+func InvalidateL1Prefix(prefixes ...string) {
+	if len(prefixes) == 0 {
+		return
+	}
+	DefaultL1Cache.DelPrefix(prefixes...)
+	publishL1Invalidation(&l1Invalidation{Node: l1NodeID, Prefixes: prefixes})
+}
+
+// DelTieredCache 同时删除一级缓存和DefaultCache中的键
+func DelTieredCache(keys ...string) {
+	DelCache(keys...)
+	InvalidateL1(keys...)
+}
+
+func l1InvalidationChannel() string {
+	return BuildKey("l1_invalidation")
+}
+
+func publishL1Invalidation(msg *l1Invalidation) {
+	if v, ok := DefaultCache.(*CacheRedis); ok {
+		if err := v.client.Publish(l1InvalidationChannel(), JSONStringify(msg)).Err(); err != nil {
+			ERROR("发布缓存失效消息失败：%s", err.Error())
+		}
+	}
+}
+
+// handleL1Invalidation 忽略本节点发出的消息
+func handleL1Invalidation(payload string) {
+	var msg l1Invalidation
+	if err := JSONParse(payload, &msg); err != nil {
+		ERROR("解析缓存失效消息失败：%s", err.Error())
+		return
+	}
+	if msg.Node == l1NodeID {
+		return
+	}
+	if len(msg.Keys) > 0 {
+		DefaultL1Cache.Del(msg.Keys...)
+	}
+	if len(msg.Prefixes) > 0 {
+		DefaultL1Cache.DelPrefix(msg.Prefixes...)
+	}
+}
+
+// subscribeL1Invalidation 订阅其他节点的缓存失效消息，bolt仅支持单节点故无需订阅
+func subscribeL1Invalidation(c *CacheRedis) {
+	l1PubSubLock.Lock()
+	defer l1PubSubLock.Unlock()
+	if l1PubSub != nil {
+		return
+	}
+	l1PubSub = c.client.Subscribe(l1InvalidationChannel())
+	ch := l1PubSub.Channel()
+	go func() {
+		for msg := range ch {
+			handleL1Invalidation(msg.Payload)
+		}
+	}()
+}
+
+func unsubscribeL1Invalidation() {
+	l1PubSubLock.Lock()
+	defer l1PubSubLock.Unlock()
+	if l1PubSub != nil {
+		ERROR(l1PubSub.Close())
+		l1PubSub = nil
+	}
+}
@@ -0,0 +1,97 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestL1Cache(t *testing.T) {
+	c := NewL1Cache(2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry should be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v.(int) != 1 {
+		t.Error("recently used entry should be kept")
+	}
+	c.Set("d", 4, 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("d"); ok {
+		t.Error("expired entry should be missing")
+	}
+	if c.Len() != 1 {
+		t.Errorf("expected 1 entry, got %d", c.Len())
+	}
+}
+
+func TestL1CacheInvalidation(t *testing.T) {
+	DefaultL1Cache.Set("user_1", 1)
+	DefaultL1Cache.Set("user_2", 2)
+	DefaultL1Cache.Set("language_messages", 3)
+	defer DefaultL1Cache.Purge()
+
+	handleL1Invalidation(JSONStringify(&l1Invalidation{Node: l1NodeID, Keys: []string{"user_1"}}))
+	if _, ok := DefaultL1Cache.Get("user_1"); !ok {
+		t.Error("messages from the same node should be ignored")
+	}
+	handleL1Invalidation(JSONStringify(&l1Invalidation{Node: "other", Keys: []string{"language_messages"}, Prefixes: []string{"user_"}}))
+	if DefaultL1Cache.Len() != 0 {
+		t.Errorf("expected all entries to be invalidated, got %d", DefaultL1Cache.Len())
+	}
+}
+
+func TestL1CachePin(t *testing.T) {
+	c := NewL1Cache(1, 20*time.Millisecond)
+	if !c.Pin("pinned", 1, c.Version(), 0) {
+		t.Fatal("pin should succeed without deletions")
+	}
+	c.Set("a", 2)
+	c.Set("b", 3)
+	time.Sleep(40 * time.Millisecond)
+	if v, ok := c.Get("pinned"); !ok || v.(int) != 1 {
+		t.Error("pinned entry should neither expire nor be evicted")
+	}
+	c.Del("pinned")
+	if _, ok := c.Get("pinned"); ok {
+		t.Error("pinned entry should be removed by Del")
+	}
+
+	version := c.Version()
+	c.Del("other")
+	if c.Pin("pinned", 1, version, 0) {
+		t.Error("pin should be skipped after an invalidation during loading")
+	}
+
+	if !c.Pin("expiring", 1, c.Version(), 20*time.Millisecond) {
+		t.Fatal("pin should succeed without deletions")
+	}
+	c.Set("c", 4)
+	if _, ok := c.Get("expiring"); !ok {
+		t.Error("pinned entry should not be evicted before its ttl")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("expiring"); ok {
+		t.Error("pinned entry should expire after its ttl")
+	}
+}
+
+func TestSignSecretInvalidation(t *testing.T) {
+	defer DefaultL1Cache.Purge()
+	DefaultL1Cache.Set(signSecretCacheKey("a"), SignSecret{Token: "a"})
+	DefaultL1Cache.Set(signSecretCacheKey("b"), SignSecret{Token: "b"})
+
+	_ = (&SignSecret{Token: "a"}).AfterSave(nil)
+	if _, ok := DefaultL1Cache.Get(signSecretCacheKey("a")); ok {
+		t.Error("saved secret should be invalidated")
+	}
+	if _, ok := DefaultL1Cache.Get(signSecretCacheKey("b")); !ok {
+		t.Error("other secrets should be kept")
+	}
+	_ = (&SignSecret{}).AfterDelete(nil)
+	if _, ok := DefaultL1Cache.Get(signSecretCacheKey("b")); ok {
+		t.Error("deleting by condition should invalidate all secrets")
+	}
+}
@@ -0,0 +1,149 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrCacheNotFound loader返回该错误或gorm.ErrRecordNotFound时缓存空结果
+var ErrCacheNotFound = errors.New("cache: not found")
+
+// cacheNegativeValue 空结果占位值
+const cacheNegativeValue = "\x00kuu:not_found"
+
+var (
+	cacheLoads     = &cacheLoadGroup{calls: make(map[string]*cacheLoadCall)}
+	cacheLoadStats CacheLoadStats
+)
+
+// cacheVersionDeps 模型变更时需递增的缓存版本，版本号作为缓存key的一部分
+var cacheVersionDeps = map[string][]string{
+	"User":                {"privileges"},
+	"Org":                 {"privileges"},
+	"Role":                {"privileges"},
+	"RoleAssign":          {"privileges"},
+	"OperationPrivileges": {"privileges"},
+	"DataPrivileges":      {"privileges"},
+	"Param":               {"param"},
+}
+
+// CacheLoadStats GetOrLoad命中统计
+type CacheLoadStats struct {
+	Hits         int64
+	NegativeHits int64
+	Misses       int64
+	Shared       int64
+	LoadErrors   int64
+}
+
+type cacheLoadCall struct {
+	wg  sync.WaitGroup
+	val string
+	err error
+}
+
+// cacheLoadGroup 合并同一key的并发加载
+type cacheLoadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*cacheLoadCall
+}
+
+// do 返回的leader表示是否由当前调用执行了fn
+func (g *cacheLoadGroup) do(key string, fn func() (string, error)) (val string, err error, leader bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, false
+	}
+	call := new(cacheLoadCall)
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.val, call.err = fn()
+	return call.val, call.err, true
+}
+
+// GetOrLoad 优先从DefaultCache读取并反序列化到dest，未命中时调用loader填充dest并写入缓存
+//
+// 同一key的并发加载只会执行一次loader；未找到数据时缓存空结果（时长为cache:negativeTTL秒，默认10）并返回ErrCacheNotFound
+func GetOrLoad(key string, ttl time.Duration, dest interface{}, loader func(dest interface{}) error) error {
+	if val := GetCacheString(key); val != "" {
+		if val == cacheNegativeValue {
+			atomic.AddInt64(&cacheLoadStats.NegativeHits, 1)
+			cacheLoadsTotal.Inc("negative_hit")
+			return ErrCacheNotFound
+		}
+		if err := JSONParse(val, dest); err == nil {
+			atomic.AddInt64(&cacheLoadStats.Hits, 1)
+			cacheLoadsTotal.Inc("hit")
+			return nil
+		}
+	}
+	atomic.AddInt64(&cacheLoadStats.Misses, 1)
+	cacheLoadsTotal.Inc("miss")
+	val, err, leader := cacheLoads.do(key, func() (string, error) {
+		if err := loader(dest); err != nil {
+			if err == ErrCacheNotFound || gorm.IsRecordNotFoundError(err) {
+				negativeTTL := time.Duration(C().DefaultGetInt("cache:negativeTTL", 10)) * time.Second
+				if negativeTTL > 0 {
+					SetCacheString(key, cacheNegativeValue, negativeTTL)
+				}
+				return "", ErrCacheNotFound
+			}
+			atomic.AddInt64(&cacheLoadStats.LoadErrors, 1)
+			cacheLoadsTotal.Inc("error")
+			return "", err
+		}
+		val := JSONStringify(dest)
+		if val != "" {
+			SetCacheString(key, val, ttl)
+		}
+		return val, nil
+	})
+	if err != nil || leader {
+		return err
+	}
+	atomic.AddInt64(&cacheLoadStats.Shared, 1)
+	cacheLoadsTotal.Inc("shared")
+	return JSONParse(val, dest)
+}
+
+// GetCacheLoadStats
+func GetCacheLoadStats() CacheLoadStats {
+	return CacheLoadStats{
+		Hits:         atomic.LoadInt64(&cacheLoadStats.Hits),
+		NegativeHits: atomic.LoadInt64(&cacheLoadStats.NegativeHits),
+		Misses:       atomic.LoadInt64(&cacheLoadStats.Misses),
+		Shared:       atomic.LoadInt64(&cacheLoadStats.Shared),
+		LoadErrors:   atomic.LoadInt64(&cacheLoadStats.LoadErrors),
+	}
+}
+
+func cacheVersionKey(name string) string {
+	return fmt.Sprintf("cache_version_%s", name)
+}
+
+// cacheVersion
+func cacheVersion(name string) int {
+	return GetCacheInt(cacheVersionKey(name))
+}
+
+// bumpCacheVersions 递增依赖该模型的缓存版本，旧版本的缓存自然过期
+func bumpCacheVersions(modelName string) {
+	for _, name := range cacheVersionDeps[modelName] {
+		IncrCache(cacheVersionKey(name))
+	}
+}
@@ -0,0 +1,116 @@
+package kuu
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	prev := DefaultCache
+	DefaultCache = c
+	defer func() { DefaultCache = prev }()
+
+	type item struct {
+		Name string
+	}
+	var loads int32
+	loader := func(dest interface{}) error {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		dest.(*item).Name = "foo"
+		return nil
+	}
+	var wg sync.WaitGroup
+	results := make([]item, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := GetOrLoad("loader_item", time.Minute, &results[i], loader); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("concurrent loads should be deduplicated, got %d", loads)
+	}
+	for _, v := range results {
+		if v.Name != "foo" {
+			t.Fatalf("unexpected result: %+v", results)
+		}
+	}
+	before := GetCacheLoadStats()
+	var cached item
+	if err := GetOrLoad("loader_item", time.Minute, &cached, loader); err != nil || cached.Name != "foo" || loads != 1 {
+		t.Errorf("expected cache hit, got %+v %v", cached, err)
+	}
+	if GetCacheLoadStats().Hits != before.Hits+1 {
+		t.Error("hit should be counted")
+	}
+}
+
+func TestGetOrLoadNegative(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	prev := DefaultCache
+	DefaultCache = c
+	defer func() { DefaultCache = prev }()
+
+	var loads int
+	notFound := func(dest interface{}) error {
+		loads++
+		return gorm.ErrRecordNotFound
+	}
+	var dest struct{ Name string }
+	for i := 0; i < 2; i++ {
+		if err := GetOrLoad("loader_missing", time.Minute, &dest, notFound); err != ErrCacheNotFound {
+			t.Errorf("expected ErrCacheNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("not found result should be cached, got %d loads", loads)
+	}
+
+	loads = 0
+	failed := func(dest interface{}) error {
+		loads++
+		return errors.New("db down")
+	}
+	for i := 0; i < 2; i++ {
+		if err := GetOrLoad("loader_failed", time.Minute, &dest, failed); err == nil || err == ErrCacheNotFound {
+			t.Errorf("expected loader error, got %v", err)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("errors should not be cached, got %d loads", loads)
+	}
+}
+
+func TestBumpCacheVersionsAfterCommit(t *testing.T) {
+	defer setTestConfig("name", `"test"`)()
+	old := DefaultCache
+	DefaultCache = NewCacheMemory()
+	defer func() { DefaultCache = old }()
+
+	tx := newTestDB(t, nil, nil).Begin()
+	trackTransaction(tx.CommonDB())
+	modelChangeCallback(tx.NewScope(&Param{}))
+	if v := cacheVersion("param"); v != 1 {
+		t.Fatalf("expected version 1 before commit, got %d", v)
+	}
+	finishTransaction(tx.CommonDB(), true)
+	if v := cacheVersion("param"); v != 2 {
+		t.Errorf("expected version 2 after commit, got %d", v)
+	}
+	if v := cacheVersion("privileges"); v != 0 {
+		t.Errorf("unrelated versions should not change, got %d", v)
+	}
+}
@@ -0,0 +1,433 @@
+package kuu
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheMemory 进程内缓存，数据不持久化且不在节点间共享，适用于测试及单节点部署
+type CacheMemory struct {
+	mu       sync.Mutex
+	entries  map[string]*memoryEntry
+	locker   *MemoryLocker
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type memoryEntry struct {
+	value    string
+	hash     map[string]string
+	set      map[string]struct{}
+	zset     map[string]float64
+	expireAt time.Time
+}
+
+// NewCacheMemory
+func NewCacheMemory() *CacheMemory {
+	c := &CacheMemory{
+		entries: make(map[string]*memoryEntry),
+		locker:  NewMemoryLocker(),
+		stop:    make(chan struct{}),
+	}
+	// 定期清理过期键（单位：秒，默认60，小于等于0表示仅在访问时清理）
+	if interval := C().DefaultGetInt("memory:evictInterval", 60); interval > 0 {
+		go c.evictLoop(time.Duration(interval) * time.Second)
+	}
+	return c
+}
+
+func (c *CacheMemory) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.evictExpired()
+		}
+	}
+}
+
+func (c *CacheMemory) evictExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// entry 返回未过期的键，调用方需持有锁
+func (c *CacheMemory) entry(key string) *memoryEntry {
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.expired(time.Now()) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// put 覆盖写入字符串值，未指定过期时间时清除原有过期时间
+func (c *CacheMemory) put(key, val string, expiration []time.Duration) {
+	entry := &memoryEntry{value: val}
+	if len(expiration) > 0 && expiration[0] > 0 {
+		entry.expireAt = time.Now().Add(expiration[0])
+	}
+	c.entries[key] = entry
+}
+
+// SetString
+func (c *CacheMemory) SetString(key, val string, expiration ...time.Duration) {
+	defer startCacheSpan("memory", "SetString", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, val, expiration)
+}
+
+// GetString
+func (c *CacheMemory) GetString(key string) string {
+	defer startCacheSpan("memory", "GetString", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry := c.entry(key); entry != nil {
+		return entry.value
+	}
+	return ""
+}
+
+func (c *CacheMemory) scan(limit int, f func(k string) bool) (values map[string]string) {
+	values = make(map[string]string)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, entry := range c.entries {
+		if entry.expired(now) || entry.hash != nil || entry.set != nil || entry.zset != nil || !f(key) {
+			continue
+		}
+		values[key] = entry.value
+		if limit > 0 && len(values) >= limit {
+			break
+		}
+	}
+	return
+}
+
+// HasPrefix
+func (c *CacheMemory) HasPrefix(prefix string, limit int) (values map[string]string) {
+	defer startCacheSpan("memory", "HasPrefix", prefix).End()
+	if len(prefix) == 0 {
+		return
+	}
+	return c.scan(limit, func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// HasSuffix
+func (c *CacheMemory) HasSuffix(suffix string, limit int) (values map[string]string) {
+	defer startCacheSpan("memory", "HasSuffix", suffix).End()
+	if len(suffix) == 0 {
+		return
+	}
+	return c.scan(limit, func(k string) bool {
+		return strings.HasSuffix(k, suffix)
+	})
+}
+
+// Contains
+func (c *CacheMemory) Contains(pattern string, limit int) (values map[string]string) {
+	defer startCacheSpan("memory", "Contains", pattern).End()
+	if len(pattern) == 0 {
+		return
+	}
+	return c.scan(limit, func(k string) bool {
+		return strings.Contains(k, pattern)
+	})
+}
+
+// SetInt
+func (c *CacheMemory) SetInt(key string, val int, expiration ...time.Duration) {
+	defer startCacheSpan("memory", "SetInt", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, strconv.Itoa(val), expiration)
+}
+
+// GetInt
+func (c *CacheMemory) GetInt(key string) int {
+	val, _ := strconv.Atoi(c.GetString(key))
+	return val
+}
+
+// Incr
+func (c *CacheMemory) Incr(key string) int {
+	return c.IncrBy(key, 1)
+}
+
+// IncrBy 计数保留原有过期时间
+func (c *CacheMemory) IncrBy(key string, step int) int {
+	defer startCacheSpan("memory", "IncrBy", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil {
+		entry = &memoryEntry{}
+		c.entries[key] = entry
+	}
+	val, _ := strconv.Atoi(entry.value)
+	val += step
+	entry.value = strconv.Itoa(val)
+	return val
+}
+
+// Decr
+func (c *CacheMemory) Decr(key string) int {
+	return c.IncrBy(key, -1)
+}
+
+// Del
+func (c *CacheMemory) Del(keys ...string) {
+	defer startCacheSpan("memory", "Del", keys...).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+}
+
+// Expire
+func (c *CacheMemory) Expire(key string, ttl time.Duration) bool {
+	defer startCacheSpan("memory", "Expire", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil {
+		return false
+	}
+	if ttl <= 0 {
+		delete(c.entries, key)
+	} else {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+	return true
+}
+
+// TTL
+func (c *CacheMemory) TTL(key string) time.Duration {
+	defer startCacheSpan("memory", "TTL", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil {
+		return CacheTTLMissing
+	}
+	if entry.expireAt.IsZero() {
+		return CacheTTLPersistent
+	}
+	return time.Until(entry.expireAt)
+}
+
+// SetNX
+func (c *CacheMemory) SetNX(key, val string, expiration ...time.Duration) bool {
+	defer startCacheSpan("memory", "SetNX", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entry(key) != nil {
+		return false
+	}
+	c.put(key, val, expiration)
+	return true
+}
+
+// MGet
+func (c *CacheMemory) MGet(keys ...string) []string {
+	defer startCacheSpan("memory", "MGet", keys...).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		if entry := c.entry(key); entry != nil {
+			values[i] = entry.value
+		}
+	}
+	return values
+}
+
+// MSet
+func (c *CacheMemory) MSet(values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	defer startCacheSpan("memory", "MSet", keys...).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, val := range values {
+		c.put(key, val, nil)
+	}
+}
+
+// HSet
+func (c *CacheMemory) HSet(key, field, val string) {
+	defer startCacheSpan("memory", "HSet", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil || entry.hash == nil {
+		entry = &memoryEntry{hash: make(map[string]string)}
+		c.entries[key] = entry
+	}
+	entry.hash[field] = val
+}
+
+// HGet
+func (c *CacheMemory) HGet(key, field string) string {
+	defer startCacheSpan("memory", "HGet", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry := c.entry(key); entry != nil {
+		return entry.hash[field]
+	}
+	return ""
+}
+
+// HGetAll
+func (c *CacheMemory) HGetAll(key string) map[string]string {
+	defer startCacheSpan("memory", "HGetAll", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]string)
+	if entry := c.entry(key); entry != nil {
+		for field, val := range entry.hash {
+			values[field] = val
+		}
+	}
+	return values
+}
+
+// SAdd
+func (c *CacheMemory) SAdd(key string, members ...string) {
+	defer startCacheSpan("memory", "SAdd", key).End()
+	if len(members) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil || entry.set == nil {
+		entry = &memoryEntry{set: make(map[string]struct{})}
+		c.entries[key] = entry
+	}
+	for _, member := range members {
+		entry.set[member] = struct{}{}
+	}
+}
+
+// SRem 集合为空时删除该键
+func (c *CacheMemory) SRem(key string, members ...string) {
+	defer startCacheSpan("memory", "SRem", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil || entry.set == nil {
+		return
+	}
+	for _, member := range members {
+		delete(entry.set, member)
+	}
+	if len(entry.set) == 0 {
+		delete(c.entries, key)
+	}
+}
+
+// SMembers
+func (c *CacheMemory) SMembers(key string) []string {
+	defer startCacheSpan("memory", "SMembers", key).End()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members := make([]string, 0)
+	if entry := c.entry(key); entry != nil {
+		for member := range entry.set {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// ZAdd
+func (c *CacheMemory) ZAdd(key string, members ...CacheZMember) {
+	defer startCacheSpan("memory", "ZAdd", key).End()
+	if len(members) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(key)
+	if entry == nil || entry.zset == nil {
+		entry = &memoryEntry{zset: make(map[string]float64)}
+		c.entries[key] = entry
+	}
+	for _, member := range members {
+		entry.zset[member.Member] = member.Score
+	}
+}
+
+// ZRange 按分数升序返回下标start至stop（包含）的成员，支持负数下标
+func (c *CacheMemory) ZRange(key string, start, stop int) []string {
+	defer startCacheSpan("memory", "ZRange", key).End()
+	c.mu.Lock()
+	var list []CacheZMember
+	if entry := c.entry(key); entry != nil {
+		for member, score := range entry.zset {
+			list = append(list, CacheZMember{Member: member, Score: score})
+		}
+	}
+	c.mu.Unlock()
+	return zrange(list, start, stop)
+}
+
+// TryLock
+func (c *CacheMemory) TryLock(key string, ttl time.Duration) (*Lock, error) {
+	lock, err := c.locker.TryLock(key, ttl)
+	if lock != nil {
+		lock.owner = c
+	}
+	return lock, err
+}
+
+// Unlock
+func (c *CacheMemory) Unlock(lock *Lock) error {
+	return c.locker.Unlock(lock)
+}
+
+// Refresh
+func (c *CacheMemory) Refresh(lock *Lock, ttl time.Duration) error {
+	return c.locker.Refresh(lock, ttl)
+}
+
+// Ping
+func (c *CacheMemory) Ping() error {
+	return nil
+}
+
+// Close 停止过期清理并清空数据
+func (c *CacheMemory) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*memoryEntry)
+}
@@ -3,8 +3,10 @@ package kuu
 import (
 	"fmt"
 	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,6 +43,83 @@ func (c *CacheRedis) buildKeyAndExp(key string, expiration []time.Duration) (str
 	return key, exp
 }
 
+// RedisConfig redis配置，MasterName不为空时连接哨兵，配置了多个地址或Cluster为true时连接集群，超时时间单位为秒
+type RedisConfig struct {
+	Addr           string
+	Addrs          []string
+	MasterName     string
+	Cluster        bool
+	Password       string
+	DB             int
+	PoolSize       int
+	MinIdleConns   int
+	MaxRetries     int
+	MaxRedirects   int
+	ReadOnly       bool
+	RouteByLatency bool
+	RouteRandomly  bool
+	DialTimeout    int
+	ReadTimeout    int
+	WriteTimeout   int
+	PoolTimeout    int
+	IdleTimeout    int
+}
+
+// UniversalOptions 兼容单地址写法addr
+func (cfg *RedisConfig) UniversalOptions() *redis.UniversalOptions {
+	var addrs []string
+	if cfg.Addr != "" {
+		addrs = append(addrs, cfg.Addr)
+	}
+	for _, addr := range cfg.Addrs {
+		if addr != "" && addr != cfg.Addr {
+			addrs = append(addrs, addr)
+		}
+	}
+	return &redis.UniversalOptions{
+		Addrs:          addrs,
+		MasterName:     cfg.MasterName,
+		Password:       cfg.Password,
+		DB:             cfg.DB,
+		PoolSize:       cfg.PoolSize,
+		MinIdleConns:   cfg.MinIdleConns,
+		MaxRetries:     cfg.MaxRetries,
+		MaxRedirects:   cfg.MaxRedirects,
+		ReadOnly:       cfg.ReadOnly,
+		RouteByLatency: cfg.RouteByLatency,
+		RouteRandomly:  cfg.RouteRandomly,
+		DialTimeout:    time.Duration(cfg.DialTimeout) * time.Second,
+		ReadTimeout:    time.Duration(cfg.ReadTimeout) * time.Second,
+		WriteTimeout:   time.Duration(cfg.WriteTimeout) * time.Second,
+		PoolTimeout:    time.Duration(cfg.PoolTimeout) * time.Second,
+		IdleTimeout:    time.Duration(cfg.IdleTimeout) * time.Second,
+	}
+}
+
+// NewClient 集群只暴露单个地址（如云服务的代理地址）时需设置Cluster
+func (cfg *RedisConfig) NewClient() redis.UniversalClient {
+	opts := cfg.UniversalOptions()
+	if cfg.Cluster && cfg.MasterName == "" {
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:          opts.Addrs,
+			Password:       opts.Password,
+			PoolSize:       opts.PoolSize,
+			MinIdleConns:   opts.MinIdleConns,
+			MaxRetries:     opts.MaxRetries,
+			MaxRedirects:   opts.MaxRedirects,
+			ReadOnly:       opts.ReadOnly,
+			RouteByLatency: opts.RouteByLatency,
+			RouteRandomly:  opts.RouteRandomly,
+			DialTimeout:    opts.DialTimeout,
+			ReadTimeout:    opts.ReadTimeout,
+			WriteTimeout:   opts.WriteTimeout,
+			PoolTimeout:    opts.PoolTimeout,
+			IdleTimeout:    opts.IdleTimeout,
+		})
+	}
+	return redis.NewUniversalClient(opts)
+}
+
 // NewCacheRedis
 func NewCacheRedis() *CacheRedis {
 	GetAppName()
@@ -48,20 +127,21 @@ func NewCacheRedis() *CacheRedis {
 		c = &CacheRedis{}
 	)
 	// 解析配置
-	var opts redis.UniversalOptions
-	C().GetInterface("redis", &opts)
+	var cfg RedisConfig
+	C().GetInterface("redis", &cfg)
 	// 初始化客户端
-	cmd := redis.NewUniversalClient(&opts)
+	cmd := cfg.NewClient()
 	if _, err := cmd.Ping().Result(); err != nil {
 		PANIC(err)
 	}
 	c.client = cmd
-	connectedPrint(strings.Title("redis"), strings.Join(opts.Addrs, ","))
+	connectedPrint(strings.Title("redis"), strings.Join(cfg.UniversalOptions().Addrs, ","))
 	return c
 }
 
 // SetString
 func (c *CacheRedis) SetString(rawKey, val string, expiration ...time.Duration) {
+	defer startCacheSpan("redis", "SetString", rawKey).End()
 	var (
 		key, exp = c.buildKeyAndExp(rawKey, expiration)
 		status   = c.client.Set(key, val, exp)
@@ -73,6 +153,7 @@ func (c *CacheRedis) SetString(rawKey, val string, expiration ...time.Duration)
 
 // GetString
 func (c *CacheRedis) GetString(rawKey string) (val string) {
+	defer startCacheSpan("redis", "GetString", rawKey).End()
 	var (
 		key = BuildKey(rawKey)
 		cmd = c.client.Get(key)
@@ -85,51 +166,77 @@ func (c *CacheRedis) GetString(rawKey string) (val string) {
 	return
 }
 
-func (c *CacheRedis) scan(cursor uint64, pattern string, limit int64) (values map[string]string) {
+// scan 集群模式下SCAN仅返回单个节点的键，需在每个主节点上分别扫描
+func (c *CacheRedis) scan(pattern string, limit int64) (values map[string]string) {
+	cluster, ok := c.client.(*redis.ClusterClient)
+	if !ok {
+		return scanRedisNode(c.client, pattern, limit)
+	}
+	var mu sync.Mutex
+	values = make(map[string]string)
+	ERROR(cluster.ForEachMaster(func(client *redis.Client) error {
+		nodeValues := scanRedisNode(client, pattern, limit)
+		mu.Lock()
+		defer mu.Unlock()
+		for key, val := range nodeValues {
+			if limit > 0 && len(values) >= int(limit) {
+				break
+			}
+			values[key] = val
+		}
+		return nil
+	}))
+	return
+}
+
+// scanRedisNode limit小于等于0时不限制数量
+func scanRedisNode(client redis.Cmdable, pattern string, limit int64) (values map[string]string) {
+	var cursor uint64
 	values = make(map[string]string)
-	for len(values) < int(limit) {
-		cmd := c.client.Scan(cursor, pattern, limit)
+	for limit <= 0 || len(values) < int(limit) {
+		cmd := client.Scan(cursor, pattern, limit)
 		if err := cmd.Err(); err != nil {
 			ERROR(err)
 			return
 		}
-
-		if keys, nextCur := cmd.Val(); len(keys) > 0 {
-			for _, key := range keys {
-				values[key] = c.client.Get(key).Val()
-			}
-			if (limit != 0 && len(values) >= int(limit)) || nextCur == 0 {
+		keys, nextCur := cmd.Val()
+		for _, key := range keys {
+			values[key] = client.Get(key).Val()
+			if limit > 0 && len(values) >= int(limit) {
 				break
-			} else {
-				cursor = nextCur
 			}
-		} else {
+		}
+		if nextCur == 0 {
 			break
 		}
+		cursor = nextCur
 	}
 	return
 }
 
 // HasPrefix
 func (c *CacheRedis) HasPrefix(rawKey string, limit int) map[string]string {
+	defer startCacheSpan("redis", "HasPrefix", rawKey).End()
 	pattern := BuildKey(rawKey)
 	if !strings.HasSuffix(pattern, "*") {
 		pattern = fmt.Sprintf("%s*", pattern)
 	}
-	return c.scan(0, pattern, int64(limit))
+	return c.scan(pattern, int64(limit))
 }
 
 // HasSuffix
 func (c *CacheRedis) HasSuffix(rawKey string, limit int) map[string]string {
+	defer startCacheSpan("redis", "HasSuffix", rawKey).End()
 	pattern := BuildKey(rawKey)
 	if !strings.HasPrefix(pattern, "*") {
 		pattern = fmt.Sprintf("*%s", pattern)
 	}
-	return c.scan(0, pattern, int64(limit))
+	return c.scan(pattern, int64(limit))
 }
 
 // Contains
 func (c *CacheRedis) Contains(rawKey string, limit int) map[string]string {
+	defer startCacheSpan("redis", "Contains", rawKey).End()
 	pattern := BuildKey(rawKey)
 	if !strings.HasPrefix(pattern, "*") {
 		pattern = fmt.Sprintf("*%s", pattern)
@@ -137,7 +244,7 @@ func (c *CacheRedis) Contains(rawKey string, limit int) map[string]string {
 	if !strings.HasSuffix(pattern, "*") {
 		pattern = fmt.Sprintf("%s*", pattern)
 	}
-	return c.scan(0, pattern, int64(limit))
+	return c.scan(pattern, int64(limit))
 }
 
 // SetInt
@@ -156,6 +263,7 @@ func (c *CacheRedis) GetInt(key string) (val int) {
 
 // Incr
 func (c *CacheRedis) Incr(rawKey string) (val int) {
+	defer startCacheSpan("redis", "Incr", rawKey).End()
 	var (
 		key = BuildKey(rawKey)
 		cmd = c.client.Incr(key)
@@ -170,15 +278,326 @@ func (c *CacheRedis) Incr(rawKey string) (val int) {
 
 // Del
 func (c *CacheRedis) Del(keys ...string) {
+	defer startCacheSpan("redis", "Del", keys...).End()
 	for index, key := range keys {
 		keys[index] = BuildKey(key)
 	}
+	// 集群模式下多键DEL会返回CROSSSLOT错误，改为按节点分组的流水线逐个删除
+	if _, ok := c.client.(*redis.ClusterClient); ok && len(keys) > 1 {
+		_, err := c.client.Pipelined(func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(key)
+			}
+			return nil
+		})
+		ERROR(err)
+		return
+	}
 	cmd := c.client.Del(keys...)
 	if err := cmd.Err(); err != nil {
 		ERROR(err)
 	}
 }
 
+// Expire
+func (c *CacheRedis) Expire(rawKey string, ttl time.Duration) (ok bool) {
+	defer startCacheSpan("redis", "Expire", rawKey).End()
+	if ttl < 0 {
+		ttl = 0
+	}
+	cmd := c.client.PExpire(BuildKey(rawKey), ttl)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+	} else {
+		ok = cmd.Val()
+	}
+	return
+}
+
+// TTL
+func (c *CacheRedis) TTL(rawKey string) (ttl time.Duration) {
+	defer startCacheSpan("redis", "TTL", rawKey).End()
+	ttl = CacheTTLMissing
+	cmd := c.client.PTTL(BuildKey(rawKey))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return
+	}
+	// PTTL以毫秒为精度返回-1、-2
+	switch v := cmd.Val(); v {
+	case -2 * time.Millisecond:
+		ttl = CacheTTLMissing
+	case -1 * time.Millisecond:
+		ttl = CacheTTLPersistent
+	default:
+		ttl = v
+	}
+	return
+}
+
+// SetNX
+func (c *CacheRedis) SetNX(rawKey, val string, expiration ...time.Duration) (ok bool) {
+	defer startCacheSpan("redis", "SetNX", rawKey).End()
+	key, exp := c.buildKeyAndExp(rawKey, expiration)
+	cmd := c.client.SetNX(key, val, exp)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+	} else {
+		ok = cmd.Val()
+	}
+	return
+}
+
+// IncrBy
+func (c *CacheRedis) IncrBy(rawKey string, step int) (val int) {
+	defer startCacheSpan("redis", "IncrBy", rawKey).End()
+	cmd := c.client.IncrBy(BuildKey(rawKey), int64(step))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+	} else {
+		val = int(cmd.Val())
+	}
+	return
+}
+
+// Decr
+func (c *CacheRedis) Decr(rawKey string) int {
+	return c.IncrBy(rawKey, -1)
+}
+
+// HSet
+func (c *CacheRedis) HSet(rawKey, field, val string) {
+	defer startCacheSpan("redis", "HSet", rawKey).End()
+	if err := c.client.HSet(BuildKey(rawKey), field, val).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+// HGet
+func (c *CacheRedis) HGet(rawKey, field string) (val string) {
+	defer startCacheSpan("redis", "HGet", rawKey).End()
+	cmd := c.client.HGet(BuildKey(rawKey), field)
+	if err := cmd.Err(); err != nil {
+		if err != redis.Nil {
+			ERROR(err)
+		}
+	} else {
+		val = cmd.Val()
+	}
+	return
+}
+
+// HGetAll
+func (c *CacheRedis) HGetAll(rawKey string) (values map[string]string) {
+	defer startCacheSpan("redis", "HGetAll", rawKey).End()
+	cmd := c.client.HGetAll(BuildKey(rawKey))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return make(map[string]string)
+	}
+	return cmd.Val()
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	items := make([]interface{}, len(values))
+	for i, v := range values {
+		items[i] = v
+	}
+	return items
+}
+
+// SAdd
+func (c *CacheRedis) SAdd(rawKey string, members ...string) {
+	defer startCacheSpan("redis", "SAdd", rawKey).End()
+	if len(members) == 0 {
+		return
+	}
+	if err := c.client.SAdd(BuildKey(rawKey), stringsToInterfaces(members)...).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+// SRem
+func (c *CacheRedis) SRem(rawKey string, members ...string) {
+	defer startCacheSpan("redis", "SRem", rawKey).End()
+	if len(members) == 0 {
+		return
+	}
+	if err := c.client.SRem(BuildKey(rawKey), stringsToInterfaces(members)...).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+// SMembers
+func (c *CacheRedis) SMembers(rawKey string) []string {
+	defer startCacheSpan("redis", "SMembers", rawKey).End()
+	cmd := c.client.SMembers(BuildKey(rawKey))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return make([]string, 0)
+	}
+	return cmd.Val()
+}
+
+// ZAdd
+func (c *CacheRedis) ZAdd(rawKey string, members ...CacheZMember) {
+	defer startCacheSpan("redis", "ZAdd", rawKey).End()
+	if len(members) == 0 {
+		return
+	}
+	items := make([]redis.Z, len(members))
+	for i, member := range members {
+		items[i] = redis.Z{Score: member.Score, Member: member.Member}
+	}
+	if err := c.client.ZAdd(BuildKey(rawKey), items...).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+// ZRange
+func (c *CacheRedis) ZRange(rawKey string, start, stop int) []string {
+	defer startCacheSpan("redis", "ZRange", rawKey).End()
+	cmd := c.client.ZRange(BuildKey(rawKey), int64(start), int64(stop))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return make([]string, 0)
+	}
+	return cmd.Val()
+}
+
+// MGet
+func (c *CacheRedis) MGet(rawKeys ...string) (values []string) {
+	defer startCacheSpan("redis", "MGet", rawKeys...).End()
+	values = make([]string, len(rawKeys))
+	if len(rawKeys) == 0 {
+		return
+	}
+	keys := make([]string, len(rawKeys))
+	for i, key := range rawKeys {
+		keys[i] = BuildKey(key)
+	}
+	// 集群模式下键可能位于不同槽位，MGET会返回CROSSSLOT错误，改为按节点分组的流水线读取
+	if _, ok := c.client.(*redis.ClusterClient); ok {
+		cmds := make([]*redis.StringCmd, len(keys))
+		_, err := c.client.Pipelined(func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				cmds[i] = pipe.Get(key)
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			ERROR(err)
+		}
+		for i, cmd := range cmds {
+			values[i] = cmd.Val()
+		}
+		return
+	}
+	cmd := c.client.MGet(keys...)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return
+	}
+	for i, v := range cmd.Val() {
+		if s, ok := v.(string); ok {
+			values[i] = s
+		}
+	}
+	return
+}
+
+// MSet
+func (c *CacheRedis) MSet(values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	var (
+		keys  = make([]string, 0, len(values))
+		pairs = make([]interface{}, 0, len(values)*2)
+	)
+	for key, val := range values {
+		keys = append(keys, key)
+		pairs = append(pairs, BuildKey(key), val)
+	}
+	defer startCacheSpan("redis", "MSet", keys...).End()
+	// 集群模式下逐个写入，不保证原子性
+	if _, ok := c.client.(*redis.ClusterClient); ok {
+		_, err := c.client.Pipelined(func(pipe redis.Pipeliner) error {
+			for i := 0; i < len(pairs); i += 2 {
+				pipe.Set(pairs[i].(string), pairs[i+1], 0)
+			}
+			return nil
+		})
+		ERROR(err)
+		return
+	}
+	if err := c.client.MSet(pairs...).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+// 锁相关脚本只操作单个键，集群模式下无需hash tag
+var (
+	redisUnlockScript  = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+	redisRefreshScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+)
+
+// TryLock 基于SET NX实现，fencing token由独立的自增键生成
+func (c *CacheRedis) TryLock(rawKey string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	var (
+		key   = BuildKey("lock", rawKey)
+		value = uuid.NewV4().String()
+	)
+	ok, err := c.client.SetNX(key, value, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLockNotAcquired
+	}
+	token, err := c.client.Incr(BuildKey("lock_fencing", rawKey)).Result()
+	if err != nil {
+		c.client.Del(key)
+		return nil, err
+	}
+	return &Lock{Key: rawKey, Token: token, value: value, owner: c}, nil
+}
+
+// Unlock
+func (c *CacheRedis) Unlock(lock *Lock) error {
+	n, err := redisUnlockScript.Run(c.client, []string{BuildKey("lock", lock.Key)}, lock.value).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh
+func (c *CacheRedis) Refresh(lock *Lock, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	n, err := redisRefreshScript.Run(c.client, []string{BuildKey("lock", lock.Key)}, lock.value, int64(ttl/time.Millisecond)).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Ping
+func (c *CacheRedis) Ping() error {
+	return c.client.Ping().Err()
+}
+
 // Close
 func (c *CacheRedis) Close() {
 	if c.client != nil {
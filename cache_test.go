@@ -0,0 +1,257 @@
+package kuu
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// testCacheConformance 各缓存实现须通过的一致性测试
+func testCacheConformance(t *testing.T, c Cache) {
+	const prefix = "conformance_"
+	c.Del(prefix+"str", prefix+"nx", prefix+"tmp", prefix+"counter", prefix+"hash", prefix+"set", prefix+"zset", prefix+"m1", prefix+"m2", prefix+"n1", prefix+"n2")
+
+	t.Run("TTL", func(t *testing.T) {
+		if ttl := c.TTL(prefix + "str"); ttl != CacheTTLMissing {
+			t.Errorf("expected missing, got %s", ttl)
+		}
+		if c.Expire(prefix+"str", time.Minute) {
+			t.Error("expire should fail on missing key")
+		}
+		c.SetString(prefix+"str", "foo")
+		if ttl := c.TTL(prefix + "str"); ttl != CacheTTLPersistent {
+			t.Errorf("expected persistent, got %s", ttl)
+		}
+		if !c.Expire(prefix+"str", time.Minute) {
+			t.Error("expire should succeed on existing key")
+		}
+		if ttl := c.TTL(prefix + "str"); ttl <= 0 || ttl > time.Minute {
+			t.Errorf("unexpected ttl: %s", ttl)
+		}
+		c.SetString(prefix+"tmp", "bar", 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		if v := c.GetString(prefix + "tmp"); v != "" {
+			t.Errorf("expired key should be missing, got %q", v)
+		}
+		if ttl := c.TTL(prefix + "tmp"); ttl != CacheTTLMissing {
+			t.Errorf("expected missing after expiration, got %s", ttl)
+		}
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		if !c.SetNX(prefix+"nx", "first", time.Minute) {
+			t.Error("first SetNX should succeed")
+		}
+		if c.SetNX(prefix+"nx", "second") {
+			t.Error("second SetNX should fail")
+		}
+		if v := c.GetString(prefix + "nx"); v != "first" {
+			t.Errorf("unexpected value: %q", v)
+		}
+	})
+
+	t.Run("Counter", func(t *testing.T) {
+		if v := c.IncrBy(prefix+"counter", 5); v != 5 {
+			t.Errorf("IncrBy = %d", v)
+		}
+		if v := c.Incr(prefix + "counter"); v != 6 {
+			t.Errorf("Incr = %d", v)
+		}
+		if v := c.Decr(prefix + "counter"); v != 5 {
+			t.Errorf("Decr = %d", v)
+		}
+		if v := c.GetInt(prefix + "counter"); v != 5 {
+			t.Errorf("GetInt = %d", v)
+		}
+	})
+
+	t.Run("IntAsString", func(t *testing.T) {
+		c.SetString(prefix+"n1", "5")
+		if v := c.GetInt(prefix + "n1"); v != 5 {
+			t.Errorf("GetInt after SetString = %d", v)
+		}
+		if v := c.IncrBy(prefix+"n1", 2); v != 7 {
+			t.Errorf("IncrBy after SetString = %d", v)
+		}
+		if v := c.GetString(prefix + "n1"); v != "7" {
+			t.Errorf("GetString after IncrBy = %q", v)
+		}
+		c.SetInt(prefix+"n2", 42)
+		if v := c.GetString(prefix + "n2"); v != "42" {
+			t.Errorf("GetString after SetInt = %q", v)
+		}
+		if v := c.MGet(prefix + "n2"); len(v) != 1 || v[0] != "42" {
+			t.Errorf("MGet after SetInt = %v", v)
+		}
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		c.HSet(prefix+"hash", "a", "1")
+		c.HSet(prefix+"hash", "b", "2")
+		if v := c.HGet(prefix+"hash", "a"); v != "1" {
+			t.Errorf("HGet = %q", v)
+		}
+		if v := c.HGet(prefix+"hash", "c"); v != "" {
+			t.Errorf("HGet missing field = %q", v)
+		}
+		if v := c.HGetAll(prefix + "hash"); !reflect.DeepEqual(v, map[string]string{"a": "1", "b": "2"}) {
+			t.Errorf("HGetAll = %v", v)
+		}
+		c.Del(prefix + "hash")
+		if v := c.HGetAll(prefix + "hash"); len(v) != 0 {
+			t.Errorf("deleted hash should be empty: %v", v)
+		}
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		c.SAdd(prefix+"set", "b", "a", "c", "a")
+		c.SRem(prefix+"set", "b")
+		members := c.SMembers(prefix + "set")
+		sort.Strings(members)
+		if !reflect.DeepEqual(members, []string{"a", "c"}) {
+			t.Errorf("SMembers = %v", members)
+		}
+		c.SRem(prefix+"set", "a", "c")
+		if ttl := c.TTL(prefix + "set"); ttl != CacheTTLMissing {
+			t.Errorf("empty set should be removed, got %s", ttl)
+		}
+	})
+
+	t.Run("SortedSet", func(t *testing.T) {
+		c.ZAdd(prefix+"zset", CacheZMember{Score: 3, Member: "c"}, CacheZMember{Score: 1, Member: "a"}, CacheZMember{Score: 2, Member: "b"})
+		cases := []struct {
+			start, stop int
+			want        []string
+		}{
+			{0, -1, []string{"a", "b", "c"}},
+			{0, 0, []string{"a"}},
+			{-2, -1, []string{"b", "c"}},
+			{1, 10, []string{"b", "c"}},
+			{5, 10, []string{}},
+		}
+		for _, item := range cases {
+			if got := c.ZRange(prefix+"zset", item.start, item.stop); !reflect.DeepEqual(got, item.want) {
+				t.Errorf("ZRange(%d, %d) = %v, want %v", item.start, item.stop, got, item.want)
+			}
+		}
+	})
+
+	t.Run("Multi", func(t *testing.T) {
+		c.MSet(map[string]string{prefix + "m1": "1", prefix + "m2": "2"})
+		if got := c.MGet(prefix+"m1", prefix+"missing", prefix+"m2"); !reflect.DeepEqual(got, []string{"1", "", "2"}) {
+			t.Errorf("MGet = %v", got)
+		}
+	})
+}
+
+func TestCacheBoltConformance(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	testCacheConformance(t, c)
+}
+
+func TestCacheMemoryConformance(t *testing.T) {
+	c := NewCacheMemory()
+	defer c.Close()
+	testCacheConformance(t, c)
+}
+
+func TestCacheMemoryExpiration(t *testing.T) {
+	c := NewCacheMemory()
+	defer c.Close()
+	c.SetString("a", "1", 20*time.Millisecond)
+	c.SetString("b", "2")
+	time.Sleep(40 * time.Millisecond)
+	c.evictExpired()
+	if len(c.entries) != 1 || c.GetString("b") != "2" {
+		t.Fatalf("expected only b to remain, got %d entries", len(c.entries))
+	}
+	if v := c.HasPrefix("a", 0); len(v) != 0 {
+		t.Fatalf("expected expired key to be skipped, got %v", len(v))
+	}
+}
+
+func TestRegisterCacheBackend(t *testing.T) {
+	if getCacheBackend("test_backend") != nil {
+		t.Fatal("expected test_backend to be unregistered")
+	}
+	current := DefaultCache
+	RegisterCacheBackend("test_backend", func() Cache { return NewCacheMemory() })
+	if getCacheBackend("test_backend") == nil {
+		t.Fatal("expected test_backend to be registered")
+	}
+	if DefaultCache != current {
+		t.Fatal("expected DefaultCache to be unchanged when cache:backend is not test_backend")
+	}
+}
+
+func TestRedisConfigUniversalOptions(t *testing.T) {
+	cfg := RedisConfig{
+		Addr:        "127.0.0.1:6379",
+		Addrs:       []string{"127.0.0.1:6379", "127.0.0.1:6380"},
+		MasterName:  "mymaster",
+		DB:          2,
+		ReadTimeout: 3,
+	}
+	opts := cfg.UniversalOptions()
+	if !reflect.DeepEqual(opts.Addrs, []string{"127.0.0.1:6379", "127.0.0.1:6380"}) {
+		t.Fatalf("unexpected addrs: %v", opts.Addrs)
+	}
+	if opts.MasterName != "mymaster" || opts.DB != 2 || opts.ReadTimeout != 3*time.Second {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+	if opts := (&RedisConfig{Addr: "127.0.0.1:6379"}).UniversalOptions(); len(opts.Addrs) != 1 {
+		t.Fatalf("unexpected addrs: %v", opts.Addrs)
+	}
+}
+
+func TestCacheRedisClusterDel(t *testing.T) {
+	defer setTestConfig("name", `"test"`)()
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:0"}})
+	defer client.Close()
+
+	var (
+		single   [][]interface{}
+		pipeline [][]interface{}
+	)
+	client.WrapProcess(func(func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			single = append(single, cmd.Args())
+			return nil
+		}
+	})
+	client.WrapProcessPipeline(func(func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			for _, cmd := range cmds {
+				pipeline = append(pipeline, cmd.Args())
+			}
+			return nil
+		}
+	})
+	c := &CacheRedis{client: client}
+	c.Del("a", "b")
+	if len(single) != 0 {
+		t.Errorf("unexpected multi-key commands: %v", single)
+	}
+	expected := [][]interface{}{{"del", "test_a"}, {"del", "test_b"}}
+	if !reflect.DeepEqual(pipeline, expected) {
+		t.Errorf("expected one DEL per key in a pipeline, got %v", pipeline)
+	}
+}
+
+func TestCacheRedisConformance(t *testing.T) {
+	addr := os.Getenv("KUU_TEST_REDIS")
+	if addr == "" {
+		t.Skip("KUU_TEST_REDIS is not set")
+	}
+	if C().GetString("name") == "" {
+		C(map[string]interface{}{"name": "kuu_test"})
+	}
+	c := &CacheRedis{client: redis.NewClient(&redis.Options{Addr: addr})}
+	defer c.Close()
+	testCacheConformance(t, c)
+}
@@ -166,6 +166,12 @@ func (c *Context) IgnoreAuth(cancel ...bool) *Context {
 	return c
 }
 
+// ReadPrimary
+func (c *Context) ReadPrimary(cancel ...bool) *Context {
+	ReadPrimary(cancel...)
+	return c
+}
+
 // Scheme
 func (c *Context) Scheme() string {
 	// Can't use `r.Request.URL.Scheme`
@@ -1,8 +1,10 @@
 package kuu
 
 import (
+	"database/sql"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
@@ -16,9 +18,15 @@ var (
 )
 
 type dataSource struct {
-	Name    string
-	Dialect string
-	Args    string
+	Name                string
+	Dialect             string
+	Args                string
+	Replicas            []string
+	HealthCheckInterval int
+	MaxOpenConns        int
+	MaxIdleConns        int
+	ConnMaxLifetime     int
+	SlowThreshold       int
 }
 
 func (ds *dataSource) isBlank() bool {
@@ -78,12 +86,27 @@ func initDataSources() {
 }
 
 func openDB(ds dataSource) {
-	db, err := gorm.Open(ds.Dialect, ds.Args)
+	var (
+		db  *gorm.DB
+		err error
+	)
+	if len(ds.Replicas) > 0 {
+		db, err = openReplicaDB(ds)
+	} else {
+		db, err = gorm.Open(ds.Dialect, ds.Args)
+	}
 	if err != nil {
 		panic(err)
 	} else {
 		connectedPrint(strings.Title(db.Dialect().GetName()), db.Dialect().CurrentDatabase())
+		// 标记数据源名称，供慢查询检测读取阈值
+		db = db.Set(dataSourceNameKey, ds.Name)
+		if ds.SlowThreshold != 0 {
+			slowQueryThresholds.Store(ds.Name, time.Duration(ds.SlowThreshold)*time.Millisecond)
+		}
 		dataSourcesMap.Store(ds.Name, db)
+		configurePool(ds, db)
+		startDBHealthCheck(ds, db)
 		if gin.IsDebugging() {
 			db.LogMode(true)
 			db.SetLogger(dbLogger{})
@@ -91,9 +114,26 @@ func openDB(ds dataSource) {
 	}
 }
 
+func openReplicaDB(ds dataSource) (*gorm.DB, error) {
+	primary, err := sql.Open(ds.Dialect, ds.Args)
+	if err != nil {
+		return nil, err
+	}
+	if err := primary.Ping(); err != nil {
+		_ = primary.Close()
+		return nil, err
+	}
+	router, err := newReplicaRouter(ds, primary)
+	if err != nil {
+		_ = primary.Close()
+		return nil, err
+	}
+	return gorm.Open(ds.Dialect, router)
+}
+
 // DB
 func DB() *gorm.DB {
-	return DS("")
+	return DS(routineDSName())
 }
 
 // DS
@@ -111,13 +151,19 @@ func DS(name string) *gorm.DB {
 	return nil
 }
 
-// WithTransaction
+var (
+	afterCommits   = make(map[gorm.SQLCommon][]func())
+	afterCommitsMu sync.Mutex
+)
+
+// WithTransaction 提交成功后执行事务内通过afterCommit登记的函数
 func WithTransaction(fn func(*gorm.DB) error) (err error) {
 	var tx *gorm.DB
 	if err = CatchError(func() {
 		if tx = DB().Begin(); tx.Error != nil {
 			panic(tx.Error)
 		}
+		trackTransaction(tx.CommonDB())
 		if err := fn(tx); err != nil {
 			panic(err)
 		}
@@ -127,9 +173,41 @@ func WithTransaction(fn func(*gorm.DB) error) (err error) {
 	}); err != nil {
 		tx.Rollback()
 	}
+	if tx != nil {
+		finishTransaction(tx.CommonDB(), err == nil)
+	}
 	return
 }
 
+func trackTransaction(db gorm.SQLCommon) {
+	afterCommitsMu.Lock()
+	afterCommits[db] = nil
+	afterCommitsMu.Unlock()
+}
+
+func finishTransaction(db gorm.SQLCommon, committed bool) {
+	afterCommitsMu.Lock()
+	fns, ok := afterCommits[db]
+	delete(afterCommits, db)
+	afterCommitsMu.Unlock()
+	if ok && committed {
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
+
+// afterCommit 在WithTransaction开启的事务中登记提交后执行的函数，其他连接（非事务或外部事务）返回false
+func afterCommit(db gorm.SQLCommon, fn func()) bool {
+	afterCommitsMu.Lock()
+	defer afterCommitsMu.Unlock()
+	fns, ok := afterCommits[db]
+	if ok {
+		afterCommits[db] = append(fns, fn)
+	}
+	return ok
+}
+
 func releaseDB() {
 	dataSourcesMap.Range(func(_, value interface{}) bool {
 		db := value.(*gorm.DB)
@@ -0,0 +1,168 @@
+package kuu
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// replicaConn
+type replicaConn struct {
+	db      *sql.DB
+	index   int
+	healthy int32
+}
+
+func (r *replicaConn) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// replicaRouter 读写分离连接，写操作和事务始终使用主库，事务外的查询轮询健康的只读副本
+type replicaRouter struct {
+	name     string
+	primary  *sql.DB
+	replicas []*replicaConn
+	next     uint32
+	quit     chan struct{}
+}
+
+func newReplicaRouter(ds dataSource, primary *sql.DB) (*replicaRouter, error) {
+	r := &replicaRouter{
+		name:    ds.Name,
+		primary: primary,
+		quit:    make(chan struct{}),
+	}
+	for index, args := range ds.Replicas {
+		db, err := sql.Open(ds.Dialect, args)
+		if err != nil {
+			return nil, err
+		}
+		conn := &replicaConn{db: db, index: index}
+		if err := db.Ping(); err != nil {
+			ERROR("Replica %d of data source \"%s\" is unavailable: %s", index, ds.Name, err.Error())
+		} else {
+			conn.healthy = 1
+		}
+		r.replicas = append(r.replicas, conn)
+	}
+	interval := ds.HealthCheckInterval
+	if interval <= 0 {
+		interval = 10
+	}
+	go r.healthCheck(time.Duration(interval) * time.Second)
+	return r, nil
+}
+
+func (r *replicaRouter) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, conn := range r.replicas {
+				var healthy int32
+				if err := conn.db.Ping(); err == nil {
+					healthy = 1
+				}
+				if old := atomic.SwapInt32(&conn.healthy, healthy); old != healthy {
+					if healthy == 1 {
+						INFO("Replica %d of data source \"%s\" is recovered", conn.index, r.name)
+					} else {
+						WARN("Replica %d of data source \"%s\" is unhealthy", conn.index, r.name)
+					}
+				}
+			}
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// reader 选择查询连接，非SELECT语句或已固定主库时使用主库
+func (r *replicaRouter) reader(query string) *sql.DB {
+	if len(r.replicas) == 0 || isReadPrimary() {
+		return r.primary
+	}
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "SELECT") {
+		return r.primary
+	}
+	total := uint32(len(r.replicas))
+	start := atomic.AddUint32(&r.next, 1)
+	for i := uint32(0); i < total; i++ {
+		if conn := r.replicas[(start+i)%total]; conn.isHealthy() {
+			return conn.db
+		}
+	}
+	return r.primary
+}
+
+// Exec
+func (r *replicaRouter) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.primary.Exec(query, args...)
+}
+
+// Prepare
+func (r *replicaRouter) Prepare(query string) (*sql.Stmt, error) {
+	return r.primary.Prepare(query)
+}
+
+// Query
+func (r *replicaRouter) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.reader(query).Query(query, args...)
+}
+
+// QueryRow
+func (r *replicaRouter) QueryRow(query string, args ...interface{}) *sql.Row {
+	return r.reader(query).QueryRow(query, args...)
+}
+
+// Begin
+func (r *replicaRouter) Begin() (*sql.Tx, error) {
+	return r.primary.Begin()
+}
+
+// BeginTx
+func (r *replicaRouter) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.primary.BeginTx(ctx, opts)
+}
+
+// Close
+func (r *replicaRouter) Close() error {
+	close(r.quit)
+	for _, conn := range r.replicas {
+		if err := conn.db.Close(); err != nil {
+			ERROR(err)
+		}
+	}
+	return r.primary.Close()
+}
+
+// ReadPrimary 当前请求的后续查询固定使用主库（读己之写）
+func ReadPrimary(cancel ...bool) (success bool) {
+	caches := GetRoutineCaches()
+	if caches != nil {
+		if len(cancel) > 0 && cancel[0] == true {
+			delete(caches, GLSReadPrimaryKey)
+		} else {
+			caches[GLSReadPrimaryKey] = true
+		}
+		success = true
+	}
+	return
+}
+
+func isReadPrimary() bool {
+	if caches := GetRoutineCaches(); caches != nil {
+		if _, ok := caches[GLSReadPrimaryKey]; ok {
+			return true
+		}
+	}
+	if c := GetRoutineRequestContext(); c != nil && c.Request != nil {
+		if v := c.GetHeader(ReadPrimaryHeaderKey); v != "" && v != "false" && v != "0" {
+			return true
+		}
+	}
+	return false
+}
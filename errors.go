@@ -16,10 +16,12 @@ var (
 	ErrAffectedSaveToken   = errors.New("未新增或修改任何记录，请检查更新条件或数据权限")
 	ErrAffectedDeleteToken = errors.New("未删除任何记录，请检查更新条件或数据权限")
 
-	ErrWidelyCode         = uint(1 << 50)
-	ErrFieldValidatorCode = uint(1 << 51)
-	ErrWidely             = &Error{Code: ErrWidelyCode}
-	ErrFieldValidator     = &Error{Code: ErrFieldValidatorCode}
+	ErrWidelyCode          = uint(1 << 50)
+	ErrFieldValidatorCode  = uint(1 << 51)
+	ErrVersionConflictCode = uint(1 << 52)
+	ErrWidely              = &Error{Code: ErrWidelyCode}
+	ErrFieldValidator      = &Error{Code: ErrFieldValidatorCode}
+	ErrVersionConflict     = &Error{Code: ErrVersionConflictCode}
 )
 
 // Error defined kuu error type
@@ -0,0 +1,184 @@
+package kuu
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	dbHealthMap sync.Map
+	cronRunning int32
+)
+
+// DBHealth
+type DBHealth struct {
+	Name      string
+	Healthy   bool
+	Error     string `json:",omitempty"`
+	CheckedAt time.Time
+	Stats     []sql.DBStats
+}
+
+type cachePinger interface {
+	Ping() error
+}
+
+// sqlDBs 返回数据源底层的连接池（含只读副本）
+func sqlDBs(db *gorm.DB) (list []*sql.DB) {
+	switch v := db.CommonDB().(type) {
+	case *sql.DB:
+		list = append(list, v)
+	case *replicaRouter:
+		list = append(list, v.primary)
+		for _, conn := range v.replicas {
+			list = append(list, conn.db)
+		}
+	}
+	return
+}
+
+func configurePool(ds dataSource, db *gorm.DB) {
+	for _, item := range sqlDBs(db) {
+		if ds.MaxOpenConns > 0 {
+			item.SetMaxOpenConns(ds.MaxOpenConns)
+		}
+		if ds.MaxIdleConns > 0 {
+			item.SetMaxIdleConns(ds.MaxIdleConns)
+		}
+		if ds.ConnMaxLifetime > 0 {
+			item.SetConnMaxLifetime(time.Duration(ds.ConnMaxLifetime) * time.Second)
+		}
+	}
+}
+
+func pingDB(name string, db *gorm.DB) *DBHealth {
+	health := &DBHealth{Name: name, CheckedAt: time.Now()}
+	list := sqlDBs(db)
+	if len(list) > 0 {
+		if err := list[0].Ping(); err != nil {
+			health.Error = err.Error()
+		} else {
+			health.Healthy = true
+		}
+	}
+	for _, item := range list {
+		health.Stats = append(health.Stats, item.Stats())
+	}
+	return health
+}
+
+// startDBHealthCheck 定时检测主库连接，连接断开时由database/sql在下一次Ping时重新建立连接
+func startDBHealthCheck(ds dataSource, db *gorm.DB) {
+	interval := ds.HealthCheckInterval
+	if interval <= 0 {
+		interval = 10
+	}
+	dbHealthMap.Store(ds.Name, pingDB(ds.Name, db))
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, ok := dataSourcesMap.Load(ds.Name); !ok {
+				return
+			}
+			health := pingDB(ds.Name, db)
+			if v, ok := dbHealthMap.Load(ds.Name); ok {
+				if old := v.(*DBHealth); old.Healthy != health.Healthy {
+					if health.Healthy {
+						INFO("Data source \"%s\" is reconnected", ds.Name)
+					} else {
+						ERROR("Data source \"%s\" is disconnected: %s", ds.Name, health.Error)
+					}
+				}
+			}
+			dbHealthMap.Store(ds.Name, health)
+		}
+	}()
+}
+
+// HealthStatus
+type HealthStatus struct {
+	Ready    bool
+	Uptime   string        `json:",omitempty"`
+	DB       []*DBHealth   `json:",omitempty"`
+	Cache    M             `json:",omitempty"`
+	Cron     M             `json:",omitempty"`
+	LogSinks []LogSinkStat `json:",omitempty"`
+	Checked  time.Time
+}
+
+// GetHealthStatus
+func GetHealthStatus() *HealthStatus {
+	status := &HealthStatus{Ready: true, Checked: time.Now()}
+	if !RunTime.IsZero() {
+		status.Uptime = time.Since(RunTime).String()
+	}
+	dbHealthMap.Range(func(_, value interface{}) bool {
+		health := value.(*DBHealth)
+		if !health.Healthy {
+			status.Ready = false
+		}
+		status.DB = append(status.DB, health)
+		return true
+	})
+	status.Cache = M{"healthy": false}
+	if DefaultCache != nil {
+		status.Cache["healthy"] = true
+		if v, ok := DefaultCache.(cachePinger); ok {
+			if err := v.Ping(); err != nil {
+				status.Cache["healthy"] = false
+				status.Cache["error"] = err.Error()
+			}
+		}
+	}
+	if !status.Cache["healthy"].(bool) {
+		status.Ready = false
+	}
+	status.Cron = M{
+		"running": atomic.LoadInt32(&cronRunning) == 1,
+		"entries": len(DefaultCron.Entries()),
+	}
+	status.LogSinks = GetLogSinkStats()
+	return status
+}
+
+// healthStatusFor 检测接口免登录，未登录时仅返回就绪状态，不暴露连接池等运行信息
+func healthStatusFor(c *Context) *HealthStatus {
+	status := GetHealthStatus()
+	if c.SignInfo.IsValid() {
+		return status
+	}
+	return &HealthStatus{Ready: status.Ready, Checked: status.Checked}
+}
+
+// HealthzRoute
+var HealthzRoute = RouteInfo{
+	Name:         "存活检测接口",
+	Method:       "GET",
+	Path:         "/healthz",
+	IgnorePrefix: true,
+	HandlerFunc: func(c *Context) {
+		c.STD(healthStatusFor(c))
+	},
+}
+
+// ReadyzRoute
+var ReadyzRoute = RouteInfo{
+	Name:         "就绪检测接口",
+	Method:       "GET",
+	Path:         "/readyz",
+	IgnorePrefix: true,
+	HandlerFunc: func(c *Context) {
+		status := healthStatusFor(c)
+		if status.Ready {
+			c.STD(status)
+		} else {
+			c.STDErrHold(c.L("sys_not_ready", "Service is not ready"), status).HTTPCode(http.StatusServiceUnavailable).Render()
+		}
+	},
+}
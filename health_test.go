@@ -0,0 +1,18 @@
+package kuu
+
+import "testing"
+
+func TestHealthStatusFor(t *testing.T) {
+	dbHealthMap.Store("health_test", &DBHealth{Name: "health_test", Healthy: true})
+	defer dbHealthMap.Delete("health_test")
+
+	status := healthStatusFor(&Context{})
+	if status.DB != nil || status.Cache != nil || status.Cron != nil || status.LogSinks != nil || status.Uptime != "" {
+		t.Errorf("anonymous callers should only get the ready state: %+v", status)
+	}
+	desc := tenantTestDesc(1, 0, nil)
+	status = healthStatusFor(&Context{SignInfo: desc.SignInfo})
+	if len(status.DB) == 0 || status.Cache == nil {
+		t.Errorf("signed-in callers should get the details: %+v", status)
+	}
+}
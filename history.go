@@ -0,0 +1,304 @@
+package kuu
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// HistoryActionCreate
+	HistoryActionCreate = "create"
+	// HistoryActionUpdate
+	HistoryActionUpdate = "update"
+	// HistoryActionDelete
+	HistoryActionDelete = "delete"
+
+	historyBeforeKey = "kuu:history_before"
+)
+
+// 更新时自动维护的字段，不计入变更明细
+var historyIgnoredDiffFields = map[string]bool{
+	"UpdatedAt":   true,
+	"UpdatedByID": true,
+	"Ts":          true,
+}
+
+// RecordHistory 数据变更历史
+type RecordHistory struct {
+	ID            uint      `gorm:"primary_key"`
+	Time          time.Time `name:"变更时间"`
+	ModelName     string    `name:"模型名称" gorm:"index:idx_record_history"`
+	RecordID      string    `name:"数据ID" gorm:"index:idx_record_history"`
+	Action        string    `name:"变更类型"`
+	UID           uint      `name:"操作人ID"`
+	Username      string    `name:"操作人账号"`
+	RealName      string    `name:"操作人姓名"`
+	RequestID     string    `name:"请求ID"`
+	RequestMethod string    `name:"请求方法"`
+	RequestPath   string    `name:"请求路径"`
+	Before        string    `name:"变更前数据" gorm:"type:text"`
+	After         string    `name:"变更后数据" gorm:"type:text"`
+	Diff          string    `name:"变更明细" gorm:"type:text"`
+}
+
+// IgnoreLog
+func (h *RecordHistory) IgnoreLog() {}
+
+// HistoryDiff 字段级变更明细
+type HistoryDiff struct {
+	Field     string
+	Name      string
+	LocaleKey string      `json:",omitempty"`
+	Before    interface{} `json:",omitempty"`
+	After     interface{} `json:",omitempty"`
+}
+
+// HistoryTimelineItem
+type HistoryTimelineItem struct {
+	ID        uint
+	Time      time.Time
+	Action    string
+	UID       uint
+	Username  string
+	RealName  string
+	RequestID string
+	Diff      []HistoryDiff
+}
+
+func registerHistoryCallbacks(callback *gorm.Callback) {
+	if callback.Create().Get("kuu:history_create") == nil {
+		callback.Create().After("gorm:create").Register("kuu:history_create", historyCreateCallback)
+	}
+	if callback.Update().Get("kuu:history_before_update") == nil {
+		callback.Update().Before("gorm:update").Register("kuu:history_before_update", historyBeforeCallback)
+	}
+	if callback.Update().Get("kuu:history_update") == nil {
+		callback.Update().After("gorm:update").Register("kuu:history_update", historyUpdateCallback)
+	}
+	if callback.Delete().Get("kuu:history_before_delete") == nil {
+		callback.Delete().Before("gorm:delete").Register("kuu:history_before_delete", historyBeforeCallback)
+	}
+	if callback.Delete().Get("kuu:history_delete") == nil {
+		callback.Delete().After("gorm:delete").Register("kuu:history_delete", historyDeleteCallback)
+	}
+}
+
+// historyMeta 仅处理声明了kuu:"history"且指定了主键的数据
+func historyMeta(scope *gorm.Scope) (*Metadata, *gorm.Field) {
+	if scope.Value == nil {
+		return nil, nil
+	}
+	if indirectValue(scope.Value).Kind() != reflect.Struct {
+		return nil, nil
+	}
+	meta := Meta(scope.Value)
+	if meta == nil || !meta.History {
+		return nil, nil
+	}
+	field := scope.PrimaryField()
+	if field == nil || field.IsBlank {
+		return nil, nil
+	}
+	return meta, field
+}
+
+func loadHistoryRecord(scope *gorm.Scope, meta *Metadata, pk *gorm.Field) interface{} {
+	value := meta.NewValue()
+	err := scope.NewDB().Unscoped().
+		Where(fmt.Sprintf("%v.%v = ?", scope.QuotedTableName(), scope.Quote(pk.DBName)), pk.Field.Interface()).
+		First(value).Error
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			ERROR("Load history snapshot of %s failed: %s", meta.Name, err.Error())
+		}
+		return nil
+	}
+	return value
+}
+
+// historySnapshot 提取普通字段的值，密码字段脱敏
+func historySnapshot(scope *gorm.Scope, meta *Metadata, value interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+	passwords := make(map[string]bool)
+	for _, field := range meta.Fields {
+		if field.IsPassword {
+			passwords[field.Code] = true
+		}
+	}
+	snapshot := make(map[string]interface{})
+	for _, field := range scope.New(value).Fields() {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		if passwords[field.Name] {
+			snapshot[field.Name] = "******"
+		} else {
+			snapshot[field.Name] = field.Field.Interface()
+		}
+	}
+	return snapshot
+}
+
+func historyDiff(meta *Metadata, before, after map[string]interface{}) (diff []HistoryDiff) {
+	for _, field := range meta.Fields {
+		if historyIgnoredDiffFields[field.Code] {
+			continue
+		}
+		b, hasBefore := before[field.Code]
+		a, hasAfter := after[field.Code]
+		if !hasBefore && !hasAfter {
+			continue
+		}
+		if JSONStringify(b) == JSONStringify(a) {
+			continue
+		}
+		// 新增时忽略空值字段
+		if before == nil && IsBlank(a) {
+			continue
+		}
+		diff = append(diff, HistoryDiff{
+			Field:     field.Code,
+			Name:      field.Name,
+			LocaleKey: field.LocaleKey,
+			Before:    b,
+			After:     a,
+		})
+	}
+	return
+}
+
+func saveRecordHistory(scope *gorm.Scope, meta *Metadata, pk *gorm.Field, action string, before, after map[string]interface{}) {
+	diff := historyDiff(meta, before, after)
+	if len(diff) == 0 {
+		return
+	}
+	history := RecordHistory{
+		Time:      time.Now(),
+		ModelName: meta.Name,
+		RecordID:  fmt.Sprintf("%v", pk.Field.Interface()),
+		Action:    action,
+		Diff:      JSONStringify(diff),
+	}
+	if before != nil {
+		history.Before = JSONStringify(before)
+	}
+	if after != nil {
+		history.After = JSONStringify(after)
+	}
+	if desc := GetRoutinePrivilegesDesc(); desc.IsValid() {
+		history.UID = desc.UID
+		if user := GetUserFromCache(desc.UID); user.ID != 0 {
+			history.Username = user.Username
+			history.RealName = user.Name
+		}
+	}
+	if c := GetRoutineRequestContext(); c != nil && c.Request != nil {
+		history.RequestID = GetRequestID(c.Context)
+		history.RequestMethod = c.Request.Method
+		history.RequestPath = c.Request.URL.Path
+	}
+	// 与业务数据处于同一事务中
+	if err := scope.NewDB().Create(&history).Error; err != nil {
+		_ = scope.Err(fmt.Errorf("保存数据变更历史失败：%s", err.Error()))
+	}
+}
+
+func historyCreateCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		if meta, pk := historyMeta(scope); meta != nil {
+			saveRecordHistory(scope, meta, pk, HistoryActionCreate, nil, historySnapshot(scope, meta, scope.Value))
+		}
+	}
+}
+
+func historyBeforeCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		if meta, pk := historyMeta(scope); meta != nil {
+			if before := loadHistoryRecord(scope, meta, pk); before != nil {
+				scope.InstanceSet(historyBeforeKey, before)
+			}
+		}
+	}
+}
+
+func historyUpdateCallback(scope *gorm.Scope) {
+	if !scope.HasError() && scope.DB().RowsAffected > 0 {
+		if meta, pk := historyMeta(scope); meta != nil {
+			before, _ := scope.InstanceGet(historyBeforeKey)
+			after := loadHistoryRecord(scope, meta, pk)
+			saveRecordHistory(scope, meta, pk, HistoryActionUpdate, historySnapshot(scope, meta, before), historySnapshot(scope, meta, after))
+		}
+	}
+}
+
+func historyDeleteCallback(scope *gorm.Scope) {
+	if !scope.HasError() && scope.DB().RowsAffected > 0 {
+		if meta, pk := historyMeta(scope); meta != nil {
+			before, _ := scope.InstanceGet(historyBeforeKey)
+			// 软删除时记录删除后的数据，物理删除时为空
+			after := loadHistoryRecord(scope, meta, pk)
+			saveRecordHistory(scope, meta, pk, HistoryActionDelete, historySnapshot(scope, meta, before), historySnapshot(scope, meta, after))
+		}
+	}
+}
+
+// GetRecordHistory 查询数据变更时间线，按时间倒序排列
+func GetRecordHistory(modelName string, recordID string) (list []HistoryTimelineItem, err error) {
+	var records []RecordHistory
+	if err = DB().Where("model_name = ? AND record_id = ?", modelName, recordID).Order("time desc, id desc").Find(&records).Error; err != nil {
+		return
+	}
+	for _, record := range records {
+		item := HistoryTimelineItem{
+			ID:        record.ID,
+			Time:      record.Time,
+			Action:    record.Action,
+			UID:       record.UID,
+			Username:  record.Username,
+			RealName:  record.RealName,
+			RequestID: record.RequestID,
+		}
+		_ = JSONParse(record.Diff, &item.Diff)
+		list = append(list, item)
+	}
+	return
+}
+
+func restHistoryHandler(reflectType reflect.Type) func(c *Context) {
+	return func(c *Context) {
+		var (
+			modelValue = reflect.New(reflectType).Interface()
+			meta       = Meta(modelValue)
+			scope      = DB().NewScope(modelValue)
+			recordID   = strings.TrimSpace(c.Param("id"))
+		)
+		if recordID == "" {
+			c.STDErr(c.L("rest_history_failed", "Query history failed"), "'id' is required")
+			return
+		}
+		// 校验当前用户对数据的读取权限
+		if err := DB().Unscoped().Where(fmt.Sprintf("%v = ?", scope.Quote(scope.PrimaryKey())), recordID).First(modelValue).Error; err != nil {
+			c.STDErr(c.L("rest_history_failed", "Query history failed"), err)
+			return
+		}
+		list, err := GetRecordHistory(meta.Name, recordID)
+		if err != nil {
+			c.STDErr(c.L("rest_history_failed", "Query history failed"), err)
+			return
+		}
+		for i, item := range list {
+			for j, diff := range item.Diff {
+				if diff.LocaleKey != "" {
+					list[i].Diff[j].Name = c.L(diff.LocaleKey, diff.Name).Render()
+				}
+			}
+		}
+		c.STD(list)
+	}
+}
@@ -8,16 +8,28 @@ import (
 	"github.com/hoisie/mustache"
 	"github.com/jinzhu/gorm"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	// LanguageMessagesCache
-	languageMessagesCache = map[string]LanguageMessagesMap{}
 	// RequestLangKey
 	RequestLangKey = "Lang"
 )
 
+// languageMessagesCacheKey 消息缓存存放在一级缓存中，修改后通知所有节点重新加载
+const languageMessagesCacheKey = "language_messages"
+
+var languageMessagesLoadMu sync.Mutex
+
+// languageMessagesCache
+type languageMessagesCache struct {
+	// Global 全局消息
+	Global map[string]LanguageMessagesMap
+	// Tenants 租户覆盖后的消息
+	Tenants map[uint]map[string]LanguageMessagesMap
+}
+
 // 需求点：
 // 1.缓存LanguageMessage到内存中，每次修改后更新缓存
 // 2.保存用户的上一次语言设置，根据请求中的Lang参数自动切换语言
@@ -43,6 +55,7 @@ type LanguageMessage struct {
 	FormattedContext interface{}  `name:"格式化上下文" json:"-,omitempty" gorm:"-"`
 	Group            string       `name:"分组"`
 	Sort             int          `name:"排序值"`
+	TenantID         uint         `name:"租户ID（为空表示全局配置）"`
 }
 
 // C
@@ -243,28 +256,87 @@ func L(key string, defaultMessage string, formattedContext ...interface{}) *Lang
 	return msg
 }
 
+// AfterSave
+func (m *LanguageMessage) AfterSave(tx *gorm.DB) (err error) {
+	InvalidateL1(languageMessagesCacheKey)
+	return
+}
+
+// AfterDelete
+func (m *LanguageMessage) AfterDelete(tx *gorm.DB) (err error) {
+	InvalidateL1(languageMessagesCacheKey)
+	return
+}
+
 // RefreshLanguageMessagesCache
 func RefreshLanguageMessagesCache() {
+	InvalidateL1(languageMessagesCacheKey)
+	getLanguageMessagesCache()
+}
+
+// getLanguageMessagesCache 消息缓存固定在一级缓存中（不被淘汰，i18n:cacheTTL秒后过期以防失效消息丢失），失效后仅由一个协程重新加载
+func getLanguageMessagesCache() *languageMessagesCache {
+	if v, ok := DefaultL1Cache.Get(languageMessagesCacheKey); ok {
+		return v.(*languageMessagesCache)
+	}
+	languageMessagesLoadMu.Lock()
+	defer languageMessagesLoadMu.Unlock()
+	if v, ok := DefaultL1Cache.Get(languageMessagesCacheKey); ok {
+		return v.(*languageMessagesCache)
+	}
+	// 加载期间发生失效时不固定加载结果，下次访问重新加载
+	version := DefaultL1Cache.Version()
+	cache := loadLanguageMessagesCache()
+	if cache != nil {
+		DefaultL1Cache.Pin(languageMessagesCacheKey, cache, version, time.Duration(C().DefaultGetInt("i18n:cacheTTL", 300))*time.Second)
+	} else {
+		cache = &languageMessagesCache{}
+	}
+	return cache
+}
+
+func loadLanguageMessagesCache() *languageMessagesCache {
 	var list []LanguageMessage
-	if err := DB().Find(&list).Error; err != nil {
+	if err := DB().Set(GormIgnoreTenantKey, true).Find(&list).Error; err != nil {
 		ERROR("Refreshing i18n cache failed: %s", err.Error())
-		return
+		return nil
+	}
+	cache := &languageMessagesCache{
+		Global:  make(map[string]LanguageMessagesMap),
+		Tenants: make(map[uint]map[string]LanguageMessagesMap),
 	}
-	languageMessagesCache = make(map[string]LanguageMessagesMap)
+	var overrides []LanguageMessage
 	for _, item := range list {
-		if languageMessagesCache[item.LangCode] == nil {
-			languageMessagesCache[item.LangCode] = make(LanguageMessagesMap)
+		if item.TenantID != 0 {
+			overrides = append(overrides, item)
+			continue
+		}
+		if cache.Global[item.LangCode] == nil {
+			cache.Global[item.LangCode] = make(LanguageMessagesMap)
 		}
-		languageMessagesCache[item.LangCode][item.Key] = item
+		cache.Global[item.LangCode][item.Key] = item
 	}
+	// 租户覆盖配置合并全局配置
+	for _, item := range overrides {
+		if cache.Tenants[item.TenantID] == nil {
+			cache.Tenants[item.TenantID] = make(map[string]LanguageMessagesMap)
+		}
+		tenantMessages := cache.Tenants[item.TenantID]
+		if tenantMessages[item.LangCode] == nil {
+			tenantMessages[item.LangCode] = make(LanguageMessagesMap)
+			for key, msg := range cache.Global[item.LangCode] {
+				tenantMessages[item.LangCode][key] = msg
+			}
+		}
+		tenantMessages[item.LangCode][item.Key] = item
+	}
+	return cache
 }
 
 // GetUserLanguageMessages
 func GetUserLanguageMessages(c *gin.Context, userLang ...string) LanguageMessagesMap {
-	if len(languageMessagesCache) == 0 {
-		RefreshLanguageMessagesCache()
-	}
 	var (
+		cache    = getLanguageMessagesCache()
 		messages LanguageMessagesMap
 		lang     string
 	)
@@ -282,7 +354,12 @@ func GetUserLanguageMessages(c *gin.Context, userLang ...string) LanguageMessage
 			lang = ParseLang(c)
 		}
 	}
-	messages = languageMessagesCache[lang]
+	if tenantID := GetRoutineTenantID(); tenantID != 0 {
+		if v := cache.Tenants[tenantID][lang]; v != nil {
+			return v
+		}
+	}
+	messages = cache.Global[lang]
 	return messages
 }
 
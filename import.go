@@ -115,7 +115,7 @@ func ReimportRecord(importSn string) error {
 	if record.Sync {
 		CallImportCallback(&record)
 	} else {
-		go CallImportCallback(&record)
+		GoWithSpan(func() { CallImportCallback(&record) })
 	}
 	return nil
 }
@@ -125,22 +125,36 @@ func CallImportCallback(info *ImportRecord) {
 	if info == nil {
 		return
 	}
+	importQueueDepth.Inc()
+	defer importQueueDepth.Dec()
+	span := StartSpan(fmt.Sprintf("import %s", info.Channel))
+	span.SetAttribute("import.channel", info.Channel)
+	span.SetAttribute("import.sn", info.ImportSn)
+	defer span.End()
+	WithSpan(span, func() {
+		span.SetError(callImportCallback(info))
+	})
+}
 
+func callImportCallback(info *ImportRecord) error {
 	var rows [][]string
 	if err := JSONParse(info.Data, &rows); err != nil {
 		ERROR(err)
-		return
+		return err
 	}
 
 	callback := importCallbackMap[info.Channel]
 	if callback == nil {
-		ERROR("no import callback registered for this channel: %s", info.Channel)
-		return
+		err := fmt.Errorf("no import callback registered for this channel: %s", info.Channel)
+		ERROR(err)
+		return err
 	}
 
 	context := &ImportContext{}
 	_ = JSONParse(info.Context, context)
 	args := callback
+	span := GetRoutineSpan()
+	span.SetAttribute("import.rows", len(rows))
 	result := args.Processor(context, rows)
 	if result == nil {
 		result = &ImportCallbackResult{Message: "success"}
@@ -161,7 +175,9 @@ func CallImportCallback(info *ImportRecord) {
 	}
 	if err := db.Update(&doc).Error; err != nil {
 		ERROR(err)
+		return err
 	}
+	return result.Error
 }
 
 // ImportRoute
@@ -242,7 +258,7 @@ var ImportRoute = RouteInfo{
 		if record.Sync {
 			CallImportCallback(&record)
 		} else {
-			go CallImportCallback(&record)
+			GoWithSpan(func() { CallImportCallback(&record) })
 		}
 		// 响应请求
 		c.STD(record.ImportSn)
@@ -1,17 +1,556 @@
 package kuu
 
-import "github.com/robfig/cron/v3"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
 
 // DefaultCron (set option 5 cron to convet 6 cron)
 var DefaultCron = cron.New(cron.WithSeconds())
 
-// Job
+const (
+	// JobStatusSuccess
+	JobStatusSuccess = "success"
+	// JobStatusFailed
+	JobStatusFailed = "failed"
+	// JobStatusPanic
+	JobStatusPanic = "panic"
+	// JobStatusTimeout
+	JobStatusTimeout = "timeout"
+	// JobStatusRunning
+	JobStatusRunning = "running"
+
+	// JobTriggerCron
+	JobTriggerCron = "cron"
+	// JobTriggerManual
+	JobTriggerManual = "manual"
+)
+
+var (
+	// ErrJobNotFound
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobRunning
+	ErrJobRunning = errors.New("job is running")
+
+	jobs          = make(map[string]*Job)
+	jobsMu        sync.RWMutex
+	jobStoreReady int32
+)
+
+// Job 定时任务，Cmd和Run二选一，超时仅对Run生效（Run可感知超时并返回错误）
 type Job struct {
-	Spec string
-	Cmd  func()
+	ID           uint                        `gorm:"primary_key"`
+	CreatedAt    time.Time                   `name:"创建时间"`
+	UpdatedAt    time.Time                   `name:"修改时间"`
+	Code         string                      `name:"任务编码" gorm:"unique_index;not null"`
+	Name         string                      `name:"任务名称"`
+	Spec         string                      `name:"执行计划"`
+	Timeout      int                         `name:"超时时间（秒）"`
+	Singleton    bool                        `name:"是否单实例执行"`
+	Paused       bool                        `name:"是否暂停"`
+	LastRunAt    *time.Time                  `name:"最近执行时间"`
+	LastStatus   string                      `name:"最近执行状态"`
+	LastError    string                      `name:"最近执行错误" gorm:"type:text"`
+	LastDuration int64                       `name:"最近执行耗时（毫秒）"`
+	Running      bool                        `name:"是否执行中" gorm:"-"`
+	NextRunAt    *time.Time                  `name:"下次执行时间" gorm:"-"`
+	Cmd          func()                      `gorm:"-" json:"-"`
+	Run          func(context.Context) error `gorm:"-" json:"-"`
+
+	entryID cron.EntryID
+	running int32
+	paused  int32
+}
+
+// IgnoreLog
+func (j *Job) IgnoreLog() {}
+
+// isPaused 已注册任务的暂停状态，Paused字段仅用于持久化和接口输出
+func (j *Job) isPaused() bool {
+	return atomic.LoadInt32(&j.paused) == 1
 }
 
-// AddJob
+func (j *Job) setPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&j.paused, v)
+}
+
+// JobRun 定时任务执行记录
+type JobRun struct {
+	ID       uint       `gorm:"primary_key"`
+	JobCode  string     `name:"任务编码" sql:"index"`
+	Trigger  string     `name:"触发方式"`
+	Start    time.Time  `name:"开始时间" sql:"index"`
+	End      *time.Time `name:"结束时间"`
+	Duration int64      `name:"耗时（毫秒）"`
+	Status   string     `name:"执行状态"`
+	Error    string     `name:"错误信息" gorm:"type:text"`
+	Stack    string     `name:"异常堆栈" gorm:"type:text"`
+}
+
+// IgnoreLog
+func (j *JobRun) IgnoreLog() {}
+
+// AddJob 注册定时任务，任务编码取自函数名
 func AddJob(spec string, cmd func()) (cron.EntryID, error) {
-	return DefaultCron.AddFunc(spec, cmd)
+	code := funcName(cmd)
+	jobsMu.RLock()
+	for i := 2; jobs[code] != nil; i++ {
+		code = fmt.Sprintf("%s#%d", funcName(cmd), i)
+	}
+	jobsMu.RUnlock()
+	return RegisterJob(&Job{Code: code, Name: code, Spec: spec, Cmd: cmd})
+}
+
+// RegisterJob 注册定时任务，任务编码不可重复
+func RegisterJob(job *Job) (cron.EntryID, error) {
+	if job == nil || job.Code == "" || (job.Cmd == nil && job.Run == nil) {
+		return 0, errors.New("job code and command are required")
+	}
+	if job.Name == "" {
+		job.Name = job.Code
+	}
+	job.setPaused(job.Paused)
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if _, exists := jobs[job.Code]; exists {
+		return 0, fmt.Errorf("job already exists: %s", job.Code)
+	}
+	entryID, err := DefaultCron.AddFunc(job.Spec, func() {
+		_, _ = runJob(job, JobTriggerCron)
+	})
+	if err != nil {
+		return 0, err
+	}
+	job.entryID = entryID
+	jobs[job.Code] = job
+	if atomic.LoadInt32(&jobStoreReady) == 1 {
+		syncJob(job)
+	}
+	return entryID, nil
+}
+
+func funcName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimPrefix(name, "kuu.")
+}
+
+// initJobStore 任务表就绪后同步已注册的任务
+func initJobStore() {
+	atomic.StoreInt32(&jobStoreReady, 1)
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	for _, job := range jobs {
+		syncJob(job)
+	}
+}
+
+// syncJob 同步任务定义，暂停状态以数据库为准
+func syncJob(job *Job) {
+	var stored Job
+	db := DB().Where(&Job{Code: job.Code}).Attrs(Job{Name: job.Name, Spec: job.Spec, Timeout: job.Timeout, Singleton: job.Singleton}).FirstOrCreate(&stored)
+	if db.Error != nil {
+		ERROR("Sync job %s failed: %s", job.Code, db.Error.Error())
+		return
+	}
+	if stored.Name != job.Name || stored.Spec != job.Spec || stored.Timeout != job.Timeout || stored.Singleton != job.Singleton {
+		DB().Model(&stored).Updates(map[string]interface{}{"name": job.Name, "spec": job.Spec, "timeout": job.Timeout, "singleton": job.Singleton})
+	}
+	job.setPaused(stored.Paused)
+}
+
+// jobPaused 多副本部署时暂停状态从数据库读取
+func jobPaused(job *Job) bool {
+	if atomic.LoadInt32(&jobStoreReady) == 1 {
+		var stored Job
+		if err := DB().Select("paused").Where(&Job{Code: job.Code}).First(&stored).Error; err == nil {
+			job.setPaused(stored.Paused)
+		}
+	}
+	return job.isPaused()
+}
+
+// GetJob
+func GetJob(code string) *Job {
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	return jobs[code]
+}
+
+// ListJobs 返回已注册的任务及其运行状态
+func ListJobs() []Job {
+	jobsMu.RLock()
+	list := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		item := Job{
+			Code:      job.Code,
+			Name:      job.Name,
+			Spec:      job.Spec,
+			Timeout:   job.Timeout,
+			Singleton: job.Singleton,
+			Paused:    job.isPaused(),
+			Running:   atomic.LoadInt32(&job.running) == 1,
+		}
+		if next := DefaultCron.Entry(job.entryID).Next; !next.IsZero() {
+			item.NextRunAt = &next
+		}
+		list = append(list, item)
+	}
+	jobsMu.RUnlock()
+	if atomic.LoadInt32(&jobStoreReady) == 1 {
+		var stored []Job
+		if err := DB().Find(&stored).Error; err == nil {
+			storedMap := make(map[string]Job)
+			for _, item := range stored {
+				storedMap[item.Code] = item
+			}
+			for i, item := range list {
+				if s, ok := storedMap[item.Code]; ok {
+					list[i].ID = s.ID
+					list[i].CreatedAt = s.CreatedAt
+					list[i].UpdatedAt = s.UpdatedAt
+					list[i].Paused = s.Paused
+					list[i].LastRunAt = s.LastRunAt
+					list[i].LastStatus = s.LastStatus
+					list[i].LastError = s.LastError
+					list[i].LastDuration = s.LastDuration
+				}
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+func setJobPaused(code string, paused bool) error {
+	job := GetJob(code)
+	if job == nil {
+		return ErrJobNotFound
+	}
+	if atomic.LoadInt32(&jobStoreReady) == 1 {
+		if err := DB().Model(&Job{}).Where(&Job{Code: code}).Update("paused", paused).Error; err != nil {
+			return err
+		}
+	}
+	job.setPaused(paused)
+	return nil
+}
+
+// PauseJob
+func PauseJob(code string) error {
+	return setJobPaused(code, true)
+}
+
+// ResumeJob
+func ResumeJob(code string) error {
+	return setJobPaused(code, false)
+}
+
+// RunJob 立即执行任务（忽略暂停状态），单例任务在其他实例执行中时返回ErrLockNotAcquired
+func RunJob(code string) (*JobRun, error) {
+	job := GetJob(code)
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	return runJob(job, JobTriggerManual)
+}
+
+// StartJob 在后台立即执行任务（忽略暂停状态），返回状态为running的执行记录，执行结束后更新该记录
+func StartJob(code string) (*JobRun, error) {
+	job := GetJob(code)
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	lock, release, _, err := acquireJobRun(job, JobTriggerManual)
+	if err != nil {
+		return nil, err
+	}
+	run := newJobRun(job, JobTriggerManual)
+	run.Status = JobStatusRunning
+	if atomic.LoadInt32(&jobStoreReady) == 1 {
+		if err := DB().Create(run).Error; err != nil {
+			atomic.StoreInt32(&job.running, 0)
+			if release != nil {
+				release()
+			}
+			return nil, err
+		}
+	}
+	started := *run
+	go execJobRun(run, job, lock, release)
+	return &started, nil
+}
+
+func runJob(job *Job, trigger string) (*JobRun, error) {
+	lock, release, skip, err := acquireJobRun(job, trigger)
+	if skip || err != nil {
+		return nil, err
+	}
+	return execJobRun(newJobRun(job, trigger), job, lock, release), nil
+}
+
+// acquireJobRun 占用running标记，单例任务获取锁；skip为true时本次定时调度不执行
+func acquireJobRun(job *Job, trigger string) (lock *Lock, release func(), skip bool, err error) {
+	if trigger == JobTriggerCron && jobPaused(job) {
+		return nil, nil, true, nil
+	}
+	if !atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+		WARN("Job %s skipped: previous run is still in progress", job.Code)
+		return nil, nil, false, ErrJobRunning
+	}
+	if !job.Singleton {
+		return nil, nil, false, nil
+	}
+	// 单例任务：同一时刻仅有一个实例执行，锁持续续期直至任务协程结束（包括超时后仍在执行的情况）
+	ttl := time.Duration(C().DefaultGetInt("job:lockTTL", 30)) * time.Second
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	lock, err = TryLock("job_"+job.Code, ttl)
+	if err != nil {
+		atomic.StoreInt32(&job.running, 0)
+		if err == ErrLockNotAcquired && trigger == JobTriggerCron {
+			return nil, nil, true, nil
+		}
+		return nil, nil, false, err
+	}
+	stop := keepLockAlive(lock, ttl)
+	release = func() {
+		stop()
+		if err := lock.Unlock(); err != nil {
+			WARN("Unlock %s failed: %s", lock.Key, err.Error())
+		}
+	}
+	return lock, release, false, nil
+}
+
+func newJobRun(job *Job, trigger string) *JobRun {
+	return &JobRun{
+		JobCode: job.Code,
+		Trigger: trigger,
+		Start:   time.Now(),
+	}
+}
+
+func execJobRun(run *JobRun, job *Job, lock *Lock, release func()) *JobRun {
+	// Cmd无法感知取消，不设超时，等待其执行结束
+	var timeout int
+	if job.Run != nil {
+		timeout = job.Timeout
+		if timeout == 0 {
+			timeout = C().DefaultGetInt("job:timeout", 0)
+		}
+	}
+	run.Status, run.Error, run.Stack = executeJob(job, time.Duration(timeout)*time.Second, lock, release)
+	end := time.Now()
+	run.End = &end
+	run.Duration = end.Sub(run.Start).Nanoseconds() / int64(time.Millisecond)
+	jobRunsTotal.Inc(job.Code, run.Status)
+	if run.Status != JobStatusSuccess {
+		jobFailuresTotal.Inc(job.Code)
+		ERROR("Job %s %s: %s", job.Code, run.Status, run.Error)
+	}
+	saveJobRun(run)
+	return run
+}
+
+type jobResult struct {
+	status string
+	err    string
+	stack  string
+}
+
+// executeJob 捕获任务异常，超时后不再等待，running标记和单例锁由执行任务的协程在结束时释放（任务结束前不会再次调度）
+func executeJob(job *Job, timeout time.Duration, lock *Lock, release func()) (status, errMsg, stackMsg string) {
+	ctx := context.Background()
+	if lock != nil {
+		ctx = ContextWithLock(ctx, lock)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	done := make(chan jobResult, 1)
+	go func() {
+		defer atomic.StoreInt32(&job.running, 0)
+		if release != nil {
+			defer release()
+		}
+		span := StartSpan(fmt.Sprintf("job %s", job.Code), nil)
+		span.SetAttribute("job.code", job.Code)
+		result := jobResult{status: JobStatusSuccess}
+		defer func() {
+			if r := recover(); r != nil {
+				result = jobResult{status: JobStatusPanic, err: fmt.Sprintf("%v", r), stack: string(stack(3))}
+			}
+			span.SetAttribute("job.status", result.status)
+			if result.err != "" {
+				span.SetError(errors.New(result.err))
+			}
+			span.End()
+			done <- result
+		}()
+		WithSpan(span, func() {
+			if job.Run != nil {
+				if err := job.Run(ctx); err != nil {
+					result = jobResult{status: JobStatusFailed, err: err.Error()}
+				}
+			} else {
+				job.Cmd()
+			}
+		})
+	}()
+	select {
+	case result := <-done:
+		return result.status, result.err, result.stack
+	case <-ctx.Done():
+		return JobStatusTimeout, ctx.Err().Error(), ""
+	}
+}
+
+func saveJobRun(run *JobRun) {
+	if atomic.LoadInt32(&jobStoreReady) != 1 {
+		return
+	}
+	// 后台执行的记录已预先创建，结束时更新
+	if err := DB().Save(run).Error; err != nil {
+		ERROR("Save job run of %s failed: %s", run.JobCode, err.Error())
+	}
+	err := DB().Model(&Job{}).Where(&Job{Code: run.JobCode}).Updates(map[string]interface{}{
+		"last_run_at":   run.Start,
+		"last_status":   run.Status,
+		"last_error":    run.Error,
+		"last_duration": run.Duration,
+	}).Error
+	if err != nil {
+		ERROR("Update job %s failed: %s", run.JobCode, err.Error())
+	}
+}
+
+// JobRunCleanupJob 清除超过job:runRetention天（默认30天）的执行记录
+func JobRunCleanupJob() {
+	days := C().DefaultGetInt("job:runRetention", 30)
+	if days <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	if err := DB().Where("start < ?", cutoff).Delete(&JobRun{}).Error; err != nil {
+		ERROR("Cleanup job runs failed: %s", err.Error())
+	}
+}
+
+// JobListRoute
+var JobListRoute = RouteInfo{
+	Name:   "查询定时任务列表",
+	Method: "GET",
+	Path:   "/jobs",
+	HandlerFunc: func(c *Context) {
+		c.STD(ListJobs())
+	},
+}
+
+func jobActionHandler(key, defaultMessage string, action func(code string) (interface{}, error)) HandlerFunc {
+	return func(c *Context) {
+		var (
+			failedMessage = c.L(key, defaultMessage)
+			body          struct {
+				Code string `binding:"required"`
+			}
+		)
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.STDErr(failedMessage, err)
+			return
+		}
+		data, err := action(body.Code)
+		if err != nil {
+			c.STDErr(failedMessage, err)
+		} else {
+			c.STD(data)
+		}
+	}
+}
+
+// JobPauseRoute
+var JobPauseRoute = RouteInfo{
+	Name:   "暂停定时任务",
+	Method: "POST",
+	Path:   "/jobs/pause",
+	HandlerFunc: jobActionHandler("job_pause_failed", "Pause job failed", func(code string) (interface{}, error) {
+		return "ok", PauseJob(code)
+	}),
+}
+
+// JobResumeRoute
+var JobResumeRoute = RouteInfo{
+	Name:   "恢复定时任务",
+	Method: "POST",
+	Path:   "/jobs/resume",
+	HandlerFunc: jobActionHandler("job_resume_failed", "Resume job failed", func(code string) (interface{}, error) {
+		return "ok", ResumeJob(code)
+	}),
+}
+
+// JobRunRoute
+var JobRunRoute = RouteInfo{
+	Name:   "立即执行定时任务",
+	Method: "POST",
+	Path:   "/jobs/run",
+	HandlerFunc: jobActionHandler("job_run_failed", "Run job failed", func(code string) (interface{}, error) {
+		return StartJob(code)
+	}),
+}
+
+// JobRunsRoute
+var JobRunsRoute = RouteInfo{
+	Name:   "查询定时任务执行记录",
+	Method: "GET",
+	Path:   "/jobs/runs",
+	HandlerFunc: func(c *Context) {
+		var (
+			failedMessage = c.L("job_runs_failed", "Query job runs failed")
+			result        BizQueryResult
+			list          []JobRun
+		)
+		result.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+		result.Size, _ = strconv.Atoi(c.DefaultQuery("size", "30"))
+		if result.Page < 1 {
+			result.Page = 1
+		}
+		if result.Size < 1 {
+			result.Size = 30
+		}
+		db := DB().Model(&JobRun{})
+		if code := c.Query("code"); code != "" {
+			db = db.Where(&JobRun{JobCode: code})
+		}
+		if err := db.Count(&result.TotalRecords).Error; err != nil {
+			c.STDErr(failedMessage, err)
+			return
+		}
+		if err := db.Order("start desc").Offset((result.Page - 1) * result.Size).Limit(result.Size).Find(&list).Error; err != nil {
+			c.STDErr(failedMessage, err)
+			return
+		}
+		result.TotalPages = (result.TotalRecords + result.Size - 1) / result.Size
+		result.List = list
+		c.STD(result)
+	},
 }
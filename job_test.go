@@ -0,0 +1,155 @@
+package kuu
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunJob(t *testing.T) {
+	cases := map[string]*Job{
+		JobStatusSuccess: {Code: "test_job_success", Spec: "@every 1h", Cmd: func() {}},
+		JobStatusPanic:   {Code: "test_job_panic", Spec: "@every 1h", Cmd: func() { panic("boom") }},
+		JobStatusFailed: {Code: "test_job_failed", Spec: "@every 1h", Run: func(ctx context.Context) error {
+			return errors.New("failed")
+		}},
+		JobStatusTimeout: {Code: "test_job_timeout", Spec: "@every 1h", Timeout: 1, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(100 * time.Millisecond)
+			return ctx.Err()
+		}},
+	}
+	for status, job := range cases {
+		if _, err := RegisterJob(job); err != nil {
+			t.Fatal(err)
+		}
+		run, err := RunJob(job.Code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run.Status != status {
+			t.Errorf("job %s: expected %s, got %s", job.Code, status, run.Status)
+		}
+		if status == JobStatusPanic && run.Stack == "" {
+			t.Errorf("job %s: missing panic stack", job.Code)
+		}
+	}
+	if _, err := RegisterJob(&Job{Code: "test_job_success", Spec: "@every 1h", Cmd: func() {}}); err == nil {
+		t.Error("duplicate job code should be rejected")
+	}
+}
+
+func TestRunJobTimeoutKeepsRunning(t *testing.T) {
+	release := make(chan struct{})
+	job := &Job{Code: "test_job_timeout_running", Spec: "@every 1h", Timeout: 1, Run: func(ctx context.Context) error {
+		<-release
+		return ctx.Err()
+	}}
+	if _, err := RegisterJob(job); err != nil {
+		t.Fatal(err)
+	}
+	run, err := RunJob(job.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != JobStatusTimeout {
+		t.Fatalf("expected %s, got %s", JobStatusTimeout, run.Status)
+	}
+	if _, err := RunJob(job.Code); err != ErrJobRunning {
+		t.Fatalf("expected ErrJobRunning while the timed out run is still in progress, got %v", err)
+	}
+	close(release)
+	for i := 0; i < 100 && atomic.LoadInt32(&job.running) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&job.running) == 1 {
+		t.Fatal("job should be cleared after the timed out run returns")
+	}
+}
+
+func TestSingletonJobKeepsLockAfterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	job := &Job{Code: "test_job_singleton_timeout", Spec: "@every 1h", Timeout: 1, Singleton: true, Run: func(ctx context.Context) error {
+		<-release
+		return nil
+	}}
+	if _, err := RegisterJob(job); err != nil {
+		t.Fatal(err)
+	}
+	run, err := RunJob(job.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != JobStatusTimeout {
+		t.Fatalf("expected %s, got %s", JobStatusTimeout, run.Status)
+	}
+	if _, err := TryLock("job_"+job.Code, time.Second); err != ErrLockNotAcquired {
+		t.Fatalf("lock should be held while the timed out run is in progress, got %v", err)
+	}
+	close(release)
+	for i := 0; i < 100 && atomic.LoadInt32(&job.running) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock, err := TryLock("job_"+job.Code, time.Second)
+	if err != nil {
+		t.Fatalf("lock should be released after the run returns, got %v", err)
+	}
+	_ = lock.Unlock()
+}
+
+func TestStartJob(t *testing.T) {
+	release := make(chan struct{})
+	job := &Job{Code: "test_job_start", Spec: "@every 1h", Cmd: func() { <-release }}
+	if _, err := RegisterJob(job); err != nil {
+		t.Fatal(err)
+	}
+	run, err := StartJob(job.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != JobStatusRunning || run.JobCode != job.Code || run.Trigger != JobTriggerManual {
+		t.Fatalf("unexpected run: %+v", run)
+	}
+	if _, err := StartJob(job.Code); err != ErrJobRunning {
+		t.Fatalf("expected ErrJobRunning, got %v", err)
+	}
+	close(release)
+	for i := 0; i < 100 && atomic.LoadInt32(&job.running) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&job.running) == 1 {
+		t.Fatal("job should be cleared after the run returns")
+	}
+	if _, err := StartJob("test_job_missing"); err != ErrJobNotFound {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestJobPausedConcurrent(t *testing.T) {
+	job := &Job{Code: "test_job_paused", Spec: "@every 1h", Cmd: func() {}}
+	if _, err := RegisterJob(job); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = PauseJob(job.Code)
+			_ = ResumeJob(job.Code)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ListJobs()
+	}
+	<-done
+	if err := PauseJob(job.Code); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range ListJobs() {
+		if item.Code == job.Code && !item.Paused {
+			t.Fatal("job should be paused")
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -26,6 +27,14 @@ var (
 	GLSSignInfoKey = "SignInfo"
 	// GLSIgnoreAuthKey
 	GLSIgnoreAuthKey = "IgnoreAuth"
+	// GLSIgnoreTenantKey
+	GLSIgnoreTenantKey = "IgnoreTenant"
+	// GLSReadPrimaryKey
+	GLSReadPrimaryKey = "ReadPrimary"
+	// ReadPrimaryHeaderKey
+	ReadPrimaryHeaderKey = "X-Read-Primary"
+	// ExpectedTsHeaderKey
+	ExpectedTsHeaderKey = "X-Expected-Ts"
 	// GLSRoutineCachesKey
 	GLSRoutineCachesKey = "RoutineCaches"
 	// GLSRequestContextKey
@@ -90,6 +99,7 @@ func GetGLSValue(key interface{}) (value interface{}, ok bool) {
 
 func beforeRun() {
 	DefaultCron.Start()
+	atomic.StoreInt32(&cronRunning, 1)
 }
 
 func shutdown(srv *http.Server) {
@@ -171,11 +181,22 @@ var ConvertKuuHandlers = func(chain HandlersChain) (handlers gin.HandlersChain)
 				desc := GetPrivilegesDesc(kc.SignInfo)
 				kc.PrisDesc = desc
 			}
+			// 未挂载TraceMiddleware时由处理函数自行开启span
+			span := GetSpan(c)
+			if span == nil && TracingEnabled() && GetTraceContext(c) == nil {
+				if span = startServerSpan(c, NewTraceContext()); span != nil {
+					c.Set(SpanKey, span)
+					defer endServerSpan(c, span)
+				}
+			}
 			glsVals := make(gls.Values)
 			glsVals[GLSSignInfoKey] = kc.SignInfo
 			glsVals[GLSPrisDescKey] = kc.PrisDesc
 			glsVals[GLSRoutineCachesKey] = kc.RoutineCaches
 			glsVals[GLSRequestContextKey] = kc
+			glsVals[GLSRequestIDKey] = GetRequestID(c)
+			glsVals[GLSTraceContextKey] = GetTraceContext(c)
+			glsVals[GLSSpanKey] = span
 			SetGLSValues(glsVals, func() {
 				if InWhitelist(c) {
 					IgnoreAuth()
@@ -383,6 +404,9 @@ func GetAppName() string {
 
 // Release
 func Release() {
+	StopTaskWorkers()
+	shutdownTracing()
+	closeLogSinks()
 	releaseDB()
 	releaseCacheDB()
 }
@@ -0,0 +1,200 @@
+package kuu
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultLockTTL 未指定过期时间时锁的默认有效期
+const DefaultLockTTL = 30 * time.Second
+
+var (
+	// ErrLockNotAcquired 锁已被其他实例持有
+	ErrLockNotAcquired = errors.New("lock not acquired")
+	// ErrLockNotHeld 锁已过期或已被其他实例持有
+	ErrLockNotHeld = errors.New("lock not held")
+
+	defaultMemoryLocker = NewMemoryLocker()
+)
+
+// Locker 分布式锁，Cache实现该接口时优先使用
+type Locker interface {
+	TryLock(key string, ttl time.Duration) (*Lock, error)
+	Unlock(lock *Lock) error
+	Refresh(lock *Lock, ttl time.Duration) error
+}
+
+// Lock 已获取的锁，Token为单调递增的fencing token，写入外部资源时可用于拒绝过期持有者
+type Lock struct {
+	Key   string
+	Token int64
+	value string
+	owner Locker
+}
+
+// Unlock
+func (l *Lock) Unlock() error {
+	return l.owner.Unlock(l)
+}
+
+// Refresh
+func (l *Lock) Refresh(ttl time.Duration) error {
+	return l.owner.Refresh(l, ttl)
+}
+
+// MemoryLocker 进程内锁，适用于单节点部署
+type MemoryLocker struct {
+	mu        sync.Mutex
+	locks     map[string]memoryLockEntry
+	counter   int64
+	tokenFunc func(key string) int64
+}
+
+type memoryLockEntry struct {
+	value    string
+	expireAt time.Time
+}
+
+// NewMemoryLocker
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{locks: make(map[string]memoryLockEntry)}
+}
+
+// TryLock
+func (m *MemoryLocker) TryLock(key string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.locks[key]; ok && time.Now().Before(entry.expireAt) {
+		return nil, ErrLockNotAcquired
+	}
+	lock := &Lock{Key: key, value: uuid.NewV4().String(), owner: m}
+	if m.tokenFunc != nil {
+		lock.Token = m.tokenFunc(key)
+	} else {
+		lock.Token = atomic.AddInt64(&m.counter, 1)
+	}
+	m.locks[key] = memoryLockEntry{value: lock.value, expireAt: time.Now().Add(ttl)}
+	return lock, nil
+}
+
+// Unlock
+func (m *MemoryLocker) Unlock(lock *Lock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.locks[lock.Key]
+	if !ok || entry.value != lock.value {
+		return ErrLockNotHeld
+	}
+	delete(m.locks, lock.Key)
+	if time.Now().After(entry.expireAt) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh
+func (m *MemoryLocker) Refresh(lock *Lock, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.locks[lock.Key]
+	if !ok || entry.value != lock.value || time.Now().After(entry.expireAt) {
+		return ErrLockNotHeld
+	}
+	entry.expireAt = time.Now().Add(ttl)
+	m.locks[lock.Key] = entry
+	return nil
+}
+
+// GetLocker 返回当前缓存提供的锁实现，缓存不支持时使用进程内锁
+func GetLocker() Locker {
+	if v, ok := DefaultCache.(Locker); ok {
+		return v
+	}
+	return defaultMemoryLocker
+}
+
+// TryLock 尝试获取锁，已被持有时返回ErrLockNotAcquired
+func TryLock(key string, ttl time.Duration) (*Lock, error) {
+	return GetLocker().TryLock(key, ttl)
+}
+
+// AcquireLock 在wait时间内重试获取锁
+func AcquireLock(key string, ttl, wait time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lock, err := TryLock(key, ttl)
+		if err != ErrLockNotAcquired || !time.Now().Before(deadline) {
+			return lock, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// WithLock 持有锁执行fn，执行期间自动续期，结束后释放
+func WithLock(key string, ttl time.Duration, fn func(lock *Lock) error) error {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	lock, err := TryLock(key, ttl)
+	if err != nil {
+		return err
+	}
+	stop := keepLockAlive(lock, ttl)
+	defer func() {
+		stop()
+		if err := lock.Unlock(); err != nil {
+			WARN("Unlock %s failed: %s", key, err.Error())
+		}
+	}()
+	return fn(lock)
+}
+
+// keepLockAlive 每隔ttl/3续期一次，直到调用返回的stop
+func keepLockAlive(lock *Lock, ttl time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lock.Refresh(ttl); err != nil {
+					WARN("Refresh lock %s failed: %s", lock.Key, err.Error())
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+type lockContextKey struct{}
+
+// ContextWithLock
+func ContextWithLock(ctx context.Context, lock *Lock) context.Context {
+	return context.WithValue(ctx, lockContextKey{}, lock)
+}
+
+// LockFromContext 获取单例任务持有的锁，用于读取fencing token
+func LockFromContext(ctx context.Context) *Lock {
+	if lock, ok := ctx.Value(lockContextKey{}).(*Lock); ok {
+		return lock
+	}
+	return nil
+}
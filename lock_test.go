@@ -0,0 +1,41 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	locker := NewMemoryLocker()
+	first, err := locker.TryLock("test", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locker.TryLock("test", time.Second); err != ErrLockNotAcquired {
+		t.Fatalf("expected ErrLockNotAcquired, got %v", err)
+	}
+	if err := first.Refresh(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := locker.TryLock("test", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token <= first.Token {
+		t.Errorf("fencing token should increase: %d <= %d", second.Token, first.Token)
+	}
+	time.Sleep(100 * time.Millisecond)
+	third, err := locker.TryLock("test", time.Second)
+	if err != nil {
+		t.Fatal("expired lock should be acquirable")
+	}
+	if err := second.Unlock(); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if err := third.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -53,6 +53,8 @@ func init() {
 		Logger.AddHook(&LogDailyFileHook{})
 		Logger.AddHook(&LogBizHook{})
 	}
+	logrus.AddHook(&LogTraceHook{})
+	Logger.AddHook(&LogTraceHook{})
 }
 
 func initEnums() {
@@ -60,7 +62,8 @@ func initEnums() {
 		Add(LogTypeSign, "登录日志").
 		Add(LogTypeAPI, "接口日志").
 		Add(LogTypeAudit, "审计日志").
-		Add(LogTypeBiz, "业务日志")
+		Add(LogTypeBiz, "业务日志").
+		Add(LogTypeSlowSQL, "慢查询日志")
 
 	Enum("AuditType", "审计类型").
 		Add(AuditTypeCreate, "新增操作").
@@ -78,6 +81,8 @@ type Log struct {
 	UUID       string `name:"数据ID（UUID）" rest:"*" displayName:"系统日志"`
 	Time       int64  `name:"记录时间（Unix时间戳）"`
 	Type       string `name:"日志类型" enum:"LogType"`
+	RequestID  string `name:"请求ID" sql:"index"`
+	TraceID    string `name:"链路ID" sql:"index"`
 	// 用户信息
 	UID        uint   `name:"用户ID" sql:"index"`
 	SubDocID   uint   `name:"用户子档案ID"`
@@ -180,10 +185,21 @@ func (l *Log) CacheKey() string {
 	)
 }
 
-// Save2Cache
+// Save 按日志类型写入配置的输出
+func (l *Log) Save() {
+	DispatchLog(l)
+}
+
+// logBacklogKey 待持久化的日志数量，键名不能以BuildKey("log")开头，避免被LogPersisJob读取
+func logBacklogKey() string {
+	return BuildKey("backlog", "log")
+}
+
+// Save2Cache 写入缓存，由LogPersisJob持久化
 func (l *Log) Save2Cache() {
 	if key := l.CacheKey(); key != "" {
 		SetCacheString(key, JSONStringify(l))
+		IncrCache(logBacklogKey())
 	}
 }
 
@@ -231,6 +247,18 @@ func NewLog(logType string, context ...*gin.Context) (log *Log) {
 		}
 	}
 
+	if c != nil {
+		log.RequestID = GetRequestID(c)
+		if trace := GetTraceContext(c); trace != nil {
+			log.TraceID = trace.TraceID
+		}
+	} else {
+		log.RequestID = GetRoutineRequestID()
+		if trace := GetRoutineTraceContext(); trace != nil {
+			log.TraceID = trace.TraceID
+		}
+	}
+
 	if desc := GetRoutinePrivilegesDesc(); desc != nil {
 		log.ActOrgID = desc.ActOrgID
 		log.ActOrgCode = desc.ActOrgCode
@@ -275,7 +303,13 @@ func NewLog(logType string, context ...*gin.Context) (log *Log) {
 
 // 日志序列化任务
 func LogPersisJob() {
-	err := WithTransaction(func(tx *gorm.DB) error {
+	if err := persistLogs(); err != nil {
+		ERROR("Persist logs failed: %s", err.Error())
+	}
+}
+
+func persistLogs() error {
+	return WithTransaction(func(tx *gorm.DB) error {
 		data := HasPrefixCache(BuildKey("log"), 5000)
 
 		if len(data) == 0 {
@@ -283,152 +317,154 @@ func LogPersisJob() {
 		}
 
 		var (
-			totalKeys  []string
-			insertBase = fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s) VALUES ",
-				tx.Dialect().Quote("sys_Log"),
-				tx.Dialect().Quote("uuid"),
-				tx.Dialect().Quote("time"),
-				tx.Dialect().Quote("type"),
-				tx.Dialect().Quote("content_human"),
-				tx.Dialect().Quote("content_data"),
-				// 用户信息
-				tx.Dialect().Quote("uid"),
-				tx.Dialect().Quote("sub_doc_id"),
-				tx.Dialect().Quote("username"),
-				tx.Dialect().Quote("real_name"),
-				tx.Dialect().Quote("token"),
-				// 认证信息
-				tx.Dialect().Quote("sign_method"),
-				tx.Dialect().Quote("sign_type"),
-				tx.Dialect().Quote("sign_payload"),
-				// 请求信息
-				tx.Dialect().Quote("request_user_agent"),
-				tx.Dialect().Quote("request_method"),
-				tx.Dialect().Quote("request_path"),
-				tx.Dialect().Quote("request_content_length"),
-				tx.Dialect().Quote("request_referer"),
-				tx.Dialect().Quote("request_is_websocket"),
-				tx.Dialect().Quote("request_is_mobile"),
-				tx.Dialect().Quote("request_content_type"),
-				tx.Dialect().Quote("request_headers"),
-				tx.Dialect().Quote("request_query"),
-				tx.Dialect().Quote("request_cost"),
-				tx.Dialect().Quote("request_ip"),
-				tx.Dialect().Quote("request_country"),
-				tx.Dialect().Quote("request_city"),
-				tx.Dialect().Quote("request_client_localization"),
-				tx.Dialect().Quote("request_client_browser_engine"),
-				tx.Dialect().Quote("request_client_browser_engine_version"),
-				tx.Dialect().Quote("request_client_browser_name"),
-				tx.Dialect().Quote("request_client_browser_version"),
-				tx.Dialect().Quote("request_client_platform"),
-				tx.Dialect().Quote("request_client_os_full_name"),
-				tx.Dialect().Quote("request_client_os_name"),
-				tx.Dialect().Quote("request_client_os_version"),
-				tx.Dialect().Quote("request_error_message"),
-				tx.Dialect().Quote("response_status_code"),
-				tx.Dialect().Quote("response_body_size"),
-				// 审计信息
-				tx.Dialect().Quote("audit_type"),
-				tx.Dialect().Quote("audit_tag"),
-				tx.Dialect().Quote("audit_model"),
-				tx.Dialect().Quote("audit_sql"),
-				tx.Dialect().Quote("audit_sql_vars"),
-				// 业务日志
-				tx.Dialect().Quote("level"),
-			)
-			insertItems []BatchInsertItem
+			totalKeys []string
+			logs      []*Log
 		)
 		for key, value := range data {
 			totalKeys = append(totalKeys, key)
 			var item Log
 			if err := JSONParse(value, &item); err == nil && item.UUID != "" {
-				insertItems = append(insertItems, BatchInsertItem{
-					SQL: "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-					Vars: []interface{}{
-						item.UUID,
-						item.Time,
-						item.Type,
-						item.ContentHuman,
-						item.ContentData,
-						// 用户信息
-						item.UID,
-						item.SubDocID,
-						item.Username,
-						item.RealName,
-						item.Token,
-						// 认证信息
-						item.SignMethod,
-						item.SignType,
-						item.SignPayload,
-						// 请求信息
-						item.RequestUserAgent,
-						item.RequestMethod,
-						item.RequestPath,
-						item.RequestContentLength,
-						item.RequestReferer,
-						item.RequestIsWebsocket,
-						item.RequestIsMobile,
-						item.RequestContentType,
-						item.RequestHeaders,
-						item.RequestQuery,
-						item.RequestCost,
-						item.RequestIP,
-						item.RequestCountry,
-						item.RequestCity,
-						item.RequestClientLocalization,
-						item.RequestClientBrowserEngine,
-						item.RequestClientBrowserEngineVersion,
-						item.RequestClientBrowserName,
-						item.RequestClientBrowserVersion,
-						item.RequestClientPlatform,
-						item.RequestClientOSFullName,
-						item.RequestClientOSName,
-						item.RequestClientOSVersion,
-						item.RequestErrorMessage,
-						item.ResponseStatusCode,
-						item.ResponseBodySize,
-						// 审计信息
-						item.AuditType,
-						item.AuditTag,
-						item.AuditModel,
-						item.AuditSQL,
-						item.AuditSQLVars,
-						// 业务日志
-						item.Level,
-					},
-				})
+				logs = append(logs, &item)
 			}
 		}
 
-		if err := BatchInsert(tx, insertBase, insertItems, 200); err != nil {
+		if err := insertLogs(tx, logs); err != nil {
 			return err
 		} else {
 			DelCache(totalKeys...)
+			IncrByCache(logBacklogKey(), -len(totalKeys))
 		}
 
 		return tx.Error
 	})
+}
 
-	if err != nil {
-		fmt.Println(err)
+// insertLogs 批量写入日志
+func insertLogs(tx *gorm.DB, logs []*Log) error {
+	var (
+		insertBase = fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s) VALUES ",
+			tx.Dialect().Quote("sys_Log"),
+			tx.Dialect().Quote("uuid"),
+			tx.Dialect().Quote("time"),
+			tx.Dialect().Quote("type"),
+			tx.Dialect().Quote("request_id"),
+			tx.Dialect().Quote("trace_id"),
+			tx.Dialect().Quote("content_human"),
+			tx.Dialect().Quote("content_data"),
+			// 用户信息
+			tx.Dialect().Quote("uid"),
+			tx.Dialect().Quote("sub_doc_id"),
+			tx.Dialect().Quote("username"),
+			tx.Dialect().Quote("real_name"),
+			tx.Dialect().Quote("token"),
+			// 认证信息
+			tx.Dialect().Quote("sign_method"),
+			tx.Dialect().Quote("sign_type"),
+			tx.Dialect().Quote("sign_payload"),
+			// 请求信息
+			tx.Dialect().Quote("request_user_agent"),
+			tx.Dialect().Quote("request_method"),
+			tx.Dialect().Quote("request_path"),
+			tx.Dialect().Quote("request_content_length"),
+			tx.Dialect().Quote("request_referer"),
+			tx.Dialect().Quote("request_is_websocket"),
+			tx.Dialect().Quote("request_is_mobile"),
+			tx.Dialect().Quote("request_content_type"),
+			tx.Dialect().Quote("request_headers"),
+			tx.Dialect().Quote("request_query"),
+			tx.Dialect().Quote("request_cost"),
+			tx.Dialect().Quote("request_ip"),
+			tx.Dialect().Quote("request_country"),
+			tx.Dialect().Quote("request_city"),
+			tx.Dialect().Quote("request_client_localization"),
+			tx.Dialect().Quote("request_client_browser_engine"),
+			tx.Dialect().Quote("request_client_browser_engine_version"),
+			tx.Dialect().Quote("request_client_browser_name"),
+			tx.Dialect().Quote("request_client_browser_version"),
+			tx.Dialect().Quote("request_client_platform"),
+			tx.Dialect().Quote("request_client_os_full_name"),
+			tx.Dialect().Quote("request_client_os_name"),
+			tx.Dialect().Quote("request_client_os_version"),
+			tx.Dialect().Quote("request_error_message"),
+			tx.Dialect().Quote("response_status_code"),
+			tx.Dialect().Quote("response_body_size"),
+			// 审计信息
+			tx.Dialect().Quote("audit_type"),
+			tx.Dialect().Quote("audit_tag"),
+			tx.Dialect().Quote("audit_model"),
+			tx.Dialect().Quote("audit_sql"),
+			tx.Dialect().Quote("audit_sql_vars"),
+			// 业务日志
+			tx.Dialect().Quote("level"),
+		)
+		insertItems []BatchInsertItem
+	)
+	for _, item := range logs {
+		insertItems = append(insertItems, BatchInsertItem{
+			SQL: "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			Vars: []interface{}{
+				item.UUID,
+				item.Time,
+				item.Type,
+				item.RequestID,
+				item.TraceID,
+				item.ContentHuman,
+				item.ContentData,
+				// 用户信息
+				item.UID,
+				item.SubDocID,
+				item.Username,
+				item.RealName,
+				item.Token,
+				// 认证信息
+				item.SignMethod,
+				item.SignType,
+				item.SignPayload,
+				// 请求信息
+				item.RequestUserAgent,
+				item.RequestMethod,
+				item.RequestPath,
+				item.RequestContentLength,
+				item.RequestReferer,
+				item.RequestIsWebsocket,
+				item.RequestIsMobile,
+				item.RequestContentType,
+				item.RequestHeaders,
+				item.RequestQuery,
+				item.RequestCost,
+				item.RequestIP,
+				item.RequestCountry,
+				item.RequestCity,
+				item.RequestClientLocalization,
+				item.RequestClientBrowserEngine,
+				item.RequestClientBrowserEngineVersion,
+				item.RequestClientBrowserName,
+				item.RequestClientBrowserVersion,
+				item.RequestClientPlatform,
+				item.RequestClientOSFullName,
+				item.RequestClientOSName,
+				item.RequestClientOSVersion,
+				item.RequestErrorMessage,
+				item.ResponseStatusCode,
+				item.ResponseBodySize,
+				// 审计信息
+				item.AuditType,
+				item.AuditTag,
+				item.AuditModel,
+				item.AuditSQL,
+				item.AuditSQLVars,
+				// 业务日志
+				item.Level,
+			},
+		})
 	}
+	return BatchInsert(tx, insertBase, insertItems, 200)
 }
 
 // LogCleanupJob
 func LogCleanupJob() {
-	var (
-		dest   = (24 * time.Hour) * 100 // 默认清除100天前的记录
-		divide = time.Now().Add(-dest).Unix()
-		db     = DB()
-	)
-
-	err := db.Unscoped().
-		Where(fmt.Sprintf("%s < ?", db.Dialect().Quote("time")), divide).
-		Delete(&Log{}).Error
-
-	if err != nil {
-		fmt.Println(err)
+	if err := cleanupLogs(); err != nil {
+		ERROR("Cleanup logs failed: %s", err.Error())
 	}
 }
 
@@ -0,0 +1,174 @@
+package kuu
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// logRetentionDays 读取logs:retention配置（单位：天），支持按日志类型配置，default为默认值（默认100天），小于等于0表示永久保留
+func logRetentionDays(logType string) int {
+	retention := map[string]int{"default": 100}
+	C().GetInterface("logs:retention", &retention)
+	if v, ok := retention[logType]; ok {
+		return v
+	}
+	if v, ok := retention["default"]; ok {
+		return v
+	}
+	return 100
+}
+
+// logArchiveDir 归档目录，默认为日志目录下的archive
+func logArchiveDir() string {
+	dir := LogDir
+	if dir == "" {
+		dir = "logs"
+	}
+	return C().DefaultGetString("logs:archiveDir", path.Join(dir, "archive"))
+}
+
+func cleanupLogs() error {
+	var (
+		retention map[string]int
+		types     = []string{LogTypeSign, LogTypeAPI, LogTypeAudit, LogTypeBiz, LogTypeSlowSQL}
+		known     = make(map[string]bool)
+	)
+	C().GetInterface("logs:retention", &retention)
+	for key := range retention {
+		if key != "default" {
+			types = append(types, key)
+		}
+	}
+	for _, logType := range types {
+		if known[logType] {
+			continue
+		}
+		known[logType] = true
+		db := DB().Where(fmt.Sprintf("%s = ?", DB().Dialect().Quote("type")), logType)
+		if err := cleanupLogsBy(db, logType, logRetentionDays(logType)); err != nil {
+			return err
+		}
+	}
+	// 未单独配置的其他类型使用默认值
+	db := DB().Where(fmt.Sprintf("%s NOT IN (?)", DB().Dialect().Quote("type")), types)
+	return cleanupLogsBy(db, "other", logRetentionDays("default"))
+}
+
+// cleanupLogsBy 分批删除过期日志，开启logs:archive时删除前写入gzip压缩的JSONL文件
+func cleanupLogsBy(db *gorm.DB, name string, days int) error {
+	if days <= 0 {
+		return nil
+	}
+	var (
+		divide    = time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix()
+		batchSize = C().DefaultGetInt("logs:cleanupBatchSize", 1000)
+		archive   = C().DefaultGetBool("logs:archive", false)
+		archiver  *logArchiver
+		total     int
+	)
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	defer func() {
+		if archiver != nil {
+			ERROR(archiver.Close())
+		}
+	}()
+	db = db.Unscoped().Where(fmt.Sprintf("%s < ?", DB().Dialect().Quote("time")), divide)
+	for {
+		var ids []uint
+		if archive {
+			var list []Log
+			if err := db.Order("id asc").Limit(batchSize).Find(&list).Error; err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				break
+			}
+			if archiver == nil {
+				var err error
+				if archiver, err = newLogArchiver(name); err != nil {
+					return err
+				}
+			}
+			// 归档写入成功后才删除
+			if err := archiver.Write(list); err != nil {
+				return err
+			}
+			for _, item := range list {
+				ids = append(ids, item.ID)
+			}
+		} else if err := db.Model(&Log{}).Order("id asc").Limit(batchSize).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if err := DB().Unscoped().Where("id IN (?)", ids).Delete(&Log{}).Error; err != nil {
+			return err
+		}
+		total += len(ids)
+		if len(ids) < batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		INFO("Cleaned up %d %s logs older than %d days", total, name, days)
+	}
+	return nil
+}
+
+type logArchiver struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	writer *bufio.Writer
+}
+
+func newLogArchiver(name string) (*logArchiver, error) {
+	dir := logArchiveDir()
+	EnsureDir(dir)
+	fileName := path.Join(dir, fmt.Sprintf("logs_%s_%s.jsonl.gz", name, time.Now().Format("20060102150405")))
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	return &logArchiver{file: file, gzip: gz, writer: bufio.NewWriter(gz)}, nil
+}
+
+// Write 写入并落盘
+func (a *logArchiver) Write(list []Log) error {
+	for _, item := range list {
+		data, err := json.Marshal(&item)
+		if err != nil {
+			return err
+		}
+		if _, err := a.writer.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+	if err := a.gzip.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close
+func (a *logArchiver) Close() error {
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+	if err := a.gzip.Close(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
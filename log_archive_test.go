@@ -0,0 +1,62 @@
+package kuu
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogArchiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuu_logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origin := LogDir
+	LogDir = dir
+	defer func() { LogDir = origin }()
+
+	archiver, err := newLogArchiver(LogTypeAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []Log{{UUID: "a", Type: LogTypeAPI}, {UUID: "b", Type: LogTypeAPI}}
+	if err := archiver.Write(logs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := archiver.Write(logs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := archiver.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "archive", "logs_api_*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 archive file, got %d", len(files))
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uuids []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var item Log
+		if err := JSONParse(scanner.Text(), &item); err != nil {
+			t.Fatal(err)
+		}
+		uuids = append(uuids, item.UUID)
+	}
+	if len(uuids) != 2 || uuids[0] != "a" || uuids[1] != "b" {
+		t.Errorf("unexpected archived logs: %v", uuids)
+	}
+}
@@ -71,6 +71,6 @@ func (h *LogBizHook) Fire(entry *logrus.Entry) error {
 
 	info.ContentHuman = strings.TrimSpace(strings.Replace(entry.Message, "biz:", "", 1))
 	info.ContentData = info.ContentHuman
-	info.Save2Cache()
+	info.Save()
 	return nil
 }
@@ -0,0 +1,517 @@
+package kuu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// LogSinkDB 批量写入sys_Log
+	LogSinkDB = "db"
+	// LogSinkFile 按天及大小滚动的JSONL文件
+	LogSinkFile = "file"
+	// LogSinkSyslog
+	LogSinkSyslog = "syslog"
+	// LogSinkHTTP 批量POST至日志收集服务
+	LogSinkHTTP = "http"
+	// LogSinkStdout 以JSONL格式输出至标准输出
+	LogSinkStdout = "stdout"
+)
+
+// LogSink 日志输出目标，Write按批次调用
+type LogSink interface {
+	Write(logs []*Log) error
+	Close() error
+}
+
+// LogSinkStat 日志输出队列指标
+type LogSinkStat struct {
+	Name          string
+	QueueDepth    int
+	QueueCapacity int
+	Written       int64
+	Failed        int64
+	Dropped       int64
+	LastError     string     `json:",omitempty"`
+	LastFlushAt   *time.Time `json:",omitempty"`
+}
+
+var (
+	logSinkFactories = map[string]func() (LogSink, error){
+		LogSinkDB:     func() (LogSink, error) { return &DBLogSink{}, nil },
+		LogSinkFile:   func() (LogSink, error) { return NewFileLogSink(), nil },
+		LogSinkSyslog: func() (LogSink, error) { return NewSyslogLogSink() },
+		LogSinkHTTP:   func() (LogSink, error) { return NewHTTPLogSink(), nil },
+		LogSinkStdout: func() (LogSink, error) { return &StdoutLogSink{}, nil },
+	}
+	logSinkFactoriesMu sync.RWMutex
+
+	logPipelines   = make(map[string]*logPipeline)
+	logPipelinesMu sync.Mutex
+	logSinksClosed int32
+)
+
+// RegisterLogSink 注册自定义日志输出，可在logs:sinks中按名称引用
+func RegisterLogSink(name string, factory func() (LogSink, error)) {
+	logSinkFactoriesMu.Lock()
+	defer logSinkFactoriesMu.Unlock()
+	logSinkFactories[name] = factory
+}
+
+// logSinkNames 读取logs:sinks配置，支持按日志类型配置，default为默认值（默认db）
+func logSinkNames(logType string) []string {
+	var sinks map[string][]string
+	C().GetInterface("logs:sinks", &sinks)
+	if v, ok := sinks[logType]; ok {
+		return v
+	}
+	if v, ok := sinks["default"]; ok {
+		return v
+	}
+	return []string{LogSinkDB}
+}
+
+// DispatchLog 将日志放入对应输出的队列
+func DispatchLog(l *Log) {
+	if atomic.LoadInt32(&logSinksClosed) == 1 {
+		return
+	}
+	for _, name := range logSinkNames(l.Type) {
+		if p := getLogPipeline(name); p != nil {
+			p.push(l)
+		}
+	}
+}
+
+func getLogPipeline(name string) *logPipeline {
+	logPipelinesMu.Lock()
+	defer logPipelinesMu.Unlock()
+	if p, ok := logPipelines[name]; ok {
+		return p
+	}
+	logSinkFactoriesMu.RLock()
+	factory := logSinkFactories[name]
+	logSinkFactoriesMu.RUnlock()
+	if factory == nil {
+		ERROR("Unknown log sink: %s", name)
+		logPipelines[name] = nil
+		return nil
+	}
+	sink, err := factory()
+	if err != nil {
+		ERROR("Create log sink %s failed: %s", name, err.Error())
+		logPipelines[name] = nil
+		return nil
+	}
+	p := newLogPipeline(name, sink)
+	logPipelines[name] = p
+	return p
+}
+
+// GetLogSinkStats
+func GetLogSinkStats() (list []LogSinkStat) {
+	logPipelinesMu.Lock()
+	for _, p := range logPipelines {
+		if p != nil {
+			list = append(list, p.stat())
+		}
+	}
+	logPipelinesMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return
+}
+
+// closeLogSinks 写完队列中剩余的日志
+func closeLogSinks() {
+	atomic.StoreInt32(&logSinksClosed, 1)
+	logPipelinesMu.Lock()
+	defer logPipelinesMu.Unlock()
+	for _, p := range logPipelines {
+		if p != nil {
+			p.close()
+		}
+	}
+}
+
+type logPipeline struct {
+	name          string
+	sink          LogSink
+	queue         chan *Log
+	done          chan struct{}
+	batchSize     int
+	flushInterval time.Duration
+	blockTimeout  time.Duration
+	retries       int
+	written       int64
+	failed        int64
+	dropped       int64
+	mu            sync.Mutex
+	closeMu       sync.RWMutex
+	closed        bool
+	lastError     string
+	lastFlushAt   *time.Time
+}
+
+func newLogPipeline(name string, sink LogSink) *logPipeline {
+	p := &logPipeline{
+		name:          name,
+		sink:          sink,
+		queue:         make(chan *Log, C().DefaultGetInt("logs:queueSize", 10000)),
+		done:          make(chan struct{}),
+		batchSize:     C().DefaultGetInt("logs:batchSize", 200),
+		flushInterval: time.Duration(C().DefaultGetInt("logs:flushInterval", 1000)) * time.Millisecond,
+		blockTimeout:  time.Duration(C().DefaultGetInt("logs:blockTimeout", 0)) * time.Millisecond,
+		retries:       C().DefaultGetInt("logs:retries", 3),
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = 200
+	}
+	if p.flushInterval <= 0 {
+		p.flushInterval = time.Second
+	}
+	go p.run()
+	return p
+}
+
+// push 同步写入支持持久化缓冲的输出（db），其余输出进入队列；队列已满时等待blockTimeout，超时后丢弃（审计日志持续等待，不丢弃）
+func (p *logPipeline) push(l *Log) {
+	if s, ok := p.sink.(logSinkSpooler); ok {
+		s.Spool(l)
+		atomic.AddInt64(&p.written, 1)
+		return
+	}
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- l:
+		return
+	default:
+	}
+	if p.blockTimeout > 0 {
+		timer := time.NewTimer(p.blockTimeout)
+		defer timer.Stop()
+		select {
+		case p.queue <- l:
+			return
+		case <-timer.C:
+		}
+	}
+	if l.Type == LogTypeAudit {
+		p.queue <- l
+		return
+	}
+	if atomic.AddInt64(&p.dropped, 1)%1000 == 1 {
+		WARN("Log sink %s queue is full, logs are being dropped", p.name)
+	}
+}
+
+func (p *logPipeline) run() {
+	defer close(p.done)
+	var (
+		ticker = time.NewTicker(p.flushInterval)
+		batch  = make([]*Log, 0, p.batchSize)
+	)
+	defer ticker.Stop()
+	for {
+		select {
+		case l, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, l)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = make([]*Log, 0, p.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = make([]*Log, 0, p.batchSize)
+			}
+		}
+	}
+}
+
+func (p *logPipeline) flush(batch []*Log) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for i := 0; i <= p.retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i*i) * 100 * time.Millisecond)
+		}
+		if err = p.sink.Write(batch); err == nil {
+			break
+		}
+	}
+	now := time.Now()
+	p.mu.Lock()
+	p.lastFlushAt = &now
+	if err != nil {
+		p.lastError = err.Error()
+	}
+	p.mu.Unlock()
+	if err != nil {
+		atomic.AddInt64(&p.failed, int64(len(batch)))
+		if v, ok := p.sink.(logSinkFallback); ok {
+			v.Fallback(batch)
+		}
+		// 避免日志输出失败时递归产生日志
+		fmt.Fprintf(os.Stderr, "[KUU-ERROR] Write %d logs to sink %s failed: %s\n", len(batch), p.name, err.Error())
+	} else {
+		atomic.AddInt64(&p.written, int64(len(batch)))
+	}
+}
+
+func (p *logPipeline) close() {
+	p.closeMu.Lock()
+	p.closed = true
+	close(p.queue)
+	p.closeMu.Unlock()
+	<-p.done
+	if err := p.sink.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "[KUU-ERROR] Close log sink %s failed: %s\n", p.name, err.Error())
+	}
+}
+
+func (p *logPipeline) stat() LogSinkStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return LogSinkStat{
+		Name:          p.name,
+		QueueDepth:    len(p.queue),
+		QueueCapacity: cap(p.queue),
+		Written:       atomic.LoadInt64(&p.written),
+		Failed:        atomic.LoadInt64(&p.failed),
+		Dropped:       atomic.LoadInt64(&p.dropped),
+		LastError:     p.lastError,
+		LastFlushAt:   p.lastFlushAt,
+	}
+}
+
+// logSinkSpooler 将日志同步写入持久化缓冲区的输出，不经过内存队列，进程崩溃时不丢失
+type logSinkSpooler interface {
+	Spool(l *Log)
+}
+
+// logSinkFallback 重试后仍然失败时调用
+type logSinkFallback interface {
+	Fallback(logs []*Log)
+}
+
+// DBLogSink 日志先写入缓存，由LogPersisJob批量写入sys_Log
+type DBLogSink struct{}
+
+// Spool
+func (s *DBLogSink) Spool(l *Log) {
+	l.Save2Cache()
+}
+
+// Write
+func (s *DBLogSink) Write(logs []*Log) error {
+	if _, ok := dataSourcesMap.Load(singleDSName); !ok {
+		return errors.New("no data source configured")
+	}
+	return WithTransaction(func(tx *gorm.DB) error {
+		return insertLogs(tx, logs)
+	})
+}
+
+// Fallback
+func (s *DBLogSink) Fallback(logs []*Log) {
+	for _, l := range logs {
+		l.Save2Cache()
+	}
+}
+
+// Close
+func (s *DBLogSink) Close() error {
+	return nil
+}
+
+// StdoutLogSink
+type StdoutLogSink struct{}
+
+// Write
+func (s *StdoutLogSink) Write(logs []*Log) error {
+	_, err := os.Stdout.Write(encodeJSONLines(logs))
+	return err
+}
+
+// Close
+func (s *StdoutLogSink) Close() error {
+	return nil
+}
+
+func encodeJSONLines(logs []*Log) []byte {
+	var buf bytes.Buffer
+	for _, l := range logs {
+		if data, err := json.Marshal(l); err == nil {
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// FileLogSink 按天滚动，单个文件超过logs:file:maxSize（MB）时切换新文件
+type FileLogSink struct {
+	Dir     string
+	MaxSize int64
+	file    *os.File
+	day     string
+	size    int64
+	index   int
+}
+
+// NewFileLogSink
+func NewFileLogSink() *FileLogSink {
+	dir := LogDir
+	if dir == "" {
+		dir = "logs"
+	}
+	return &FileLogSink{
+		Dir:     C().DefaultGetString("logs:file:dir", path.Join(dir, "jsonl")),
+		MaxSize: int64(C().DefaultGetInt("logs:file:maxSize", 100)) * 1024 * 1024,
+	}
+}
+
+func (s *FileLogSink) rotate() error {
+	day := time.Now().Format("2006-01-02")
+	if s.file != nil && day == s.day && (s.MaxSize <= 0 || s.size < s.MaxSize) {
+		return nil
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+	if day != s.day {
+		s.day = day
+		s.index = 0
+	}
+	EnsureDir(s.Dir)
+	for {
+		name := fmt.Sprintf("kuu-logs-%s.jsonl", s.day)
+		if s.index > 0 {
+			name = fmt.Sprintf("kuu-logs-%s.%d.jsonl", s.day, s.index)
+		}
+		file, err := os.OpenFile(path.Join(s.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		if s.MaxSize > 0 && info.Size() >= s.MaxSize {
+			_ = file.Close()
+			s.index++
+			continue
+		}
+		s.file = file
+		s.size = info.Size()
+		return nil
+	}
+}
+
+// Write
+func (s *FileLogSink) Write(logs []*Log) error {
+	if err := s.rotate(); err != nil {
+		return err
+	}
+	n, err := s.file.Write(encodeJSONLines(logs))
+	s.size += int64(n)
+	return err
+}
+
+// Close
+func (s *FileLogSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// HTTPLogSink 以JSON数组批量POST日志，配置项为logs:http
+type HTTPLogSink struct {
+	URL     string
+	Headers map[string]string
+	client  *http.Client
+}
+
+// NewHTTPLogSink
+func NewHTTPLogSink() *HTTPLogSink {
+	var opts struct {
+		URL     string
+		Headers map[string]string
+		Timeout int
+	}
+	C().GetInterface("logs:http", &opts)
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10
+	}
+	return &HTTPLogSink{
+		URL:     opts.URL,
+		Headers: opts.Headers,
+		client:  &http.Client{Timeout: time.Duration(opts.Timeout) * time.Second},
+	}
+}
+
+// Write
+func (s *HTTPLogSink) Write(logs []*Log) error {
+	if s.URL == "" {
+		return fmt.Errorf("logs:http.url is required")
+	}
+	data, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close
+func (s *HTTPLogSink) Close() error {
+	return nil
+}
+
+// LogSinksRoute
+var LogSinksRoute = RouteInfo{
+	Name:   "查询日志输出队列",
+	Method: "GET",
+	Path:   "/log/sinks",
+	HandlerFunc: func(c *Context) {
+		c.STD(GetLogSinkStats())
+	},
+}
@@ -0,0 +1,55 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package kuu
+
+import (
+	"log/syslog"
+)
+
+// SyslogLogSink 配置项为logs:syslog，未指定地址时写入本机syslog
+type SyslogLogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogLogSink
+func NewSyslogLogSink() (LogSink, error) {
+	var opts struct {
+		Network string
+		Addr    string
+		Tag     string
+	}
+	C().GetInterface("logs:syslog", &opts)
+	if opts.Tag == "" {
+		opts.Tag = GetAppName()
+	}
+	writer, err := syslog.Dial(opts.Network, opts.Addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogLogSink{writer: writer}, nil
+}
+
+// Write
+func (s *SyslogLogSink) Write(logs []*Log) error {
+	for _, l := range logs {
+		data, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		if l.Type == LogTypeBiz && l.Level == "error" {
+			err = s.writer.Err(string(data))
+		} else {
+			err = s.writer.Info(string(data))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close
+func (s *SyslogLogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,11 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package kuu
+
+import "errors"
+
+// NewSyslogLogSink
+func NewSyslogLogSink() (LogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,136 @@
+package kuu
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLogSink struct {
+	mu      sync.Mutex
+	batches [][]*Log
+	fail    bool
+	block   chan struct{}
+}
+
+func (s *testLogSink) Write(logs []*Log) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("failed")
+	}
+	s.batches = append(s.batches, logs)
+	return nil
+}
+
+func (s *testLogSink) Close() error {
+	return nil
+}
+
+func TestLogPipeline(t *testing.T) {
+	sink := &testLogSink{}
+	p := &logPipeline{
+		name:          "test",
+		sink:          sink,
+		queue:         make(chan *Log, 10),
+		done:          make(chan struct{}),
+		batchSize:     2,
+		flushInterval: time.Second,
+	}
+	go p.run()
+	for i := 0; i < 5; i++ {
+		p.push(&Log{UUID: "test"})
+	}
+	p.close()
+	var total int
+	for _, batch := range sink.batches {
+		total += len(batch)
+	}
+	if stat := p.stat(); total != 5 || stat.Written != 5 || stat.QueueDepth != 0 {
+		t.Errorf("expected 5 written logs, got %d: %+v", total, stat)
+	}
+	p.push(&Log{UUID: "closed"})
+}
+
+func TestLogPipelineBackPressure(t *testing.T) {
+	sink := &testLogSink{block: make(chan struct{})}
+	p := &logPipeline{
+		name:          "test",
+		sink:          sink,
+		queue:         make(chan *Log, 2),
+		done:          make(chan struct{}),
+		batchSize:     1,
+		flushInterval: time.Second,
+	}
+	go p.run()
+	// 第一条被消费者取出后阻塞在Write中
+	p.push(&Log{})
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		p.push(&Log{})
+	}
+	if stat := p.stat(); stat.QueueDepth != 2 || stat.Dropped != 2 {
+		t.Errorf("expected 2 queued and 2 dropped logs: %+v", stat)
+	}
+	// 审计日志等待队列空出，不丢弃
+	pushed := make(chan struct{})
+	go func() {
+		p.push(&Log{Type: LogTypeAudit})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("audit log should wait for the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(sink.block)
+	<-pushed
+	p.close()
+	if stat := p.stat(); stat.Written != 4 || stat.Dropped != 2 {
+		t.Errorf("expected 4 written and 2 dropped logs: %+v", stat)
+	}
+}
+
+func TestDBLogSinkSpool(t *testing.T) {
+	defer setTestConfig("name", `"test"`)()
+	old := DefaultCache
+	DefaultCache = NewCacheMemory()
+	defer func() { DefaultCache = old }()
+
+	p := &logPipeline{name: LogSinkDB, sink: &DBLogSink{}, queue: make(chan *Log)}
+	for i := 0; i < 3; i++ {
+		p.push(NewLog(LogTypeBiz))
+	}
+	if n := len(HasPrefixCache(BuildKey("log"), 0)); n != 3 {
+		t.Errorf("expected 3 spooled logs, got %d", n)
+	}
+	if stat := p.stat(); stat.Written != 3 || stat.Dropped != 0 || stat.QueueDepth != 0 {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+}
+
+func TestFileLogSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuu_sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sink := &FileLogSink{Dir: dir, MaxSize: 1}
+	for i := 0; i < 3; i++ {
+		if err := sink.Write([]*Log{{UUID: "a"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = sink.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "kuu-logs-*.jsonl"))
+	if len(files) != 3 {
+		t.Errorf("expected 3 rotated files, got %d", len(files))
+	}
+}
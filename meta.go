@@ -27,6 +27,9 @@ type Metadata struct {
 	SubDocIDNames []string          `json:"-" gorm:"-"`
 	UIDNames      []string          `json:"-" gorm:"-"`
 	OrgIDNames    []string          `json:"-" gorm:"-"`
+	UpsertKeys    []string          `json:"-" gorm:"-"`
+	History       bool              `json:"-" gorm:"-"`
+	CacheTTL      time.Duration     `json:"-" gorm:"-"`
 	TagSettings   map[string]string `json:"-" gorm:"-"`
 }
 
@@ -169,6 +172,15 @@ func parseMetadata(value interface{}) (m *Metadata) {
 			if _, exists := tagSettings["PASSWORD"]; exists {
 				field.IsPassword = true
 			}
+			if _, exists := tagSettings["HISTORY"]; exists {
+				m.History = true
+			}
+			if v, exists := tagSettings["CACHE"]; exists {
+				m.CacheTTL = parseCacheTTL(v)
+			}
+			if v, exists := tagSettings["UPSERT"]; exists && v != "UPSERT" {
+				m.UpsertKeys = splitFieldNames(v)
+			}
 			if v, exists := tagSettings["UIDS"]; exists {
 				m.UIDNames = strings.Split(v, ",")
 			}
@@ -0,0 +1,469 @@
+package kuu
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultMetricBuckets 耗时直方图的默认分桶（秒）
+var DefaultMetricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric 以Prometheus文本格式输出的指标
+type Metric interface {
+	MetricName() string
+	WritePrometheus(w io.Writer)
+}
+
+var (
+	metricsRegistry   []Metric
+	metricsRegistryMu sync.RWMutex
+
+	httpRequestsTotal = NewCounterVec("kuu_http_requests_total",
+		"Total number of HTTP requests.", "route", "method", "status")
+	httpRequestDuration = NewHistogramVec("kuu_http_request_duration_seconds",
+		"HTTP request latencies in seconds.", DefaultMetricBuckets, "route", "method", "status")
+	dbQueryDuration = NewHistogramVec("kuu_db_query_duration_seconds",
+		"GORM callback chain durations in seconds.", DefaultMetricBuckets, "model", "operation")
+	cacheRequestsTotal = NewCounterVec("kuu_cache_requests_total",
+		"Total number of GetCacheString calls by result (hit or miss).", "result")
+	cacheLoadsTotal = NewCounterVec("kuu_cache_loads_total",
+		"Total number of GetOrLoad calls by result.", "result")
+	jobRunsTotal = NewCounterVec("kuu_job_runs_total",
+		"Total number of job runs by status.", "job", "status")
+	jobFailuresTotal = NewCounterVec("kuu_job_failures_total",
+		"Total number of failed, panicked or timed out job runs.", "job")
+	websocketConnections = NewGauge("kuu_websocket_connections",
+		"Number of open websocket connections.")
+	importQueueDepth = NewGauge("kuu_import_queue_depth",
+		"Number of imports being processed.")
+)
+
+func init() {
+	RegisterMetric(
+		httpRequestsTotal,
+		httpRequestDuration,
+		dbQueryDuration,
+		cacheRequestsTotal,
+		cacheLoadsTotal,
+		jobRunsTotal,
+		jobFailuresTotal,
+		websocketConnections,
+		importQueueDepth,
+		&metricFunc{
+			name: "kuu_log_sink_queue_depth",
+			help: "Number of logs waiting in each log sink queue.",
+			typ:  "gauge",
+			fn: func() (samples []metricSample) {
+				for _, stat := range GetLogSinkStats() {
+					samples = append(samples, metricSample{labels: []string{"sink", stat.Name}, value: float64(stat.QueueDepth)})
+				}
+				return
+			},
+		},
+		&metricFunc{
+			name: "kuu_log_cache_backlog",
+			help: "Number of logs cached for LogPersisJob.",
+			typ:  "gauge",
+			fn: func() []metricSample {
+				// 计数器在写入缓存时递增、持久化后递减，避免每次采集扫描缓存
+				backlog := GetCacheInt(logBacklogKey())
+				if backlog < 0 {
+					backlog = 0
+				}
+				return []metricSample{{value: float64(backlog)}}
+			},
+		},
+	)
+}
+
+// RegisterMetric 注册自定义指标
+func RegisterMetric(metrics ...Metric) {
+	metricsRegistryMu.Lock()
+	defer metricsRegistryMu.Unlock()
+	metricsRegistry = append(metricsRegistry, metrics...)
+}
+
+// WriteMetrics 按名称顺序输出全部指标
+func WriteMetrics(w io.Writer) {
+	metricsRegistryMu.RLock()
+	metrics := append([]Metric(nil), metricsRegistry...)
+	metricsRegistryMu.RUnlock()
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].MetricName() < metrics[j].MetricName()
+	})
+	for _, m := range metrics {
+		m.WritePrometheus(w)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatMetricLabels pairs为name、value交替排列
+func formatMetricLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	items := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		items = append(items, fmt.Sprintf(`%s="%s"`, pairs[i], metricLabelEscaper.Replace(pairs[i+1])))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(items, ","))
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func metricLabelPairs(names, values []string) []string {
+	pairs := make([]string, 0, len(names)*2)
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name, value)
+	}
+	return pairs
+}
+
+func metricSeriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+}
+
+// MetricName
+func (v *CounterVec) MetricName() string {
+	return v.name
+}
+
+// Inc
+func (v *CounterVec) Inc(labels ...string) {
+	v.Add(1, labels...)
+}
+
+// Add
+func (v *CounterVec) Add(delta float64, labels ...string) {
+	key := metricSeriesKey(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &counterSeries{labels: append([]string(nil), labels...)}
+		v.series[key] = s
+	}
+	s.value += delta
+}
+
+// Value
+func (v *CounterVec) Value(labels ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[metricSeriesKey(labels)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// WritePrometheus
+func (v *CounterVec) WritePrometheus(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	writeMetricHeader(w, v.name, v.help, "counter")
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatMetricLabels(metricLabelPairs(v.labels, s.labels)...), formatMetricValue(s.value))
+	}
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// MetricName
+func (v *HistogramVec) MetricName() string {
+	return v.name
+}
+
+// Observe
+func (v *HistogramVec) Observe(value float64, labels ...string) {
+	key := metricSeriesKey(labels)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labels...), counts: make([]uint64, len(v.buckets))}
+		v.series[key] = s
+	}
+	for i, bound := range v.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// ObserveDuration
+func (v *HistogramVec) ObserveDuration(start time.Time, labels ...string) {
+	v.Observe(time.Since(start).Seconds(), labels...)
+}
+
+// WritePrometheus
+func (v *HistogramVec) WritePrometheus(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	writeMetricHeader(w, v.name, v.help, "histogram")
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		pairs := metricLabelPairs(v.labels, s.labels)
+		for i, bound := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatMetricLabels(append(pairs, "le", formatMetricValue(bound))...), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatMetricLabels(append(pairs, "le", "+Inf")...), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatMetricLabels(pairs...), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatMetricLabels(pairs...), s.count)
+	}
+}
+
+// Gauge 无标签的瞬时值
+type Gauge struct {
+	name  string
+	help  string
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge
+func NewGauge(name, help string) *Gauge {
+	return &Gauge{name: name, help: help}
+}
+
+// MetricName
+func (g *Gauge) MetricName() string {
+	return g.name
+}
+
+// Set
+func (g *Gauge) Set(value float64) {
+	g.mu.Lock()
+	g.value = value
+	g.mu.Unlock()
+}
+
+// Add
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+// Inc
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// WritePrometheus
+func (g *Gauge) WritePrometheus(w io.Writer) {
+	writeMetricHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatMetricValue(g.Value()))
+}
+
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+// metricFunc 抓取时计算的指标
+type metricFunc struct {
+	name string
+	help string
+	typ  string
+	fn   func() []metricSample
+}
+
+func (m *metricFunc) MetricName() string {
+	return m.name
+}
+
+func (m *metricFunc) WritePrometheus(w io.Writer) {
+	writeMetricHeader(w, m.name, m.help, m.typ)
+	for _, s := range m.fn() {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatMetricLabels(s.labels...), formatMetricValue(s.value))
+	}
+}
+
+// NewGaugeFunc 抓取时调用fn获取当前值
+func NewGaugeFunc(name, help string, fn func() float64) Metric {
+	return &metricFunc{name: name, help: help, typ: "gauge", fn: func() []metricSample {
+		return []metricSample{{value: fn()}}
+	}}
+}
+
+// MetricsMiddleware 按路由名称及状态码统计请求数和耗时
+func MetricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.GetString(RouteNameKey)
+	if route == "" {
+		// 未命名的路由统一归类，避免路径参数导致标签数量膨胀
+		route = "other"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	httpRequestsTotal.Inc(route, c.Request.Method, status)
+	httpRequestDuration.ObserveDuration(start, route, c.Request.Method, status)
+}
+
+const metricsStartInstanceKey = "kuu:metrics_start"
+
+func registerMetricsCallbacks(callback *gorm.Callback) {
+	if callback.Create().Get("kuu:metrics_begin") == nil {
+		callback.Create().Before("gorm:begin_transaction").Register("kuu:metrics_begin", metricsBeginCallback)
+		callback.Create().After("gorm:commit_or_rollback_transaction").Register("kuu:metrics_end", metricsEndCallback("create"))
+	}
+	if callback.Update().Get("kuu:metrics_begin") == nil {
+		callback.Update().Before("gorm:begin_transaction").Register("kuu:metrics_begin", metricsBeginCallback)
+		callback.Update().After("gorm:commit_or_rollback_transaction").Register("kuu:metrics_end", metricsEndCallback("update"))
+	}
+	if callback.Delete().Get("kuu:metrics_begin") == nil {
+		callback.Delete().Before("gorm:begin_transaction").Register("kuu:metrics_begin", metricsBeginCallback)
+		callback.Delete().After("gorm:commit_or_rollback_transaction").Register("kuu:metrics_end", metricsEndCallback("delete"))
+	}
+	if callback.Query().Get("kuu:metrics_begin") == nil {
+		callback.Query().Before("gorm:query").Register("kuu:metrics_begin", metricsBeginCallback)
+		callback.Query().After("gorm:after_query").Register("kuu:metrics_end", metricsEndCallback("query"))
+	}
+	if callback.RowQuery().Get("kuu:metrics_begin") == nil {
+		callback.RowQuery().Before("gorm:row_query").Register("kuu:metrics_begin", metricsBeginCallback)
+		callback.RowQuery().After("gorm:row_query").Register("kuu:metrics_end", metricsEndCallback("row_query"))
+	}
+}
+
+func metricsBeginCallback(scope *gorm.Scope) {
+	scope.InstanceSet(metricsStartInstanceKey, time.Now())
+}
+
+func metricsEndCallback(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.InstanceGet(metricsStartInstanceKey)
+		if !ok {
+			return
+		}
+		if start, ok := v.(time.Time); ok {
+			dbQueryDuration.ObserveDuration(start, metricsModelName(scope), operation)
+		}
+	}
+}
+
+func metricsModelName(scope *gorm.Scope) string {
+	if scope.Value == nil {
+		return "raw"
+	}
+	if t := scope.GetModelStruct().ModelType; t != nil {
+		return t.Name()
+	}
+	return "unknown"
+}
+
+// MetricsRoute
+var MetricsRoute = RouteInfo{
+	Name:         "Prometheus指标",
+	Method:       "GET",
+	Path:         "/metrics",
+	IgnorePrefix: true,
+	HandlerFunc: func(c *Context) {
+		token := C().GetString("metrics:token")
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		var buf bytes.Buffer
+		WriteMetrics(&buf)
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+	},
+}
+
+// initMetrics 配置了metrics:public或metrics:token时，/metrics加入白名单
+func initMetrics() {
+	if C().DefaultGetBool("metrics:public", false) || C().GetString("metrics:token") != "" {
+		AddWhitelist(fmt.Sprintf("GET %s", MetricsRoute.Path))
+	}
+}
@@ -0,0 +1,70 @@
+package kuu
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "route", "status")
+	counter.Inc("a\"b", "200")
+	counter.Add(2, "a\"b", "200")
+	histogram := NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.1}, "op")
+	histogram.Observe(0.05, "query")
+	histogram.Observe(0.5, "query")
+	gauge := NewGauge("test_gauge", "Test gauge.")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+
+	var buf bytes.Buffer
+	for _, m := range []Metric{counter, histogram, gauge} {
+		m.WritePrometheus(&buf)
+	}
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{route="a\"b",status="200"} 3
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="query",le="0.1"} 1
+test_seconds_bucket{op="query",le="1"} 2
+test_seconds_bucket{op="query",le="+Inf"} 2
+test_seconds_sum{op="query"} 0.55
+test_seconds_count{op="query"} 2
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(MetricsMiddleware)
+	r.GET("/named", func(c *gin.Context) {
+		c.Set(RouteNameKey, "test:named")
+		c.Status(http.StatusCreated)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/named", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing/1", nil))
+
+	if v := httpRequestsTotal.Value("test:named", "GET", "201"); v != 1 {
+		t.Errorf("expected 1 named request, got %v", v)
+	}
+	if v := httpRequestsTotal.Value("other", "GET", "404"); v < 1 {
+		t.Errorf("expected unnamed request to be counted as other, got %v", v)
+	}
+	var buf bytes.Buffer
+	httpRequestDuration.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `kuu_http_request_duration_seconds_count{route="test:named",method="GET",status="201"} 1`) {
+		t.Errorf("missing request duration in output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,405 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	tableNames["schema_migration"] = "sys_SchemaMigration"
+	tableNames["schema_migrations"] = "sys_SchemaMigration"
+	tableNames["schema_migration_lock"] = "sys_SchemaMigrationLock"
+	tableNames["schema_migration_locks"] = "sys_SchemaMigrationLock"
+}
+
+// Migration 版本化迁移，同一模块内按ID升序执行
+type Migration struct {
+	ID      string
+	Up      func(*gorm.DB) error
+	Down    func(*gorm.DB) error
+	UpSQL   string
+	DownSQL string
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	ID        uint   `gorm:"primary_key"`
+	ModCode   string `gorm:"not null"`
+	Version   string `gorm:"not null"`
+	AppliedAt time.Time
+}
+
+// IgnoreLog
+func (m *SchemaMigration) IgnoreLog() {}
+
+// SchemaMigrationLock 迁移锁，保证多副本部署时只有一个实例执行迁移
+type SchemaMigrationLock struct {
+	ID       uint `gorm:"primary_key;auto_increment:false"`
+	Owner    string
+	LockedAt time.Time
+}
+
+// IgnoreLog
+func (m *SchemaMigrationLock) IgnoreLog() {}
+
+// MigrationStatusItem
+type MigrationStatusItem struct {
+	ModCode   string
+	Version   string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// SQLMigration
+func SQLMigration(id, upSQL, downSQL string) *Migration {
+	return &Migration{ID: id, UpSQL: upSQL, DownSQL: downSQL}
+}
+
+// LoadSQLMigrations 从目录加载SQL迁移，文件名格式为<ID>.up.sql和<ID>.down.sql
+func LoadSQLMigrations(dir string) (list []*Migration, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	migrationMap := make(map[string]*Migration)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		var (
+			id   string
+			isUp bool
+		)
+		if strings.HasSuffix(name, ".up.sql") {
+			id = strings.TrimSuffix(name, ".up.sql")
+			isUp = true
+		} else if strings.HasSuffix(name, ".down.sql") {
+			id = strings.TrimSuffix(name, ".down.sql")
+		} else {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m := migrationMap[id]
+		if m == nil {
+			m = &Migration{ID: id}
+			migrationMap[id] = m
+			list = append(list, m)
+		}
+		if isUp {
+			m.UpSQL = string(data)
+		} else {
+			m.DownSQL = string(data)
+		}
+	}
+	sortMigrations(list)
+	return
+}
+
+func sortMigrations(list []*Migration) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+}
+
+// execMigrationSQL 整个文件作为一次执行，函数体、DO块及字符串中的分号不会被拆开
+func execMigrationSQL(tx *gorm.DB, sqls string) error {
+	if strings.TrimSpace(sqls) == "" {
+		return nil
+	}
+	_, err := tx.CommonDB().Exec(sqls)
+	return err
+}
+
+func (m *Migration) up(tx *gorm.DB) error {
+	if m.Up != nil {
+		return m.Up(tx)
+	}
+	return execMigrationSQL(tx, m.UpSQL)
+}
+
+func (m *Migration) down(tx *gorm.DB) error {
+	if m.Down != nil {
+		return m.Down(tx)
+	}
+	if m.DownSQL == "" {
+		return fmt.Errorf("migration %s can not be rolled back", m.ID)
+	}
+	return execMigrationSQL(tx, m.DownSQL)
+}
+
+func ensureMigrationTables() error {
+	return DB().AutoMigrate(&SchemaMigration{}, &SchemaMigrationLock{}).Error
+}
+
+func migrationLockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// withMigrationLock 获取迁移锁后执行，超时的锁视为失效
+func withMigrationLock(fn func() error) error {
+	if err := ensureMigrationTables(); err != nil {
+		return err
+	}
+	var (
+		timeout  = time.Duration(C().DefaultGetInt("migrations:lockTimeout", 600)) * time.Second
+		deadline = time.Now().Add(timeout)
+		lock     = SchemaMigrationLock{ID: 1, Owner: migrationLockOwner()}
+	)
+	for {
+		lock.LockedAt = time.Now()
+		if err := DB().Create(&lock).Error; err == nil {
+			break
+		}
+		var current SchemaMigrationLock
+		if err := DB().Where("id = ?", 1).First(&current).Error; err == nil && time.Since(current.LockedAt) > timeout {
+			WARN("Releasing stale migration lock held by %s", current.Owner)
+			DB().Where("id = ? AND owner = ?", 1, current.Owner).Delete(&SchemaMigrationLock{})
+			continue
+		}
+		if time.Now().After(deadline) {
+			return errors.New("waiting for migration lock timeout")
+		}
+		time.Sleep(time.Second)
+	}
+	// 迁移期间定期刷新加锁时间，避免执行时间超过lockTimeout时被其他实例视为失效
+	stop := keepMigrationLockAlive(DB(), lock.Owner, timeout/3)
+	defer func() {
+		stop()
+		if err := DB().Where("id = ? AND owner = ?", 1, lock.Owner).Delete(&SchemaMigrationLock{}).Error; err != nil {
+			ERROR("Releasing migration lock failed: %s", err.Error())
+		}
+	}()
+	return fn()
+}
+
+// keepMigrationLockAlive 每隔interval刷新一次加锁时间，直到调用返回的stop
+func keepMigrationLockAlive(db *gorm.DB, owner string, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := db.Model(&SchemaMigrationLock{}).Where("id = ? AND owner = ?", 1, owner).UpdateColumn("locked_at", time.Now()).Error
+				if err != nil {
+					WARN("Refresh migration lock failed: %s", err.Error())
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func appliedMigrations(modCode string) (map[string]SchemaMigration, error) {
+	var list []SchemaMigration
+	if err := DB().Where("mod_code = ?", modCode).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[string]SchemaMigration)
+	for _, item := range list {
+		applied[item.Version] = item
+	}
+	return applied, nil
+}
+
+func migrateMod(mod *Mod) error {
+	if len(mod.Migrations) == 0 {
+		return nil
+	}
+	return withMigrationLock(func() error {
+		applied, err := appliedMigrations(mod.Code)
+		if err != nil {
+			return err
+		}
+		sortMigrations(mod.Migrations)
+		for _, m := range mod.Migrations {
+			if _, ok := applied[m.ID]; ok {
+				continue
+			}
+			err := WithTransaction(func(tx *gorm.DB) error {
+				if err := m.up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{ModCode: mod.Code, Version: m.ID, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %s:%s failed: %s", mod.Code, m.ID, err.Error())
+			}
+			INFO("Migration %s:%s applied", mod.Code, m.ID)
+		}
+		return nil
+	})
+}
+
+func sortedMods(modCodes []string) (mods []*Mod, err error) {
+	if len(modCodes) == 0 {
+		for code := range ModMap {
+			modCodes = append(modCodes, code)
+		}
+		sort.Strings(modCodes)
+	}
+	for _, code := range modCodes {
+		mod, ok := ModMap[strings.ToLower(code)]
+		if !ok {
+			return nil, fmt.Errorf("mod not found: %s", code)
+		}
+		mods = append(mods, mod)
+	}
+	return
+}
+
+// Migrate 执行指定模块（默认全部）未执行的迁移
+func Migrate(modCodes ...string) error {
+	mods, err := sortedMods(modCodes)
+	if err != nil {
+		return err
+	}
+	for _, mod := range mods {
+		if err := migrateMod(mod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback 按执行顺序倒序回滚指定模块最近的steps个迁移
+func Rollback(modCode string, steps int) error {
+	mods, err := sortedMods([]string{modCode})
+	if err != nil {
+		return err
+	}
+	mod := mods[0]
+	if steps <= 0 {
+		steps = 1
+	}
+	return withMigrationLock(func() error {
+		var records []SchemaMigration
+		if err := DB().Where("mod_code = ?", mod.Code).Order("applied_at desc, id desc").Limit(steps).Find(&records).Error; err != nil {
+			return err
+		}
+		migrationMap := make(map[string]*Migration)
+		for _, m := range mod.Migrations {
+			migrationMap[m.ID] = m
+		}
+		for _, record := range records {
+			m := migrationMap[record.Version]
+			if m == nil {
+				return fmt.Errorf("migration %s:%s is not registered", mod.Code, record.Version)
+			}
+			err := WithTransaction(func(tx *gorm.DB) error {
+				if err := m.down(tx); err != nil {
+					return err
+				}
+				return tx.Where("id = ?", record.ID).Delete(&SchemaMigration{}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("rollback %s:%s failed: %s", mod.Code, m.ID, err.Error())
+			}
+			INFO("Migration %s:%s rolled back", mod.Code, m.ID)
+		}
+		return nil
+	})
+}
+
+// MigrationStatus
+func MigrationStatus(modCodes ...string) (list []MigrationStatusItem, err error) {
+	if err = ensureMigrationTables(); err != nil {
+		return
+	}
+	mods, err := sortedMods(modCodes)
+	if err != nil {
+		return
+	}
+	for _, mod := range mods {
+		applied, err := appliedMigrations(mod.Code)
+		if err != nil {
+			return nil, err
+		}
+		sortMigrations(mod.Migrations)
+		for _, m := range mod.Migrations {
+			item := MigrationStatusItem{ModCode: mod.Code, Version: m.ID}
+			if record, ok := applied[m.ID]; ok {
+				item.Applied = true
+				item.AppliedAt = &record.AppliedAt
+			}
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// MigrationCommand 处理命令行参数，支持：
+//
+//	migrate up [mod...]
+//	migrate down <mod> [steps]
+//	migrate status [mod...]
+//
+// 第一个参数不是migrate时返回handled=false
+func MigrationCommand(args []string) (handled bool, err error) {
+	if len(args) == 0 || args[0] != "migrate" {
+		return
+	}
+	handled = true
+	action := "up"
+	if len(args) > 1 {
+		action = args[1]
+	}
+	var rest []string
+	if len(args) > 2 {
+		rest = args[2:]
+	}
+	switch action {
+	case "up":
+		err = Migrate(rest...)
+	case "down":
+		if len(rest) == 0 {
+			return handled, errors.New("usage: migrate down <mod> [steps]")
+		}
+		steps := 1
+		if len(rest) > 1 {
+			if steps, err = strconv.Atoi(rest[1]); err != nil {
+				return
+			}
+		}
+		err = Rollback(rest[0], steps)
+	case "status":
+		var list []MigrationStatusItem
+		if list, err = MigrationStatus(rest...); err != nil {
+			return
+		}
+		for _, item := range list {
+			status := "pending"
+			if item.Applied {
+				status = fmt.Sprintf("applied at %s", item.AppliedAt.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Printf("%-12s %-40s %s\n", item.ModCode, item.Version, status)
+		}
+	default:
+		err = fmt.Errorf("unknown migrate action: %s", action)
+	}
+	return
+}
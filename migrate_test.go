@@ -0,0 +1,31 @@
+package kuu
+
+import (
+	"database/sql/driver"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepMigrationLockAlive(t *testing.T) {
+	var refreshes int32
+	db := newTestDB(t, nil, func(query string, args []driver.Value) driver.Result {
+		if strings.HasPrefix(query, "UPDATE") && containsDriverValue(args, "owner") {
+			atomic.AddInt32(&refreshes, 1)
+		}
+		return driver.RowsAffected(1)
+	})
+	stop := keepMigrationLockAlive(db, "owner", 10*time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	stop()
+	time.Sleep(20 * time.Millisecond)
+	n := atomic.LoadInt32(&refreshes)
+	if n < 2 {
+		t.Fatalf("lock should be refreshed while migrating, got %d refreshes", n)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&refreshes) != n {
+		t.Error("lock should not be refreshed after stop")
+	}
+}
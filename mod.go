@@ -42,6 +42,8 @@ type Mod struct {
 	Middleware  gin.HandlersChain
 	Routes      RoutesInfo
 	Models      []interface{}
+	Migrations  []*Migration
+	Seeds       []string
 	AfterImport func()
 }
 
@@ -78,10 +80,11 @@ func (e *Engine) Import(mods ...*Mod) {
 				} else {
 					routePath = path.Join(routePrefix, route.Path)
 				}
+				handler := withRouteName(route.Name, route.HandlerFunc)
 				if route.Method == "*" {
-					e.Any(routePath, route.HandlerFunc)
+					e.Any(routePath, handler)
 				} else {
-					e.Handle(route.Method, routePath, route.HandlerFunc)
+					e.Handle(route.Method, routePath, handler)
 				}
 			}
 			for _, model := range mod.Models {
@@ -111,9 +114,19 @@ func (e *Engine) Import(mods ...*Mod) {
 					}
 				}
 			}
+			if len(mod.Migrations) > 0 && C().DefaultGetBool("migrations:auto", true) {
+				if err := migrateMod(mod); err != nil {
+					PANIC(err.Error())
+				}
+			}
 			if mod.AfterImport != nil {
 				mod.AfterImport()
 			}
+			if len(mod.Seeds) > 0 && C().DefaultGetBool("seeds:auto", true) {
+				if err := seedMod(mod); err != nil {
+					PANIC(err.Error())
+				}
+			}
 			ModMap[mod.Code] = mod
 		}
 	}); err != nil {
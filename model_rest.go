@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -13,11 +14,15 @@ import (
 
 // RestDesc
 type RestDesc struct {
-	Create bool
-	Delete bool
-	Query  bool
-	Update bool
-	Import bool
+	Create  bool
+	Delete  bool
+	Query   bool
+	Update  bool
+	Import  bool
+	Restore bool
+	History bool
+	// UpsertKeys 新增接口upsert模式下用于判断数据是否已存在的唯一字段
+	UpsertKeys []string
 }
 
 // IsValid
@@ -76,6 +81,10 @@ func RESTful(r *Engine, routePrefix string, value interface{}) (desc *RestDesc)
 				}
 			}
 
+			if v, exists := tagSettings["UPSERT"]; exists && v != "UPSERT" {
+				desc.UpsertKeys = splitFieldNames(v)
+			}
+
 			if _, exists := tagSettings["-"]; exists {
 				createMethod = "-"
 				deleteMethod = "-"
@@ -94,19 +103,30 @@ func RESTful(r *Engine, routePrefix string, value interface{}) (desc *RestDesc)
 			} else {
 				if createMethod != "-" {
 					desc.Create = true
-					r.Handle(createMethod, routePath, restCreateHandler(reflectType))
+					r.Handle(createMethod, routePath, withRouteName(structName+":create", restCreateHandler(reflectType)))
 				}
 				if deleteMethod != "-" {
 					desc.Delete = true
-					r.Handle(deleteMethod, routePath, restDeleteHandler(reflectType))
+					r.Handle(deleteMethod, routePath, withRouteName(structName+":delete", restDeleteHandler(reflectType)))
+					// 支持软删除的模型生成恢复接口
+					if softDeletable(reflectType) {
+						desc.Restore = true
+						r.Handle("POST", routePath+"/restore", withRouteName(structName+":restore", restRestoreHandler(reflectType)))
+					}
 				}
 				if queryMethod != "-" {
 					desc.Query = true
-					r.Handle(queryMethod, routePath, restQueryHandler(reflectType))
+					registerRestCache(structName, tagSettings, parseMetadata(value))
+					r.Handle(queryMethod, routePath, withRouteName(structName+":query", restQueryHandler(reflectType)))
+					// 开启变更历史的模型生成历史查询接口
+					if meta := parseMetadata(value); meta != nil && meta.History {
+						desc.History = true
+						r.Handle("GET", historyRoutePath(routePath), withRouteName(structName+":history", restHistoryHandler(reflectType)))
+					}
 				}
 				if updateMethod != "-" {
 					desc.Update = true
-					r.Handle(updateMethod, routePath, restUpdateHandler(reflectType))
+					r.Handle(updateMethod, routePath, withRouteName(structName+":update", restUpdateHandler(reflectType)))
 				}
 			}
 			break
@@ -115,6 +135,11 @@ func RESTful(r *Engine, routePrefix string, value interface{}) (desc *RestDesc)
 	return
 }
 
+// historyRoutePath 固定为<routePath>/history/:id，与模型路径下的静态路由（如/user/menus）不冲突，不依赖注册顺序
+func historyRoutePath(routePath string) string {
+	return routePath + "/history/:id"
+}
+
 // CondDesc
 type CondDesc struct {
 	AndSQLs  []string
@@ -439,6 +464,21 @@ func restUpdateHandler(reflectType reflect.Type) func(c *Context) {
 			if queryDB.RowsAffected < 1 {
 				return ErrAffectedSaveToken
 			}
+			// 乐观锁：请求头或doc中携带Ts时校验数据是否已被修改
+			if !multi {
+				ts, ok := ParseExpectedTs(c.GetHeader(ExpectedTsHeaderKey))
+				if !ok {
+					ts, ok = ParseExpectedTs(params.Doc["Ts"])
+				}
+				if field, has := tx.NewScope(result).FieldByName("Ts"); ok && has {
+					current, _ := field.Field.Interface().(time.Time)
+					if !MatchExpectedTs(current, ts) {
+						return ErrWith(ErrVersionConflict, "数据已被其他用户修改，请刷新后重试")
+					}
+					// 使用数据库中的精确值，防止查询后被其他请求修改
+					tx = WithExpectedTs(tx, result, current)
+				}
+			}
 			updateFields := func(val interface{}) error {
 				doc := reflect.New(reflectType).Interface()
 				if err := Copy(params.Doc, doc); err != nil {
@@ -470,7 +510,15 @@ func restUpdateHandler(reflectType reflect.Type) func(c *Context) {
 		})
 		// 响应结果
 		if err != nil {
-			if cusErr, ok := ErrOut(err); ok {
+			if IsVersionConflict(err) {
+				render := c.STDErrHold(c.L("rest_update_conflict", "The record has been modified by someone else, please refresh and retry"), err)
+				if C().DefaultGetBool("optimisticLock:returnCurrent", true) {
+					if current := currentRecord(reflectType, result); current != nil {
+						render.Data(Meta(current).OmitPassword(current))
+					}
+				}
+				render.Render()
+			} else if cusErr, ok := ErrOut(err); ok {
 				c.STDErr(c.L("kuu_error_"+fmt.Sprintf("%v", cusErr.Code), ErrMsgs(err)[0]), err)
 			} else {
 				c.STDErr(c.L("rest_update_failed", "Update failed"), err)
@@ -498,7 +546,28 @@ func restQueryHandler(reflectType reflect.Type) func(c *Context) {
 			_ = JSONParse(rawCond, &retCond)
 			ret.Cond = retCond
 		}
+		// 命中查询缓存时直接返回（不执行query钩子），关联表的变更无法使缓存失效，带preload的查询不使用缓存
+		var (
+			cacheKey string
+			cacheTTL = restCacheTTL(reflectType.Name())
+		)
+		if cacheTTL > 0 && c.Query("preload") == "" {
+			cacheKey = restCacheKey(scope.TableName(), newRestCacheKeyDesc(c, cond, modelValue))
+			if cached, ok := getRestCache(cacheKey, reflectType); ok {
+				c.STD(cached)
+				return
+			}
+		}
 		_, db := ParseCond(cond, modelValue, DB().Model(modelValue))
+		// 回收站模式：仅查询已软删除的数据
+		if c.Query("trash") == "true" {
+			var err error
+			if db, err = TrashScope(db, modelValue); err != nil {
+				c.STDErr(c.L("rest_query_failed", "Query failed"), err)
+				return
+			}
+			ret.Trash = true
+		}
 		// 处理project
 		rawProject := c.Query("project")
 		if rawProject != "" {
@@ -663,6 +732,9 @@ func restQueryHandler(reflectType reflect.Type) func(c *Context) {
 			}
 			return
 		}
+		if cacheKey != "" {
+			SetCacheString(cacheKey, JSONStringify(ret), cacheTTL)
+		}
 		c.STD(ret)
 	}
 }
@@ -761,39 +833,86 @@ func restDeleteHandler(reflectType reflect.Type) func(c *Context) {
 func restCreateHandler(reflectType reflect.Type) func(c *Context) {
 	return func(c *Context) {
 		var (
-			docs  []interface{}
-			multi bool
-			err   error
+			docs     []interface{}
+			multi    bool
+			err      error
+			meta     = Meta(reflect.New(reflectType).Interface())
+			upsert   = c.Query("upsert") == "true"
+			cacheKey string
+			result   interface{}
 		)
+		// 幂等请求：相同Idempotency-Key直接返回首次请求的结果
+		if key := c.GetHeader(IdempotencyKeyHeaderKey); key != "" {
+			cacheKey = idempotencyCacheKey(c, meta, key)
+			cached, lock, err := acquireIdempotencyKey(cacheKey)
+			if err != nil {
+				c.STDErr(c.L("rest_idempotency_in_progress", "The same request is being processed, please try again later"), err)
+				return
+			}
+			if lock == nil {
+				var data interface{}
+				_ = JSONParse(cached, &data)
+				c.Header(IdempotentReplayedHeaderKey, "true")
+				c.STD(data)
+				return
+			}
+			defer func() {
+				releaseIdempotencyKey(cacheKey, lock, result)
+			}()
+		}
 		// 事务执行
 		err = c.WithTransaction(func(tx *gorm.DB) error {
 			var body interface{}
 			if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
 				return err
 			}
+			var raws []interface{}
 			indirectScopeValue := indirectValue(body)
 			if indirectScopeValue.Kind() == reflect.Slice {
 				multi = true
 				for i := 0; i < indirectScopeValue.Len(); i++ {
-					doc := reflect.New(reflectType).Elem().Addr().Interface()
-					if err := Copy(indirectScopeValue.Index(i).Interface(), doc); err != nil {
-						return err
-					}
-					docs = append(docs, doc)
+					raws = append(raws, indirectScopeValue.Index(i).Interface())
 				}
 			} else {
+				raws = append(raws, body)
+			}
+			for _, raw := range raws {
 				doc := reflect.New(reflectType).Interface()
-				if err := Copy(body, doc); err != nil {
+				if err := Copy(raw, doc); err != nil {
 					return err
 				}
 				docs = append(docs, doc)
 			}
+			if upsert {
+				keys := upsertKeys(meta)
+				if len(keys) == 0 {
+					return errors.New("upsert fields are not declared")
+				}
+				for i, doc := range docs {
+					saved, err := upsertDoc(c, tx, reflectType, keys, raws[i], doc)
+					if err != nil {
+						return err
+					}
+					docs[i] = meta.OmitPassword(saved)
+				}
+				return tx.Error
+			}
+			// 大批量数据且无逐条回调时使用批量插入
+			if multi && batchCreatable(tx, reflectType, docs) {
+				if err := batchCreate(tx, docs); err != nil {
+					return err
+				}
+				for i, doc := range docs {
+					docs[i] = meta.OmitPassword(doc)
+				}
+				return tx.Error
+			}
 			for i, doc := range docs {
 				bizScope := NewBizScope(c, doc, tx).callCallbacks(BizCreateKind)
 				if bizScope.HasError() {
 					return bizScope.DB.Error
 				}
-				docs[i] = Meta(reflect.New(reflectType).Interface()).OmitPassword(doc)
+				docs[i] = meta.OmitPassword(doc)
 			}
 			return tx.Error
 		})
@@ -806,13 +925,15 @@ func restCreateHandler(reflectType reflect.Type) func(c *Context) {
 			}
 		} else {
 			if multi {
-				c.STD(docs)
+				result = docs
 			} else {
-				c.STD(docs[0])
+				result = docs[0]
 			}
+			c.STD(result)
 		}
 	}
 }
+
 func methodConflict(arr []string) bool {
 	for i, s := range arr {
 		if s == "-" {
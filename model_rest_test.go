@@ -0,0 +1,20 @@
+package kuu
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistoryRoutePath(t *testing.T) {
+	if p := historyRoutePath("/api/user"); p != "/api/user/history/:id" {
+		t.Errorf("unexpected path: %s", p)
+	}
+	// 静态路由在历史路由之前或之后注册均不冲突
+	e := gin.New()
+	e.GET("/api/user/menus", func(*gin.Context) {})
+	e.GET(historyRoutePath("/api/user"), func(*gin.Context) {})
+	e.GET("/api/user/role_assigns/:uid", func(*gin.Context) {})
+	e.GET("/api/user", func(*gin.Context) {})
+	e.GET("/api/user/profile", func(*gin.Context) {})
+}
@@ -0,0 +1,355 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// IdempotencyKeyHeaderKey
+	IdempotencyKeyHeaderKey = "Idempotency-Key"
+	// IdempotentReplayedHeaderKey
+	IdempotentReplayedHeaderKey = "Idempotent-Replayed"
+	// ErrIdempotencyKeyInProgress
+	ErrIdempotencyKeyInProgress = errors.New("request with the same idempotency key is in progress")
+)
+
+// 逐条执行回调的方法，存在时不使用批量插入
+var batchCreateBlockedMethods = []string{
+	"BizBeforeCreate",
+	"BizAfterCreate",
+	"BeforeSave",
+	"BeforeCreate",
+	"AfterCreate",
+	"AfterSave",
+}
+
+// batchCreateCallbacks 批量插入时已由batchCreate等价处理或无需逐条执行的create回调，注册了其他回调时逐条新增
+var batchCreateCallbacks = map[string]bool{
+	"gorm:begin_transaction":              true,
+	"gorm:before_create":                  true,
+	"gorm:save_before_associations":       true,
+	"gorm:update_time_stamp":              true,
+	"gorm:create":                         true,
+	"gorm:force_reload_after_create":      true,
+	"gorm:save_after_associations":        true,
+	"gorm:after_create":                   true,
+	"gorm:commit_or_rollback_transaction": true,
+	"kuu:uuid_create":                     true,
+	"validations:validate":                true,
+	"kuu:update_ts":                       true,
+	"kuu:create":                          true,
+	"kuu:tenant_create":                   true,
+	"kuu:after_save":                      true,
+	"kuu:model_change":                    true,
+	"kuu:model_change_commit":             true,
+	"kuu:history_create":                  true,
+	"kuu:audit_create":                    true,
+	"kuu:sql_comment":                     true,
+	"kuu:trace_begin":                     true,
+	"kuu:trace_end":                       true,
+	"kuu:metrics_begin":                   true,
+	"kuu:metrics_end":                     true,
+	"kuu:slow_query_begin":                true,
+	"kuu:slow_query_end":                  true,
+}
+
+// batchBizCreateCallbacks 默认的业务create回调，仅调用模型上的Biz方法（已由batchCreateBlockedMethods排除）
+var batchBizCreateCallbacks = map[string]bool{
+	"kuu:biz_before_create": true,
+	"kuu:biz_create":        true,
+	"kuu:biz_after_create":  true,
+}
+
+// createCallbacksCustomized 覆盖了CreateCallback、AfterSaveCallback，或注册、替换、移除了create回调时返回true
+func createCallbacksCustomized(tx *gorm.DB) bool {
+	if reflect.ValueOf(CreateCallback).Pointer() != reflect.ValueOf(createCallback).Pointer() ||
+		reflect.ValueOf(AfterSaveCallback).Pointer() != reflect.ValueOf(afterSaveCallback).Pointer() {
+		return true
+	}
+	for _, cp := range DefaultCallback.processors {
+		if cp.kind == BizCreateKind && (cp.replace || cp.remove || !batchBizCreateCallbacks[cp.name]) {
+			return true
+		}
+	}
+	// gorm未导出回调列表，通过反射只读访问（不调用tx.Callback()，避免并发写入）
+	callbacks := reflect.ValueOf(tx).Elem().FieldByName("parent")
+	if callbacks.IsNil() {
+		return true
+	}
+	callbacks = callbacks.Elem().FieldByName("callbacks")
+	if callbacks.IsNil() {
+		return true
+	}
+	processors := callbacks.Elem().FieldByName("processors")
+	for i := 0; i < processors.Len(); i++ {
+		cp := processors.Index(i).Elem()
+		if cp.FieldByName("kind").String() != "create" {
+			continue
+		}
+		if cp.FieldByName("replace").Bool() || cp.FieldByName("remove").Bool() || !batchCreateCallbacks[cp.FieldByName("name").String()] {
+			return true
+		}
+	}
+	return false
+}
+
+func splitFieldNames(value string) (names []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			names = append(names, item)
+		}
+	}
+	return
+}
+
+// upsertKeys 优先使用rest标签中的upsert配置，其次使用kuu标签中的upsert配置
+func upsertKeys(meta *Metadata) []string {
+	if meta == nil {
+		return nil
+	}
+	if meta.RestDesc != nil && len(meta.RestDesc.UpsertKeys) > 0 {
+		return meta.RestDesc.UpsertKeys
+	}
+	return meta.UpsertKeys
+}
+
+// upsertDoc 按唯一字段查询，存在则更新，否则新增
+func upsertDoc(c *Context, tx *gorm.DB, reflectType reflect.Type, keys []string, raw interface{}, doc interface{}) (interface{}, error) {
+	var (
+		scope = tx.NewScope(doc)
+		where = make(map[string]interface{})
+	)
+	for _, key := range keys {
+		field, ok := scope.FieldByName(key)
+		if !ok {
+			return nil, fmt.Errorf("upsert field not found: %s", key)
+		}
+		if field.IsBlank {
+			return nil, fmt.Errorf("upsert field is required: %s", key)
+		}
+		where[field.DBName] = field.Field.Interface()
+	}
+	existing := reflect.New(reflectType).Interface()
+	err := tx.Where(where).First(existing).Error
+	if gorm.IsRecordNotFoundError(err) {
+		bizScope := NewBizScope(c, doc, tx).callCallbacks(BizCreateKind)
+		if bizScope.HasError() {
+			return nil, bizScope.DB.Error
+		}
+		return doc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	params := BizUpdateParams{Cond: where}
+	_ = Copy(raw, &params.Doc)
+	bizScope := NewBizScope(c, existing, tx)
+	bizScope.UpdateParams = &params
+	bizScope.UpdateCond = existing
+	bizScope.Value = doc
+	bizScope.callCallbacks(BizUpdateKind)
+	if bizScope.HasError() {
+		return nil, bizScope.DB.Error
+	}
+	updated := reflect.New(reflectType).Interface()
+	if err := tx.Where(where).First(updated).Error; err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// batchCreatable 无逐条回调、无关联数据且未指定主键时才允许批量插入，
+// 仅支持可通过RETURNING准确回填主键的postgres（mysql在auto_increment_increment>1等情况下无法推算主键）
+func batchCreatable(tx *gorm.DB, reflectType reflect.Type, docs []interface{}) bool {
+	threshold := C().DefaultGetInt("rest:batchInsertThreshold", 500)
+	if threshold <= 0 || len(docs) < threshold {
+		return false
+	}
+	if tx.Dialect().GetName() != "postgres" || createCallbacksCustomized(tx) {
+		return false
+	}
+	value := reflect.New(reflectType)
+	for _, name := range batchCreateBlockedMethods {
+		if value.MethodByName(name).IsValid() {
+			return false
+		}
+	}
+	if meta := Meta(value.Interface()); meta != nil && meta.TagSettings["UUID"] != "" {
+		return false
+	}
+	if pk := tx.NewScope(value.Interface()).PrimaryField(); pk == nil {
+		return false
+	}
+	for _, doc := range docs {
+		for _, field := range tx.NewScope(doc).Fields() {
+			if (field.Relationship != nil || field.IsPrimaryKey) && !field.IsBlank {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// batchCreate 预处理默认字段、校验及权限后分批插入，回填主键并逐条记录变更历史和审计日志
+func batchCreate(tx *gorm.DB, docs []interface{}) error {
+	var (
+		now     = time.Now()
+		scopes  = make([]*gorm.Scope, 0, len(docs))
+		columns []string
+		names   []string
+	)
+	for _, doc := range docs {
+		scope := tx.NewScope(doc)
+		for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+			if field, ok := scope.FieldByName(name); ok && field.IsBlank {
+				_ = field.Set(now)
+			}
+		}
+		updateTsForCreateCallback(scope)
+		ValidateCallback(scope)
+		CreateCallback(scope)
+		tenantCreateCallback(scope)
+		if scope.HasError() {
+			return scope.DB().Error
+		}
+		scopes = append(scopes, scope)
+	}
+	for _, field := range scopes[0].Fields() {
+		if !field.IsNormal || field.IsIgnored || field.IsPrimaryKey {
+			continue
+		}
+		if field.HasDefaultValue {
+			// 有默认值的字段仅在存在非空值时插入
+			var hasValue bool
+			for _, scope := range scopes {
+				if f, ok := scope.FieldByName(field.Name); ok && !f.IsBlank {
+					hasValue = true
+					break
+				}
+			}
+			if !hasValue {
+				continue
+			}
+		}
+		columns = append(columns, scopes[0].Quote(field.DBName))
+		names = append(names, field.Name)
+	}
+	insertBase := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", scopes[0].QuotedTableName(), strings.Join(columns, ", "))
+	items := make([]BatchInsertItem, 0, len(scopes))
+	for _, scope := range scopes {
+		item := BatchInsertItem{SQL: fmt.Sprintf("(%s)", strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))}
+		for _, name := range names {
+			field, _ := scope.FieldByName(name)
+			item.Vars = append(item.Vars, field.Field.Interface())
+		}
+		items = append(items, item)
+	}
+	size := C().DefaultGetInt("rest:batchInsertSize", 200)
+	if size <= 0 {
+		size = len(items)
+	}
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		if err := batchInsertWithIDs(tx, insertBase, scopes[start:end], items[start:end]); err != nil {
+			return err
+		}
+	}
+	// 与逐条新增一致，写入变更历史和审计日志
+	for i, scope := range scopes {
+		scope.SQL = insertBase + items[i].SQL
+		scope.SQLVars = items[i].Vars
+		historyCreateCallback(scope)
+		if C().DefaultGetBool("audit:callbacks", true) {
+			AuditCreateCallback(scope)
+		}
+	}
+	// 与逐条新增一致，使查询缓存和依赖该模型的缓存失效
+	modelChangeCallback(scopes[0])
+	return nil
+}
+
+// batchInsertWithIDs 执行一批插入并通过RETURNING回填主键
+func batchInsertWithIDs(tx *gorm.DB, insertBase string, scopes []*gorm.Scope, items []BatchInsertItem) error {
+	var (
+		dialect = tx.Dialect()
+		values  = make([]string, 0, len(items))
+		vars    []interface{}
+		pk      = scopes[0].PrimaryField()
+	)
+	for _, item := range items {
+		placeholders := make([]string, len(item.Vars))
+		for i := range item.Vars {
+			placeholders[i] = dialect.BindVar(len(vars) + i + 1)
+		}
+		values = append(values, fmt.Sprintf("(%s)", strings.Join(placeholders, ", ")))
+		vars = append(vars, item.Vars...)
+	}
+	sql := insertBase + strings.Join(values, ", ")
+	ids := make([]int64, 0, len(items))
+	rows, err := tx.CommonDB().Query(fmt.Sprintf("%s RETURNING %s", sql, scopes[0].Quote(pk.DBName)), vars...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) != len(scopes) {
+		return fmt.Errorf("batch insert returned %d ids for %d rows", len(ids), len(scopes))
+	}
+	for i, scope := range scopes {
+		if err := scope.PrimaryField().Set(ids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func idempotencyCacheKey(c *Context, meta *Metadata, key string) string {
+	var uid string
+	if desc := GetRoutinePrivilegesDesc(); desc.IsValid() {
+		uid = fmt.Sprintf("%d", desc.UID)
+	}
+	var name string
+	if meta != nil {
+		name = meta.Name
+	}
+	return BuildKey("idempotency", c.Request.Method, name, uid, key)
+}
+
+// acquireIdempotencyKey 返回已缓存的响应结果，或者获取执行权
+func acquireIdempotencyKey(cacheKey string) (cached string, lock *Lock, err error) {
+	if cached = GetCacheString(cacheKey); cached != "" {
+		return
+	}
+	// 锁自动过期，避免进程异常退出后无法重试
+	lock, err = TryLock(cacheKey, time.Duration(C().DefaultGetInt("rest:idempotencyLockTimeout", 60))*time.Second)
+	if err == ErrLockNotAcquired {
+		err = ErrIdempotencyKeyInProgress
+	}
+	return
+}
+
+func releaseIdempotencyKey(cacheKey string, lock *Lock, result interface{}) {
+	if result != nil {
+		ttl := time.Duration(C().DefaultGetInt("rest:idempotencyTTL", 86400)) * time.Second
+		SetCacheString(cacheKey, JSONStringify(result), ttl)
+	}
+	_ = lock.Unlock()
+}
@@ -0,0 +1,96 @@
+package kuu
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type batchTestDoc struct {
+	ID        uint
+	Name      string
+	CreatedAt time.Time
+}
+
+func newBatchTestDocs(n int) (docs []interface{}) {
+	for i := 0; i < n; i++ {
+		docs = append(docs, &batchTestDoc{Name: "doc"})
+	}
+	return
+}
+
+func batchTestIDs(docs []interface{}) (ids []uint) {
+	for _, doc := range docs {
+		ids = append(ids, doc.(*batchTestDoc).ID)
+	}
+	return
+}
+
+func TestBatchCreateIDs(t *testing.T) {
+	defer setTestConfig("rest:batchInsertThreshold", "2")()
+	defer setTestConfig("rest:batchInsertSize", "2")()
+	defer setTestConfig("audit:callbacks", "false")()
+
+	// postgres通过RETURNING按插入顺序返回主键
+	var nextID int64 = 100
+	db := newTestDBWithDialect(t, "postgres", func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, `RETURNING "id"`) {
+			return nil, nil
+		}
+		var rows [][]driver.Value
+		for i := 0; i < strings.Count(query, "(")-1; i++ {
+			rows = append(rows, []driver.Value{nextID})
+			nextID++
+		}
+		return []string{"id"}, rows
+	}, nil)
+	docs := newBatchTestDocs(3)
+	if !batchCreatable(db, reflect.TypeOf(batchTestDoc{}), docs) {
+		t.Fatal("expected docs to be batch creatable")
+	}
+	if err := batchCreate(db, docs); err != nil {
+		t.Fatal(err)
+	}
+	if ids := batchTestIDs(docs); !reflect.DeepEqual(ids, []uint{100, 101, 102}) {
+		t.Errorf("unexpected postgres ids: %v", ids)
+	}
+
+	for _, dialect := range []string{"mysql", "sqlite3"} {
+		if batchCreatable(newTestDBWithDialect(t, dialect, nil, nil), reflect.TypeOf(batchTestDoc{}), newBatchTestDocs(3)) {
+			t.Errorf("expected %s to be rejected", dialect)
+		}
+	}
+}
+
+func TestBatchCreateCustomCallbacks(t *testing.T) {
+	defer setTestConfig("rest:batchInsertThreshold", "2")()
+
+	db := newTestDBWithDialect(t, "postgres", nil, nil)
+	if createCallbacksCustomized(db) {
+		t.Fatal("expected default callbacks")
+	}
+	db.Callback().Create().After("gorm:create").Register("app:after_create", func(*gorm.Scope) {})
+	if !createCallbacksCustomized(db) {
+		t.Error("expected registered gorm callback to disable batching")
+	}
+	if batchCreatable(db, reflect.TypeOf(batchTestDoc{}), newBatchTestDocs(3)) {
+		t.Error("expected docs not to be batch creatable")
+	}
+
+	db = newTestDBWithDialect(t, "postgres", nil, nil)
+	db.Callback().Create().Remove("gorm:force_reload_after_create")
+	if !createCallbacksCustomized(db) {
+		t.Error("expected removed gorm callback to disable batching")
+	}
+
+	old := CreateCallback
+	defer func() { CreateCallback = old }()
+	CreateCallback = func(scope *gorm.Scope) { old(scope) }
+	if !createCallbacksCustomized(newTestDBWithDialect(t, "postgres", nil, nil)) {
+		t.Error("expected overridden CreateCallback to disable batching")
+	}
+}
@@ -0,0 +1,138 @@
+package kuu
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GormExpectedTsKey 更新时期望的Ts值，设置后仅当数据库中的Ts一致时才会更新
+var GormExpectedTsKey = "kuu:expected_ts"
+
+type expectedTsValue struct {
+	table string
+	ts    time.Time
+}
+
+// WithExpectedTs 开启乐观锁，model对应表的Ts不一致时更新返回ErrVersionConflict
+func WithExpectedTs(db *gorm.DB, model interface{}, ts time.Time) *gorm.DB {
+	return db.Set(GormExpectedTsKey, expectedTsValue{table: db.NewScope(model).TableName(), ts: ts})
+}
+
+// ParseExpectedTs 解析期望的Ts，支持RFC3339格式及毫秒时间戳
+func ParseExpectedTs(value interface{}) (ts time.Time, ok bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case *time.Time:
+		if v != nil {
+			return *v, !v.IsZero()
+		}
+	case float64:
+		if v > 0 {
+			return time.Unix(0, int64(v)*int64(time.Millisecond)), true
+		}
+	case string:
+		if v == "" {
+			return
+		}
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return ParseExpectedTs(float64(ms))
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, !t.IsZero()
+		}
+	}
+	return
+}
+
+// isMillisecondTs 毫秒时间戳解析出的Ts没有毫秒以下的部分，而数据库中的Ts精确到微秒
+func isMillisecondTs(ts time.Time) bool {
+	return ts.Nanosecond()%int(time.Millisecond) == 0
+}
+
+// MatchExpectedTs 期望的Ts为毫秒精度时按毫秒比较，否则精确比较
+func MatchExpectedTs(current, expected time.Time) bool {
+	if isMillisecondTs(expected) {
+		return current.Truncate(time.Millisecond).Equal(expected)
+	}
+	return current.Equal(expected)
+}
+
+// expectedTs 仅对目标表生效，避免关联表的级联更新被误判
+func expectedTs(scope *gorm.Scope) (ts time.Time, ok bool) {
+	if v, has := scope.Get(GormExpectedTsKey); has {
+		if value, is := v.(expectedTsValue); is && value.table == scope.TableName() {
+			return value.ts, true
+		}
+	}
+	return
+}
+
+func registerOptimisticLockCallbacks(callback *gorm.Callback) {
+	if callback.Update().Get("kuu:check_ts") == nil {
+		callback.Update().Before("gorm:update").Register("kuu:check_ts", checkTsBeforeUpdateCallback)
+	}
+	if callback.Update().Get("kuu:check_ts_affected") == nil {
+		callback.Update().After("gorm:update").Register("kuu:check_ts_affected", checkTsAfterUpdateCallback)
+	}
+}
+
+func checkTsBeforeUpdateCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		ts, ok := expectedTs(scope)
+		if !ok {
+			return
+		}
+		if field, ok := scope.FieldByName("Ts"); ok {
+			column := fmt.Sprintf("%v.%v", scope.QuotedTableName(), scope.Quote(field.DBName))
+			if isMillisecondTs(ts) {
+				scope.Search.Where(fmt.Sprintf("%v >= ? AND %v < ?", column, column), ts, ts.Add(time.Millisecond))
+			} else {
+				scope.Search.Where(fmt.Sprintf("%v = ?", column), ts)
+			}
+		}
+	}
+}
+
+func checkTsAfterUpdateCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		if _, ok := expectedTs(scope); !ok {
+			return
+		}
+		if _, ok := scope.FieldByName("Ts"); ok && scope.DB().RowsAffected < 1 {
+			_ = scope.Err(ErrWith(ErrVersionConflict, "数据已被其他用户修改，请刷新后重试"))
+		}
+	}
+}
+
+// IsVersionConflict
+func IsVersionConflict(err error) bool {
+	if cusErr, ok := ErrOut(err); ok {
+		return cusErr.Code == ErrVersionConflictCode
+	}
+	return false
+}
+
+// currentRecord 从主库重新查询冲突数据的最新值
+func currentRecord(reflectType reflect.Type, stale interface{}) interface{} {
+	if stale == nil {
+		return nil
+	}
+	scope := DB().NewScope(stale)
+	field := scope.PrimaryField()
+	if field == nil || field.IsBlank {
+		return nil
+	}
+	ReadPrimary()
+	defer ReadPrimary(true)
+	current := reflect.New(reflectType).Interface()
+	if err := DB().Where(fmt.Sprintf("%v = ?", scope.Quote(field.DBName)), field.Field.Interface()).First(current).Error; err != nil {
+		ERROR(err)
+		return nil
+	}
+	return current
+}
@@ -0,0 +1,89 @@
+package kuu
+
+import (
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpectedTs(t *testing.T) {
+	now := time.Now()
+	cases := []interface{}{
+		now,
+		&now,
+		now.Format(time.RFC3339Nano),
+	}
+	for _, item := range cases {
+		if ts, ok := ParseExpectedTs(item); !ok || !ts.Equal(now) {
+			t.Errorf("parse %v failed: %v", item, ts)
+		}
+	}
+	ms := now.UnixNano() / int64(time.Millisecond)
+	if ts, ok := ParseExpectedTs(float64(ms)); !ok || ts.UnixNano()/int64(time.Millisecond) != ms {
+		t.Errorf("parse milliseconds failed: %v", ts)
+	}
+	for _, item := range []interface{}{nil, "", "invalid", time.Time{}} {
+		if _, ok := ParseExpectedTs(item); ok {
+			t.Errorf("unexpected valid value: %v", item)
+		}
+	}
+}
+
+type tsTestDoc struct {
+	ID   uint
+	Name string
+	Ts   time.Time
+}
+
+func TestExpectedTsUpdate(t *testing.T) {
+	stored := time.Date(2019, 12, 1, 10, 20, 30, 123456000, time.UTC)
+	// 模拟数据库按ts条件更新
+	db := newTestDB(t, nil, func(query string, args []driver.Value) driver.Result {
+		var times []time.Time
+		for _, arg := range args {
+			if v, ok := arg.(time.Time); ok {
+				times = append(times, v)
+			}
+		}
+		switch {
+		case len(times) == 2 && strings.Contains(query, ">="):
+			if !stored.Before(times[0]) && stored.Before(times[1]) {
+				return driver.RowsAffected(1)
+			}
+		case len(times) == 1:
+			if stored.Equal(times[0]) {
+				return driver.RowsAffected(1)
+			}
+		}
+		return driver.RowsAffected(0)
+	})
+	registerOptimisticLockCallbacks(db.Callback())
+
+	ms := stored.UnixNano() / int64(time.Millisecond)
+	cases := []struct {
+		value    interface{}
+		conflict bool
+	}{
+		{float64(ms), false},
+		{strconv.FormatInt(ms, 10), false},
+		{stored.Format(time.RFC3339Nano), false},
+		{float64(ms - 1), true},
+		{stored.Add(time.Microsecond).Format(time.RFC3339Nano), true},
+	}
+	for _, item := range cases {
+		ts, ok := ParseExpectedTs(item.value)
+		if !ok {
+			t.Fatalf("parse %v failed", item.value)
+		}
+		if MatchExpectedTs(stored, ts) == item.conflict {
+			t.Errorf("unexpected match result of %v", item.value)
+		}
+		doc := tsTestDoc{ID: 1, Ts: stored}
+		err := WithExpectedTs(db, &doc, ts).Model(&doc).Update("name", "new").Error
+		if IsVersionConflict(err) != item.conflict {
+			t.Errorf("unexpected update result of %v: %v", item.value, err)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package kuu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-redis/redis"
+	"github.com/jinzhu/gorm"
+	"github.com/jtolds/gls"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// TaskStatusPending
+	TaskStatusPending = "pending"
+	// TaskStatusDead 超过最大重试次数，进入死信
+	TaskStatusDead = "dead"
+)
+
+var (
+	// ErrTaskQueueUnavailable
+	ErrTaskQueueUnavailable = errors.New("task queue is not initialized")
+	// ErrTaskNotFound
+	ErrTaskNotFound = errors.New("task not found")
+
+	// DefaultTaskBroker 配置redis时使用Redis，否则使用数据库
+	DefaultTaskBroker TaskBroker
+
+	taskHandlers   = make(map[string]TaskHandler)
+	taskHandlersMu sync.RWMutex
+	taskWorkers    struct {
+		sync.Mutex
+		stop chan struct{}
+		wg   sync.WaitGroup
+	}
+)
+
+// Task 队列任务，Redis队列仅在进入死信时落库
+type Task struct {
+	ID         string    `gorm:"primary_key;size:36"`
+	CreatedAt  time.Time `name:"创建时间"`
+	UpdatedAt  time.Time `name:"修改时间"`
+	Type       string    `name:"任务类型" sql:"index"`
+	Payload    string    `name:"任务参数" gorm:"type:text"`
+	Status     string    `name:"任务状态" sql:"index"`
+	Attempts   int       `name:"已执行次数"`
+	MaxRetries int       `name:"最大重试次数"`
+	ProcessAt  time.Time `name:"计划执行时间" sql:"index"`
+	LastError  string    `name:"最近错误" gorm:"type:text"`
+	Sign       string    `name:"入队用户信息" gorm:"type:text"`
+}
+
+// IgnoreLog
+func (t *Task) IgnoreLog() {}
+
+// Bind 解析任务参数
+func (t *Task) Bind(v interface{}) error {
+	return JSONParse(t.Payload, v)
+}
+
+// TaskOptions
+type TaskOptions struct {
+	// Delay 延迟执行
+	Delay time.Duration
+	// ProcessAt 指定执行时间，优先于Delay
+	ProcessAt time.Time
+	// MaxRetries 最大重试次数，0表示使用queue:maxRetries，负数表示不重试
+	MaxRetries int
+}
+
+// TaskHandler 返回错误时按指数退避重试
+type TaskHandler func(ctx context.Context, task *Task) error
+
+// TaskBroker 任务存储，Claim在可见性超时内独占任务，超时未确认的任务会被重新领取
+type TaskBroker interface {
+	Push(task *Task) error
+	Claim(visibility time.Duration) (*Task, error)
+	Ack(task *Task) error
+	Retry(task *Task) error
+	Bury(task *Task) error
+}
+
+// taskSign 入队用户的登录信息，不包含令牌及密钥
+type taskSign struct {
+	Type     string
+	Lang     string
+	UID      uint
+	SubDocID uint
+	Payload  jwt.MapClaims
+}
+
+// RegisterTaskHandler
+func RegisterTaskHandler(taskType string, handler TaskHandler) {
+	taskHandlersMu.Lock()
+	defer taskHandlersMu.Unlock()
+	taskHandlers[taskType] = handler
+}
+
+func getTaskHandler(taskType string) TaskHandler {
+	taskHandlersMu.RLock()
+	defer taskHandlersMu.RUnlock()
+	return taskHandlers[taskType]
+}
+
+// Enqueue 添加任务，返回任务ID
+func Enqueue(taskType string, payload interface{}, opts ...TaskOptions) (string, error) {
+	if DefaultTaskBroker == nil {
+		return "", ErrTaskQueueUnavailable
+	}
+	var opt TaskOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	task := &Task{
+		ID:         uuid.NewV4().String(),
+		Type:       taskType,
+		Status:     TaskStatusPending,
+		MaxRetries: opt.MaxRetries,
+		ProcessAt:  time.Now().Add(opt.Delay),
+		CreatedAt:  time.Now(),
+	}
+	if !opt.ProcessAt.IsZero() {
+		task.ProcessAt = opt.ProcessAt
+	}
+	if task.MaxRetries == 0 {
+		task.MaxRetries = C().DefaultGetInt("queue:maxRetries", 5)
+	} else if task.MaxRetries < 0 {
+		task.MaxRetries = 0
+	}
+	if v, ok := payload.(string); ok {
+		task.Payload = v
+	} else {
+		task.Payload = JSONStringify(payload)
+	}
+	// 记录入队用户，执行时恢复至GLS
+	if raw, _ := GetGLSValue(GLSSignInfoKey); !IsBlank(raw) {
+		if sign, ok := raw.(*SignContext); ok && sign.UID != 0 {
+			task.Sign = JSONStringify(&taskSign{
+				Type:     sign.Type,
+				Lang:     sign.Lang,
+				UID:      sign.UID,
+				SubDocID: sign.SubDocID,
+				Payload:  sign.Payload,
+			})
+		}
+	}
+	if err := DefaultTaskBroker.Push(task); err != nil {
+		return "", err
+	}
+	return task.ID, nil
+}
+
+// taskBackoff 第n次失败后的等待时间：base * 2^(n-1)，不超过max
+func taskBackoff(attempts int, base, max time.Duration) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func taskVisibility() time.Duration {
+	return time.Duration(C().DefaultGetInt("queue:visibilityTimeout", 300)) * time.Second
+}
+
+// initTaskQueue 初始化任务存储并启动消费者
+func initTaskQueue() {
+	if DefaultTaskBroker == nil {
+		if v, ok := DefaultCache.(*CacheRedis); ok {
+			DefaultTaskBroker = &RedisTaskBroker{client: v.client}
+		} else {
+			DefaultTaskBroker = &DBTaskBroker{}
+		}
+	}
+	StartTaskWorkers(C().DefaultGetInt("queue:workers", 4))
+}
+
+// StartTaskWorkers 启动n个消费者，重复调用时忽略
+func StartTaskWorkers(n int) {
+	taskWorkers.Lock()
+	defer taskWorkers.Unlock()
+	if taskWorkers.stop != nil || n <= 0 {
+		return
+	}
+	taskWorkers.stop = make(chan struct{})
+	interval := time.Duration(C().DefaultGetInt("queue:pollInterval", 1000)) * time.Millisecond
+	for i := 0; i < n; i++ {
+		taskWorkers.wg.Add(1)
+		go runTaskWorker(taskWorkers.stop, interval)
+	}
+}
+
+// StopTaskWorkers 等待执行中的任务结束
+func StopTaskWorkers() {
+	taskWorkers.Lock()
+	defer taskWorkers.Unlock()
+	if taskWorkers.stop == nil {
+		return
+	}
+	close(taskWorkers.stop)
+	taskWorkers.wg.Wait()
+	taskWorkers.stop = nil
+}
+
+func runTaskWorker(stop chan struct{}, interval time.Duration) {
+	defer taskWorkers.wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		task, err := DefaultTaskBroker.Claim(taskVisibility())
+		if err != nil {
+			ERROR("Claim task failed: %s", err.Error())
+		}
+		if task == nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+			continue
+		}
+		processTask(task)
+	}
+}
+
+func processTask(task *Task) {
+	var (
+		handler = getTaskHandler(task.Type)
+		err     error
+	)
+	if handler == nil {
+		err = fmt.Errorf("no task handler registered for type: %s", task.Type)
+	} else {
+		err = callTaskHandler(handler, task)
+	}
+	if err == nil {
+		if err := DefaultTaskBroker.Ack(task); err != nil {
+			ERROR("Ack task %s failed: %s", task.ID, err.Error())
+		}
+		return
+	}
+	task.LastError = err.Error()
+	if task.Attempts > task.MaxRetries {
+		ERROR("Task %s(%s) moved to dead letters after %d attempts: %s", task.Type, task.ID, task.Attempts, task.LastError)
+		task.Status = TaskStatusDead
+		err = DefaultTaskBroker.Bury(task)
+	} else {
+		WARN("Task %s(%s) failed, attempt %d: %s", task.Type, task.ID, task.Attempts, task.LastError)
+		base := time.Duration(C().DefaultGetInt("queue:retryBackoff", 10)) * time.Second
+		max := time.Duration(C().DefaultGetInt("queue:maxBackoff", 3600)) * time.Second
+		task.ProcessAt = time.Now().Add(taskBackoff(task.Attempts, base, max))
+		err = DefaultTaskBroker.Retry(task)
+	}
+	if err != nil {
+		ERROR("Update task %s failed: %s", task.ID, err.Error())
+	}
+}
+
+// callTaskHandler 恢复入队用户的登录信息，捕获异常
+func callTaskHandler(handler TaskHandler, task *Task) (err error) {
+	glsVals := make(gls.Values)
+	glsVals[GLSRoutineCachesKey] = make(RoutineCaches)
+	if task.Sign != "" {
+		var s taskSign
+		if err := JSONParse(task.Sign, &s); err == nil && s.UID != 0 {
+			sign := &SignContext{Type: s.Type, Lang: s.Lang, UID: s.UID, SubDocID: s.SubDocID, Payload: s.Payload, task: true}
+			glsVals[GLSSignInfoKey] = sign
+			glsVals[GLSPrisDescKey] = GetPrivilegesDesc(sign)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), taskVisibility())
+	defer cancel()
+	SetGLSValues(glsVals, func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				ERROR("Task %s(%s) panic: %v\n%s", task.Type, task.ID, r, stack(3))
+			}
+		}()
+		err = handler(ctx, task)
+	})
+	return
+}
+
+// DeadTasks 分页查询死信
+func DeadTasks(page, size int) (list []Task, total int, err error) {
+	db := DS(singleDSName).Model(&Task{}).Where(&Task{Status: TaskStatusDead})
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("updated_at desc").Offset((page - 1) * size).Limit(size).Find(&list).Error
+	return
+}
+
+// RetryDeadTask 将死信重新放回队列
+func RetryDeadTask(id string) error {
+	var task Task
+	if err := DS(singleDSName).Where(&Task{ID: id, Status: TaskStatusDead}).First(&task).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrTaskNotFound
+		}
+		return err
+	}
+	if DefaultTaskBroker == nil {
+		return ErrTaskQueueUnavailable
+	}
+	task.Status = TaskStatusPending
+	task.Attempts = 0
+	task.ProcessAt = time.Now()
+	if _, ok := DefaultTaskBroker.(*DBTaskBroker); ok {
+		return DS(singleDSName).Save(&task).Error
+	}
+	if err := DS(singleDSName).Delete(&Task{ID: task.ID}).Error; err != nil {
+		return err
+	}
+	return DefaultTaskBroker.Push(&task)
+}
+
+// DBTaskBroker 基于数据库的任务存储，通过条件更新领取任务，支持多实例；任务表固定存于主数据源，不随租户路由
+type DBTaskBroker struct{}
+
+// Push
+func (b *DBTaskBroker) Push(task *Task) error {
+	return DS(singleDSName).Create(task).Error
+}
+
+// Claim
+func (b *DBTaskBroker) Claim(visibility time.Duration) (*Task, error) {
+	var (
+		task Task
+		now  = time.Now()
+	)
+	err := DS(singleDSName).Where("status = ? AND process_at <= ?", TaskStatusPending, now).Order("process_at asc").First(&task).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	db := DS(singleDSName).Model(&Task{}).
+		Where("id = ? AND attempts = ? AND process_at = ?", task.ID, task.Attempts, task.ProcessAt).
+		Updates(map[string]interface{}{"attempts": task.Attempts + 1, "process_at": now.Add(visibility)})
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	// 已被其他消费者领取
+	if db.RowsAffected < 1 {
+		return nil, nil
+	}
+	task.Attempts++
+	return &task, nil
+}
+
+// Ack
+func (b *DBTaskBroker) Ack(task *Task) error {
+	return DS(singleDSName).Delete(&Task{ID: task.ID}).Error
+}
+
+// Retry
+func (b *DBTaskBroker) Retry(task *Task) error {
+	return DS(singleDSName).Model(&Task{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+		"process_at": task.ProcessAt,
+		"last_error": task.LastError,
+	}).Error
+}
+
+// Bury
+func (b *DBTaskBroker) Bury(task *Task) error {
+	return DS(singleDSName).Model(&Task{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+		"status":     TaskStatusDead,
+		"last_error": task.LastError,
+	}).Error
+}
+
+// RedisTaskBroker 任务ID按执行时间存入有序集合，任务数据存入哈希表，死信落库
+type RedisTaskBroker struct {
+	client redis.UniversalClient
+}
+
+var redisClaimTaskScript = redis.NewScript(`
+local ids = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if #ids == 0 then return false end
+redis.call("zadd", KEYS[1], ARGV[2], ids[1])
+return ids[1]`)
+
+// keys 集群模式下两个键使用相同的hash tag，保证位于同一槽位，事务才能原子执行
+func (b *RedisTaskBroker) keys() (string, string) {
+	if _, ok := b.client.(*redis.ClusterClient); ok {
+		tag := fmt.Sprintf("{%s}", BuildKey("queue"))
+		return tag + "_tasks", tag + "_data"
+	}
+	return BuildKey("queue_tasks"), BuildKey("queue_data")
+}
+
+func taskScore(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+// Push
+func (b *RedisTaskBroker) Push(task *Task) error {
+	tasksKey, dataKey := b.keys()
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(dataKey, task.ID, JSONStringify(task))
+		pipe.ZAdd(tasksKey, redis.Z{Score: taskScore(task.ProcessAt), Member: task.ID})
+		return nil
+	})
+	return err
+}
+
+// Claim
+func (b *RedisTaskBroker) Claim(visibility time.Duration) (*Task, error) {
+	tasksKey, dataKey := b.keys()
+	now := time.Now()
+	id, err := redisClaimTaskScript.Run(b.client, []string{tasksKey},
+		strconv.FormatFloat(taskScore(now), 'f', 0, 64),
+		strconv.FormatFloat(taskScore(now.Add(visibility)), 'f', 0, 64)).String()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.client.HGet(dataKey, id).Result()
+	if err == redis.Nil {
+		b.client.ZRem(tasksKey, id)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var task Task
+	if err := JSONParse(data, &task); err != nil {
+		return nil, err
+	}
+	task.Attempts++
+	task.ProcessAt = now.Add(visibility)
+	if err := b.client.HSet(dataKey, task.ID, JSONStringify(&task)).Err(); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Ack
+func (b *RedisTaskBroker) Ack(task *Task) error {
+	tasksKey, dataKey := b.keys()
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRem(tasksKey, task.ID)
+		pipe.HDel(dataKey, task.ID)
+		return nil
+	})
+	return err
+}
+
+// Retry
+func (b *RedisTaskBroker) Retry(task *Task) error {
+	return b.Push(task)
+}
+
+// Bury
+func (b *RedisTaskBroker) Bury(task *Task) error {
+	task.Status = TaskStatusDead
+	if err := DS(singleDSName).Create(task).Error; err != nil {
+		return err
+	}
+	return b.Ack(task)
+}
+
+// TaskDeadListRoute
+var TaskDeadListRoute = RouteInfo{
+	Name:   "查询死信任务",
+	Method: "GET",
+	Path:   "/tasks/dead",
+	HandlerFunc: func(c *Context) {
+		var result BizQueryResult
+		result.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+		result.Size, _ = strconv.Atoi(c.DefaultQuery("size", "30"))
+		if result.Page < 1 {
+			result.Page = 1
+		}
+		if result.Size < 1 {
+			result.Size = 30
+		}
+		list, total, err := DeadTasks(result.Page, result.Size)
+		if err != nil {
+			c.STDErr(c.L("task_dead_list_failed", "Query dead tasks failed"), err)
+			return
+		}
+		result.TotalRecords = total
+		result.TotalPages = (total + result.Size - 1) / result.Size
+		result.List = list
+		c.STD(result)
+	},
+}
+
+// TaskDeadRetryRoute
+var TaskDeadRetryRoute = RouteInfo{
+	Name:   "重试死信任务",
+	Method: "POST",
+	Path:   "/tasks/dead/retry",
+	HandlerFunc: func(c *Context) {
+		var (
+			failedMessage = c.L("task_dead_retry_failed", "Retry dead task failed")
+			body          struct {
+				IDs []string `binding:"required"`
+			}
+		)
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.STDErr(failedMessage, err)
+			return
+		}
+		for _, id := range body.IDs {
+			if err := RetryDeadTask(id); err != nil {
+				c.STDErr(failedMessage, err)
+				return
+			}
+		}
+		c.STD("ok")
+	},
+}
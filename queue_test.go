@@ -0,0 +1,140 @@
+package kuu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+type testTaskBroker struct {
+	tasks  map[string]*Task
+	dead   map[string]*Task
+	acked  int
+	claims int
+}
+
+func (b *testTaskBroker) Push(task *Task) error {
+	b.tasks[task.ID] = task
+	return nil
+}
+
+func (b *testTaskBroker) Claim(visibility time.Duration) (*Task, error) {
+	for _, task := range b.tasks {
+		if !task.ProcessAt.After(time.Now()) {
+			b.claims++
+			task.Attempts++
+			task.ProcessAt = time.Now().Add(visibility)
+			return task, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *testTaskBroker) Ack(task *Task) error {
+	b.acked++
+	delete(b.tasks, task.ID)
+	return nil
+}
+
+func (b *testTaskBroker) Retry(task *Task) error {
+	// 测试中忽略退避时间
+	task.ProcessAt = time.Now()
+	return nil
+}
+
+func (b *testTaskBroker) Bury(task *Task) error {
+	delete(b.tasks, task.ID)
+	b.dead[task.ID] = task
+	return nil
+}
+
+func TestTaskBackoff(t *testing.T) {
+	base, max := 10*time.Second, time.Minute
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, want := range expected {
+		if got := taskBackoff(i+1, base, max); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestProcessTask(t *testing.T) {
+	broker := &testTaskBroker{tasks: make(map[string]*Task), dead: make(map[string]*Task)}
+	origin := DefaultTaskBroker
+	DefaultTaskBroker = broker
+	defer func() { DefaultTaskBroker = origin }()
+
+	var calls int
+	RegisterTaskHandler("test_flaky", func(ctx context.Context, task *Task) error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	})
+	RegisterTaskHandler("test_panic", func(ctx context.Context, task *Task) error {
+		panic("boom")
+	})
+	flakyID, err := Enqueue("test_flaky", struct{ A int }{1}, TaskOptions{MaxRetries: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	panicID, _ := Enqueue("test_panic", nil, TaskOptions{MaxRetries: 1})
+	delayedID, _ := Enqueue("test_flaky", nil, TaskOptions{Delay: time.Hour})
+
+	for i := 0; i < 10; i++ {
+		task, _ := broker.Claim(time.Minute)
+		if task == nil {
+			break
+		}
+		processTask(task)
+	}
+	if _, ok := broker.tasks[flakyID]; ok || calls != 3 {
+		t.Errorf("flaky task should succeed on the third attempt, calls: %d", calls)
+	}
+	if dead := broker.dead[panicID]; dead == nil || dead.Attempts != 2 || dead.Status != TaskStatusDead {
+		t.Errorf("panic task should be dead after 2 attempts: %+v", dead)
+	}
+	if task := broker.tasks[delayedID]; task == nil || task.Attempts != 0 {
+		t.Error("delayed task should not be claimed")
+	}
+}
+
+func TestTaskHandlerIdentity(t *testing.T) {
+	prev := DefaultCache
+	DefaultCache = NewCacheMemory()
+	defer func() { DefaultCache = prev }()
+	// 预置入队用户的权限缓存，避免查询数据库
+	SetCacheString(fmt.Sprintf("privileges_%d_%d", cacheVersion("privileges"), 7), `{"UID":7,"Valid":true}`)
+
+	var uid uint
+	handler := func(ctx context.Context, task *Task) error {
+		if desc := GetRoutinePrivilegesDesc(); desc != nil {
+			uid = desc.UID
+		}
+		return nil
+	}
+	// 入队用户信息不含令牌，且重试时令牌可能已过期
+	if err := callTaskHandler(handler, &Task{ID: "identity", Type: "test_identity", Sign: `{"UID":7}`}); err != nil {
+		t.Fatal(err)
+	}
+	if uid != 7 {
+		t.Fatalf("expected the handler to see the enqueuing user 7, got %d", uid)
+	}
+}
+
+func TestRedisTaskBrokerClusterKeys(t *testing.T) {
+	defer setTestConfig("name", `"test"`)()
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:0"}})
+	defer client.Close()
+
+	tasksKey, dataKey := (&RedisTaskBroker{client: client}).keys()
+	// 相同的hash tag保证两个键位于同一槽位
+	if tasksKey != "{test_queue}_tasks" || dataKey != "{test_queue}_data" {
+		t.Errorf("unexpected cluster keys: %s, %s", tasksKey, dataKey)
+	}
+}
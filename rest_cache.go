@@ -0,0 +1,184 @@
+package kuu
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restCacheModels 开启查询缓存的模型，key为模型名，value为缓存时长
+var restCacheModels sync.Map
+
+// restCacheKeyDesc 参与缓存key计算的查询参数
+type restCacheKeyDesc struct {
+	Cond    string
+	Project []string
+	Sort    []string
+	Range   string
+	Trash   bool
+	Page    int
+	Size    int
+	Scope   string
+	Extra   string
+}
+
+// parseCacheTTL 支持时长字符串（如5m）或秒数，为空时使用restCache:ttl（单位：秒，默认60）
+func parseCacheTTL(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" || strings.ToUpper(v) == "CACHE" {
+		return time.Duration(C().DefaultGetInt("restCache:ttl", 60)) * time.Second
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	return 0
+}
+
+// registerRestCache 记录模型的查询缓存时长，rest标签优先于kuu标签
+func registerRestCache(name string, tagSettings map[string]string, meta *Metadata) {
+	var ttl time.Duration
+	if v, exists := tagSettings["CACHE"]; exists {
+		ttl = parseCacheTTL(v)
+	} else if meta != nil {
+		ttl = meta.CacheTTL
+	}
+	if ttl > 0 {
+		restCacheModels.Store(name, ttl)
+	}
+}
+
+// restCacheTTL 配置restCache:models:<模型名>（单位：秒）时优先使用配置值
+func restCacheTTL(name string) time.Duration {
+	if n := C().DefaultGetInt("restCache:models."+name, 0); n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if v, ok := restCacheModels.Load(name); ok {
+		return v.(time.Duration)
+	}
+	return 0
+}
+
+func restCacheVersionKey(table string) string {
+	return BuildKey("restcache", table, "version")
+}
+
+// InvalidateRestCache 使指定表的查询缓存失效，绕过GORM回调修改数据时需手动调用
+func InvalidateRestCache(table string) {
+	IncrCache(restCacheVersionKey(table))
+}
+
+func invalidateRestCacheByMeta(meta *Metadata, table string) {
+	if meta == nil || restCacheTTL(meta.Name) <= 0 {
+		return
+	}
+	InvalidateRestCache(table)
+}
+
+func restCacheKey(table string, desc *restCacheKeyDesc) string {
+	version := GetCacheInt(restCacheVersionKey(table))
+	return BuildKey("restcache", table, strconv.Itoa(version), restCacheHash(desc))
+}
+
+func restCacheHash(desc *restCacheKeyDesc) string {
+	sum := sha1.Sum([]byte(JSONStringify(desc)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRestCacheKeyDesc 规范化查询参数：cond按键排序，project去重排序，sort保留顺序，模型实现BizRestCacheKeyInterface时附加其返回值
+func newRestCacheKeyDesc(c *Context, cond map[string]interface{}, modelValue interface{}) *restCacheKeyDesc {
+	desc := &restCacheKeyDesc{
+		Project: sortedFieldNames(c.Query("project")),
+		Range:   strings.ToUpper(c.DefaultQuery("range", "PAGE")),
+		Trash:   c.Query("trash") == "true",
+		Scope:   dataScopeFingerprint(c.PrisDesc),
+	}
+	if len(cond) > 0 {
+		desc.Cond = JSONStringify(cond)
+	}
+	if v, ok := modelValue.(BizRestCacheKeyInterface); ok {
+		desc.Extra = v.BizRestCacheKey(c)
+	}
+	for _, name := range strings.Split(c.Query("sort"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			desc.Sort = append(desc.Sort, name)
+		}
+	}
+	if desc.Range == "PAGE" {
+		desc.Page, desc.Size = c.GetPagination()
+	}
+	return desc
+}
+
+func sortedFieldNames(raw string) (names []string) {
+	exists := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || exists[name] {
+			continue
+		}
+		exists[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// dataScopeFingerprint 数据权限指纹，权限范围不同的用户不会共用缓存
+func dataScopeFingerprint(desc *PrivilegesDesc) string {
+	if !desc.IsValid() {
+		return "anonymous"
+	}
+	var ignoreAuth bool
+	if caches := GetRoutineCaches(); caches != nil {
+		_, ignoreAuth = caches[GLSIgnoreAuthKey]
+	}
+	items := []string{
+		strconv.FormatUint(uint64(desc.UID), 10),
+		strconv.FormatUint(uint64(desc.ActOrgID), 10),
+		strconv.FormatUint(uint64(desc.TenantID), 10),
+		desc.SignInfo.Type,
+		strconv.FormatUint(uint64(desc.SignInfo.SubDocID), 10),
+		strconv.FormatBool(ignoreAuth),
+		joinSortedUints(desc.ReadableOrgIDs),
+		joinSortedUints(desc.PersonalReadableOrgIDs),
+		joinSortedUints(desc.PersonalWritableOrgIDs),
+		strings.Join(sortedFieldNames(strings.Join(desc.Permissions, ",")), ","),
+	}
+	sum := sha1.Sum([]byte(strings.Join(items, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func joinSortedUints(values []uint) string {
+	list := make([]int, len(values))
+	for i, v := range values {
+		list[i] = int(v)
+	}
+	sort.Ints(list)
+	items := make([]string, len(list))
+	for i, v := range list {
+		items[i] = strconv.Itoa(v)
+	}
+	return strings.Join(items, ",")
+}
+
+// getRestCache 命中时返回还原后的查询结果
+func getRestCache(key string, reflectType reflect.Type) (*BizQueryResult, bool) {
+	val := GetCacheString(key)
+	if val == "" {
+		return nil, false
+	}
+	ret := &BizQueryResult{List: reflect.New(reflect.SliceOf(reflectType)).Interface()}
+	if err := JSONParse(val, ret); err != nil {
+		WARN("解析查询缓存失败：%s", err.Error())
+		return nil, false
+	}
+	return ret, true
+}
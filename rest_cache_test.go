@@ -0,0 +1,137 @@
+package kuu
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseCacheTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":      60 * time.Second,
+		"cache": 60 * time.Second,
+		"30":    30 * time.Second,
+		"5m":    5 * time.Minute,
+		"abc":   0,
+	}
+	for v, want := range cases {
+		if got := parseCacheTTL(v); got != want {
+			t.Errorf("parseCacheTTL(%q) = %s, want %s", v, got, want)
+		}
+	}
+}
+
+func TestRestCacheKeyDesc(t *testing.T) {
+	newContext := func(query string) *Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/user?"+query, nil)
+		return &Context{Context: c}
+	}
+	hash := func(query string) string {
+		return restCacheHash(newRestCacheKeyDesc(newContext(query), nil, nil))
+	}
+	if hash("project=Name,ID&preload=Org&page=1&size=20") != hash("project=ID,Name,ID&preload=Org&size=20") {
+		t.Error("project order and default page should not change the key")
+	}
+	if hash("sort=Name,-ID") == hash("sort=-ID,Name") {
+		t.Error("sort order should change the key")
+	}
+	if hash("range=all&page=2") != hash("range=ALL") {
+		t.Error("page should be ignored when range is ALL")
+	}
+	c := newContext("")
+	c.Request.Header.Set("X-Region", "east")
+	east := restCacheHash(newRestCacheKeyDesc(c, nil, &restCacheTestModel{}))
+	c.Request.Header.Set("X-Region", "west")
+	if restCacheHash(newRestCacheKeyDesc(c, nil, &restCacheTestModel{})) == east {
+		t.Error("BizRestCacheKey should change the key")
+	}
+}
+
+type restCacheTestModel struct{}
+
+func (m *restCacheTestModel) BizRestCacheKey(c *Context) string {
+	return c.GetHeader("X-Region")
+}
+
+func TestDataScopeFingerprint(t *testing.T) {
+	newDesc := func(orgIDs ...uint) *PrivilegesDesc {
+		return &PrivilegesDesc{
+			UID:            2,
+			Valid:          true,
+			ReadableOrgIDs: orgIDs,
+			SignInfo: &SignContext{
+				Token:   "token",
+				UID:     2,
+				Payload: jwt.MapClaims{},
+				Secret:  &SignSecret{},
+			},
+		}
+	}
+	if dataScopeFingerprint(nil) != "anonymous" {
+		t.Error("invalid desc should be anonymous")
+	}
+	if dataScopeFingerprint(newDesc(1, 2)) != dataScopeFingerprint(newDesc(2, 1)) {
+		t.Error("org order should not change the fingerprint")
+	}
+	if dataScopeFingerprint(newDesc(1, 2)) == dataScopeFingerprint(newDesc(1)) {
+		t.Error("different readable orgs should change the fingerprint")
+	}
+}
+
+type restCacheCommitDoc struct {
+	ID   uint
+	Name string
+}
+
+func TestModelChangeAfterCommit(t *testing.T) {
+	defer setTestConfig("name", `"test"`)()
+	defer setTestConfig("restCache:models", `{"restCacheCommitDoc": 60}`)()
+	old := DefaultCache
+	DefaultCache = NewCacheMemory()
+	defer func() { DefaultCache = old }()
+
+	db := newTestDB(t, nil, nil)
+	version := func() int {
+		return GetCacheInt(restCacheVersionKey(db.NewScope(&restCacheCommitDoc{}).TableName()))
+	}
+
+	// WithTransaction开启的事务：提交后再次失效，回滚时不处理
+	for _, committed := range []bool{true, false} {
+		tx := db.Begin()
+		trackTransaction(tx.CommonDB())
+		before := version()
+		modelChangeCallback(tx.NewScope(&restCacheCommitDoc{ID: 1}))
+		if version() != before+1 {
+			t.Fatalf("expected version to be bumped before commit")
+		}
+		finishTransaction(tx.CommonDB(), committed)
+		want := before + 1
+		if committed {
+			want++
+		}
+		if version() != want {
+			t.Errorf("committed=%v: expected version %d, got %d", committed, want, version())
+		}
+	}
+
+	// gorm自动开启的事务：提交后由kuu:model_change_commit处理
+	before := version()
+	scope := db.NewScope(&restCacheCommitDoc{ID: 1})
+	scope.InstanceSet("gorm:started_transaction", true)
+	modelChangeCallback(scope)
+	modelChangeCommitCallback(scope)
+	if version() != before+2 {
+		t.Errorf("expected version to be bumped before and after commit, got %d", version()-before)
+	}
+
+	// 未登记的连接只在变更时失效一次
+	before = version()
+	modelChangeCallback(db.NewScope(&restCacheCommitDoc{ID: 1}))
+	if version() != before+1 {
+		t.Errorf("expected version to be bumped once, got %d", version()-before)
+	}
+}
@@ -0,0 +1,359 @@
+package kuu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/jinzhu/gorm"
+)
+
+// SeedRefPrefix 引用其他种子数据主键的前缀，格式为$ref:<模型名>:<自然键值>，组合键值以逗号分隔
+const SeedRefPrefix = "$ref:"
+
+// SeedKeys 各模型默认的自然键，种子数据集未指定key时使用
+var SeedKeys = map[string][]string{
+	"User":            {"Username"},
+	"Org":             {"Code"},
+	"Role":            {"Code"},
+	"Menu":            {"Code"},
+	"Param":           {"Code"},
+	"LanguageMessage": {"LangCode", "Key"},
+}
+
+// SeedFile 种子文件，支持YAML和JSON格式
+type SeedFile struct {
+	Env  []string
+	Sets []SeedSet
+}
+
+// SeedSet 同一模型的一组种子数据
+type SeedSet struct {
+	Model     string
+	Key       []string
+	Env       []string
+	Overwrite bool
+	Records   []map[string]interface{}
+}
+
+// SeedResult
+type SeedResult struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+type seedContext struct {
+	tx     *gorm.DB
+	env    string
+	ids    map[string]map[string]interface{}
+	keys   map[string][]string
+	result SeedResult
+}
+
+func newSeedContext(env string) *seedContext {
+	return &seedContext{
+		env:  env,
+		ids:  make(map[string]map[string]interface{}),
+		keys: make(map[string][]string),
+	}
+}
+
+// SeedEnv 当前种子数据环境，依次取seeds:env、env配置，默认为dev（生产模式为prod）
+func SeedEnv() string {
+	if env := C().GetString("seeds:env"); env != "" {
+		return env
+	}
+	if env := C().GetString("env"); env != "" {
+		return env
+	}
+	if IsProduction {
+		return "prod"
+	}
+	return "dev"
+}
+
+func matchSeedEnv(envs []string, env string) bool {
+	if len(envs) == 0 {
+		return true
+	}
+	for _, item := range envs {
+		if strings.EqualFold(item, env) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSeedFile 解析种子文件内容
+func ParseSeedFile(data []byte) (file SeedFile, err error) {
+	if data, err = yaml.YAMLToJSON(data); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &file)
+	return
+}
+
+// seedFilePaths 展开通配符并按文件名排序
+func seedFilePaths(patterns []string) (paths []string, err error) {
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("seed file not found: %s", pattern)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+	return
+}
+
+// ApplySeedFiles 按顺序加载种子文件，已存在的数据（按自然键判断）默认跳过，overwrite时更新
+func ApplySeedFiles(env string, patterns ...string) (result SeedResult, err error) {
+	paths, err := seedFilePaths(patterns)
+	if err != nil {
+		return
+	}
+	ctx := newSeedContext(env)
+	// 先解析全部文件，$ref可引用后续文件中使用自定义key的数据
+	files := make([]SeedFile, 0, len(paths))
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return ctx.result, err
+		}
+		file, err := ParseSeedFile(data)
+		if err != nil {
+			return ctx.result, fmt.Errorf("parse seed file %s failed: %s", p, err.Error())
+		}
+		if matchSeedEnv(file.Env, env) {
+			ctx.registerKeys(file.Sets)
+		}
+		files = append(files, file)
+	}
+	for i, file := range files {
+		if !matchSeedEnv(file.Env, env) {
+			continue
+		}
+		err = WithTransaction(func(tx *gorm.DB) error {
+			ctx.tx = tx
+			for _, set := range file.Sets {
+				if err := ctx.applySet(set); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return ctx.result, fmt.Errorf("apply seed file %s failed: %s", paths[i], err.Error())
+		}
+	}
+	return ctx.result, nil
+}
+
+// Seed 加载指定模块（默认全部）声明的种子文件
+func Seed(modCodes ...string) error {
+	mods, err := sortedMods(modCodes)
+	if err != nil {
+		return err
+	}
+	for _, mod := range mods {
+		if err := seedMod(mod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seedMod(mod *Mod) error {
+	if len(mod.Seeds) == 0 {
+		return nil
+	}
+	env := SeedEnv()
+	result, err := ApplySeedFiles(env, mod.Seeds...)
+	if err != nil {
+		return err
+	}
+	INFO("Seeds of %s applied (env=%s): %d created, %d updated, %d skipped", mod.Code, env, result.Created, result.Updated, result.Skipped)
+	return nil
+}
+
+func seedKeys(set SeedSet) []string {
+	if len(set.Key) > 0 {
+		return set.Key
+	}
+	return SeedKeys[set.Model]
+}
+
+// registerKeys 记录数据集自定义的自然键，用于解析对该模型的引用
+func (ctx *seedContext) registerKeys(sets []SeedSet) {
+	for _, set := range sets {
+		if len(set.Key) > 0 && matchSeedEnv(set.Env, ctx.env) {
+			ctx.keys[set.Model] = set.Key
+		}
+	}
+}
+
+// refKeys 与applySet使用相同的自然键
+func (ctx *seedContext) refKeys(modelName string) []string {
+	if keys, ok := ctx.keys[modelName]; ok {
+		return keys
+	}
+	return SeedKeys[modelName]
+}
+
+func seedKeyValue(values []interface{}) string {
+	var list []string
+	for _, v := range values {
+		list = append(list, fmt.Sprintf("%v", v))
+	}
+	return strings.Join(list, ",")
+}
+
+func (ctx *seedContext) applySet(set SeedSet) error {
+	if !matchSeedEnv(set.Env, ctx.env) {
+		return nil
+	}
+	meta := Meta(set.Model)
+	if meta == nil {
+		return fmt.Errorf("model not found: %s", set.Model)
+	}
+	keys := seedKeys(set)
+	if len(keys) == 0 {
+		return fmt.Errorf("natural key of model %s is required", set.Model)
+	}
+	for _, record := range set.Records {
+		resolved, err := ctx.resolveRefs(record)
+		if err != nil {
+			return err
+		}
+		record = resolved.(map[string]interface{})
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		value := meta.NewValue()
+		if err := json.Unmarshal(data, value); err != nil {
+			return err
+		}
+		scope := ctx.tx.NewScope(value)
+		var (
+			where     = make(map[string]interface{})
+			keyValues []interface{}
+		)
+		for _, key := range keys {
+			field, ok := scope.FieldByName(key)
+			if !ok {
+				return fmt.Errorf("field %s not found in model %s", key, set.Model)
+			}
+			where[field.DBName] = field.Field.Interface()
+			keyValues = append(keyValues, field.Field.Interface())
+		}
+		existing := meta.NewValue()
+		err = ctx.tx.Where(where).First(existing).Error
+		switch {
+		case gorm.IsRecordNotFoundError(err):
+			if err := ctx.tx.Create(value).Error; err != nil {
+				return err
+			}
+			ctx.result.Created++
+		case err != nil:
+			return err
+		case set.Overwrite:
+			updates := make(map[string]interface{})
+			for name := range record {
+				if field, ok := scope.FieldByName(name); ok && !field.IsPrimaryKey {
+					updates[field.DBName] = field.Field.Interface()
+				}
+			}
+			if err := ctx.tx.Model(existing).Updates(updates).Error; err != nil {
+				return err
+			}
+			ctx.result.Updated++
+			value = existing
+		default:
+			ctx.result.Skipped++
+			value = existing
+		}
+		if _, ok := ctx.ids[set.Model]; !ok {
+			ctx.ids[set.Model] = make(map[string]interface{})
+		}
+		ctx.ids[set.Model][seedKeyValue(keyValues)] = ctx.tx.NewScope(value).PrimaryKeyValue()
+	}
+	return nil
+}
+
+// resolveRefs 递归替换记录中的$ref引用为对应数据的主键
+func (ctx *seedContext) resolveRefs(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := ctx.resolveRefs(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = resolved
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := ctx.resolveRefs(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = resolved
+		}
+		return result, nil
+	case string:
+		if strings.HasPrefix(v, SeedRefPrefix) {
+			return ctx.lookupRef(strings.TrimPrefix(v, SeedRefPrefix))
+		}
+	}
+	return value, nil
+}
+
+func (ctx *seedContext) lookupRef(ref string) (interface{}, error) {
+	split := strings.SplitN(ref, ":", 2)
+	if len(split) != 2 {
+		return nil, fmt.Errorf("invalid seed reference: %s", ref)
+	}
+	modelName, keyValue := split[0], split[1]
+	if id, ok := ctx.ids[modelName][keyValue]; ok {
+		return id, nil
+	}
+	meta := Meta(modelName)
+	if meta == nil {
+		return nil, fmt.Errorf("model not found: %s", modelName)
+	}
+	keys := ctx.refKeys(modelName)
+	values := strings.Split(keyValue, ",")
+	if len(keys) == 0 || len(keys) != len(values) {
+		return nil, fmt.Errorf("invalid seed reference: %s", ref)
+	}
+	value := meta.NewValue()
+	scope := ctx.tx.NewScope(value)
+	where := make(map[string]interface{})
+	for i, key := range keys {
+		field, ok := scope.FieldByName(key)
+		if !ok {
+			return nil, fmt.Errorf("field %s not found in model %s", key, modelName)
+		}
+		where[field.DBName] = values[i]
+	}
+	if err := ctx.tx.Where(where).First(value).Error; err != nil {
+		return nil, fmt.Errorf("seed reference %s not found: %s", ref, err.Error())
+	}
+	id := ctx.tx.NewScope(value).PrimaryKeyValue()
+	if reflect.ValueOf(id).IsZero() {
+		return nil, fmt.Errorf("seed reference %s not found", ref)
+	}
+	return id, nil
+}
@@ -0,0 +1,59 @@
+package kuu
+
+import (
+	"testing"
+)
+
+func TestParseSeedFile(t *testing.T) {
+	file, err := ParseSeedFile([]byte(`
+env: [dev, test]
+sets:
+  - model: Menu
+    overwrite: true
+    records:
+      - Code: sys
+        Name: System
+      - Code: sys_user
+        Name: Users
+        Pid: $ref:Menu:sys
+`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !matchSeedEnv(file.Env, "DEV") || matchSeedEnv(file.Env, "prod") {
+		t.Errorf("unexpected env matching: %v", file.Env)
+	}
+	if len(file.Sets) != 1 || !file.Sets[0].Overwrite || len(file.Sets[0].Records) != 2 {
+		t.Errorf("unexpected sets: %v", file.Sets)
+		return
+	}
+
+	ctx := &seedContext{ids: map[string]map[string]interface{}{"Menu": {"sys": uint(1)}}}
+	record, err := ctx.resolveRefs(file.Sets[0].Records[1])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pid := record.(map[string]interface{})["Pid"]; pid != uint(1) {
+		t.Errorf("unexpected ref value: %v", pid)
+	}
+}
+
+func TestSeedRefKeys(t *testing.T) {
+	ctx := newSeedContext("dev")
+	ctx.registerKeys([]SeedSet{
+		{Model: "Menu"},
+		{Model: "Param", Key: []string{"Code", "TenantID"}},
+		{Model: "Role", Key: []string{"Name"}, Env: []string{"prod"}},
+	})
+	if keys := ctx.refKeys("Param"); len(keys) != 2 || keys[1] != "TenantID" {
+		t.Errorf("expected the custom key of Param, got %v", keys)
+	}
+	if keys := ctx.refKeys("Menu"); len(keys) != 1 || keys[0] != "Code" {
+		t.Errorf("expected the default key of Menu, got %v", keys)
+	}
+	if keys := ctx.refKeys("Role"); len(keys) != 1 || keys[0] != "Code" {
+		t.Errorf("expected sets of other envs to be ignored, got %v", keys)
+	}
+}
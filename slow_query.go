@@ -0,0 +1,312 @@
+package kuu
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LogTypeSlowSQL 慢查询日志
+const LogTypeSlowSQL = "slowsql"
+
+const (
+	dataSourceNameKey      = "kuu:data_source"
+	slowQueryStartInstance = "kuu:slow_query_start"
+)
+
+var (
+	slowQueryThresholds sync.Map
+	slowQueryStats      = make(map[string]*SlowQueryStat)
+	slowQueryStatsMu    sync.Mutex
+
+	sqlFingerprintComment = regexp.MustCompile(`(?s)/\*.*?\*/|--[^\n]*`)
+	sqlFingerprintString  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlFingerprintNumber  = regexp.MustCompile(`\b\d+(?:\.\d+)?\b|\$\d+`)
+	sqlFingerprintList    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	sqlFingerprintSpace   = regexp.MustCompile(`\s+`)
+)
+
+// SlowQueryStat 按指纹聚合的慢查询统计
+type SlowQueryStat struct {
+	Fingerprint string
+	SQL         string
+	Model       string
+	DataSource  string
+	Count       int64
+	TotalTime   time.Duration
+	MaxTime     time.Duration
+	LastAt      time.Time
+	LastRoute   string
+	LastExplain string
+}
+
+// SlowQuery 慢查询日志的ContentData
+type SlowQuery struct {
+	Fingerprint string
+	DataSource  string
+	Model       string
+	Operation   string
+	Route       string
+	SQL         string
+	Vars        string
+	Duration    time.Duration
+	Explain     string `json:",omitempty"`
+}
+
+// SQLFingerprint 去除注释、常量及IN列表长度差异后的SQL，用于聚合同类查询
+func SQLFingerprint(sql string) string {
+	s := sqlFingerprintComment.ReplaceAllString(sql, " ")
+	s = sqlFingerprintString.ReplaceAllString(s, "?")
+	s = sqlFingerprintNumber.ReplaceAllString(s, "?")
+	s = sqlFingerprintList.ReplaceAllString(s, "(?+)")
+	s = sqlFingerprintSpace.ReplaceAllString(s, " ")
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+func sqlFingerprintID(fingerprint string) string {
+	sum := sha1.Sum([]byte(fingerprint))
+	return hex.EncodeToString(sum[:8])
+}
+
+// slowQueryThreshold 优先使用数据源的SlowThreshold，其次为slowQuery:threshold（单位：毫秒，默认1000），小于等于0表示不检测
+func slowQueryThreshold(dsName string) time.Duration {
+	if v, ok := slowQueryThresholds.Load(dsName); ok {
+		return v.(time.Duration)
+	}
+	return time.Duration(C().DefaultGetInt("slowQuery:threshold", 1000)) * time.Millisecond
+}
+
+func registerSlowQueryCallbacks(callback *gorm.Callback) {
+	if callback.Query().Get("kuu:slow_query_begin") == nil {
+		callback.Query().Before("gorm:query").Register("kuu:slow_query_begin", slowQueryBeginCallback)
+		callback.Query().After("gorm:query").Register("kuu:slow_query_end", slowQueryEndCallback("query"))
+	}
+	if callback.RowQuery().Get("kuu:slow_query_begin") == nil {
+		callback.RowQuery().Before("gorm:row_query").Register("kuu:slow_query_begin", slowQueryBeginCallback)
+		callback.RowQuery().After("gorm:row_query").Register("kuu:slow_query_end", slowQueryEndCallback("row_query"))
+	}
+	if callback.Create().Get("kuu:slow_query_begin") == nil {
+		callback.Create().Before("gorm:create").Register("kuu:slow_query_begin", slowQueryBeginCallback)
+		callback.Create().After("gorm:create").Register("kuu:slow_query_end", slowQueryEndCallback("create"))
+	}
+	if callback.Update().Get("kuu:slow_query_begin") == nil {
+		callback.Update().Before("gorm:update").Register("kuu:slow_query_begin", slowQueryBeginCallback)
+		callback.Update().After("gorm:update").Register("kuu:slow_query_end", slowQueryEndCallback("update"))
+	}
+	if callback.Delete().Get("kuu:slow_query_begin") == nil {
+		callback.Delete().Before("gorm:delete").Register("kuu:slow_query_begin", slowQueryBeginCallback)
+		callback.Delete().After("gorm:delete").Register("kuu:slow_query_end", slowQueryEndCallback("delete"))
+	}
+}
+
+func slowQueryBeginCallback(scope *gorm.Scope) {
+	scope.InstanceSet(slowQueryStartInstance, time.Now())
+}
+
+func slowQueryEndCallback(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.InstanceGet(slowQueryStartInstance)
+		if !ok || scope.SQL == "" {
+			return
+		}
+		start, _ := v.(time.Time)
+		duration := time.Since(start)
+		var dsName string
+		if v, ok := scope.Get(dataSourceNameKey); ok {
+			dsName, _ = v.(string)
+		}
+		threshold := slowQueryThreshold(dsName)
+		if threshold <= 0 || duration < threshold {
+			return
+		}
+		query := &SlowQuery{
+			DataSource: dsName,
+			Model:      metricsModelName(scope),
+			Operation:  operation,
+			SQL:        scope.SQL,
+			Vars:       JSONStringify(scope.SQLVars),
+			Duration:   duration,
+		}
+		if c := GetRoutineRequestContext(); c != nil {
+			query.Route = c.GetString(RouteNameKey)
+		}
+		if operation == "query" && C().DefaultGetBool("slowQuery:explain", false) {
+			explain, err := explainQuery(scope.SQLDB(), scope.Dialect().GetName(), scope.SQL, scope.SQLVars)
+			if err != nil {
+				query.Explain = fmt.Sprintf("explain failed: %s", err.Error())
+			} else {
+				query.Explain = explain
+			}
+		}
+		recordSlowQuery(query)
+	}
+}
+
+// explainQuery 仅支持postgres、mysql和sqlite，使用底层连接执行以免再次触发callback
+func explainQuery(db gorm.SQLCommon, dialect, query string, vars []interface{}) (string, error) {
+	var prefix string
+	switch dialect {
+	case "postgres", "mysql":
+		prefix = "EXPLAIN "
+	case "sqlite3":
+		prefix = "EXPLAIN QUERY PLAN "
+	default:
+		return "", fmt.Errorf("unsupported dialect: %s", dialect)
+	}
+	rows, err := db.Query(prefix+query, vars...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	return formatExplainRows(rows)
+}
+
+func formatExplainRows(rows *sql.Rows) (string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	lines := []string{strings.Join(columns, " | ")}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		items := make([]string, len(values))
+		for i, v := range values {
+			items[i] = v.String
+		}
+		lines = append(lines, strings.Join(items, " | "))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// recordSlowQuery 写入慢查询日志并按指纹聚合
+func recordSlowQuery(query *SlowQuery) {
+	fingerprint := SQLFingerprint(query.SQL)
+	query.Fingerprint = sqlFingerprintID(fingerprint)
+	WARN("Slow query (%s) on %s: %s", query.Duration, query.Model, query.SQL)
+
+	log := NewLog(LogTypeSlowSQL)
+	log.Level = "warn"
+	log.AuditModel = query.Model
+	log.AuditSQL = query.SQL
+	log.AuditSQLVars = query.Vars
+	log.RequestCost = query.Duration
+	log.ContentHuman = fmt.Sprintf("%s %s took %s", query.Operation, query.Model, query.Duration)
+	log.ContentData = JSONStringify(query)
+	log.Save()
+
+	addSlowQueryStat(query, fingerprint)
+}
+
+func addSlowQueryStat(query *SlowQuery, fingerprint string) {
+	slowQueryStatsMu.Lock()
+	defer slowQueryStatsMu.Unlock()
+	stat, ok := slowQueryStats[query.Fingerprint]
+	if !ok {
+		if limit := C().DefaultGetInt("slowQuery:maxFingerprints", 1000); len(slowQueryStats) >= limit {
+			evictSlowQueryStat()
+		}
+		stat = &SlowQueryStat{
+			Fingerprint: query.Fingerprint,
+			SQL:         fingerprint,
+			Model:       query.Model,
+			DataSource:  query.DataSource,
+		}
+		slowQueryStats[query.Fingerprint] = stat
+	}
+	stat.Count++
+	stat.TotalTime += query.Duration
+	if query.Duration > stat.MaxTime {
+		stat.MaxTime = query.Duration
+	}
+	stat.LastAt = time.Now()
+	stat.LastRoute = query.Route
+	if query.Explain != "" {
+		stat.LastExplain = query.Explain
+	}
+}
+
+// evictSlowQueryStat 淘汰最久未出现的指纹
+func evictSlowQueryStat() {
+	var (
+		oldest string
+		at     time.Time
+	)
+	for key, stat := range slowQueryStats {
+		if oldest == "" || stat.LastAt.Before(at) {
+			oldest, at = key, stat.LastAt
+		}
+	}
+	delete(slowQueryStats, oldest)
+}
+
+// TopSlowQueries 按sortBy（total、count、max，默认total）排序返回前n个指纹
+func TopSlowQueries(n int, sortBy string) []SlowQueryStat {
+	slowQueryStatsMu.Lock()
+	list := make([]SlowQueryStat, 0, len(slowQueryStats))
+	for _, stat := range slowQueryStats {
+		list = append(list, *stat)
+	}
+	slowQueryStatsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		switch sortBy {
+		case "count":
+			return list[i].Count > list[j].Count
+		case "max":
+			return list[i].MaxTime > list[j].MaxTime
+		default:
+			return list[i].TotalTime > list[j].TotalTime
+		}
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// ResetSlowQueries
+func ResetSlowQueries() {
+	slowQueryStatsMu.Lock()
+	defer slowQueryStatsMu.Unlock()
+	slowQueryStats = make(map[string]*SlowQueryStat)
+}
+
+// SlowQueryTopRoute
+var SlowQueryTopRoute = RouteInfo{
+	Name:   "查询慢查询排行",
+	Method: "GET",
+	Path:   "/slowqueries/top",
+	HandlerFunc: func(c *Context) {
+		n, err := strconv.Atoi(c.DefaultQuery("top", "20"))
+		if err != nil {
+			n = 20
+		}
+		c.STD(TopSlowQueries(n, c.Query("sort")))
+	},
+}
+
+// SlowQueryResetRoute
+var SlowQueryResetRoute = RouteInfo{
+	Name:   "重置慢查询统计",
+	Method: "POST",
+	Path:   "/slowqueries/reset",
+	HandlerFunc: func(c *Context) {
+		ResetSlowQueries()
+		c.STD("ok")
+	},
+}
@@ -0,0 +1,44 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSQLFingerprint(t *testing.T) {
+	a := SQLFingerprint(`SELECT * FROM "sys_User" WHERE ("id" IN ($1,$2,$3)) AND name = 'it''s' LIMIT 10 /*request_id='abc'*/`)
+	b := SQLFingerprint("select *  from \"sys_User\"\n where (\"id\" in (?)) and name = 'bob' limit 20")
+	if a != b {
+		t.Errorf("fingerprints differ:\n%s\n%s", a, b)
+	}
+	if want := `select * from "sys_user" where ("id" in (?+)) and name = ? limit ?`; a != want {
+		t.Errorf("got %s, want %s", a, want)
+	}
+	if c := SQLFingerprint(`SELECT * FROM t1 WHERE v2 = 3.5`); c != "select * from t1 where v2 = ?" {
+		t.Errorf("identifiers with digits should be kept: %s", c)
+	}
+}
+
+func TestTopSlowQueries(t *testing.T) {
+	ResetSlowQueries()
+	defer ResetSlowQueries()
+	add := func(sql string, d time.Duration) {
+		fingerprint := SQLFingerprint(sql)
+		addSlowQueryStat(&SlowQuery{Fingerprint: sqlFingerprintID(fingerprint), SQL: sql, Duration: d}, fingerprint)
+	}
+	add("SELECT * FROM a WHERE id = 1", time.Second)
+	add("SELECT * FROM a WHERE id = 2", time.Second)
+	add("SELECT * FROM a WHERE id = 3", time.Second)
+	add("SELECT * FROM b WHERE id = 1", 5*time.Second)
+
+	top := TopSlowQueries(10, "count")
+	if len(top) != 2 || top[0].Count != 3 || top[0].SQL != "select * from a where id = ?" {
+		t.Fatalf("unexpected top by count: %+v", top)
+	}
+	if top := TopSlowQueries(1, "max"); len(top) != 1 || top[0].MaxTime != 5*time.Second {
+		t.Errorf("unexpected top by max: %+v", top)
+	}
+	if top := TopSlowQueries(0, ""); top[0].TotalTime != 5*time.Second {
+		t.Errorf("unexpected top by total: %+v", top)
+	}
+}
@@ -157,6 +157,9 @@ func (r *STDRender) Render() {
 	if r.message != "" {
 		ret["msg"] = r.message
 	}
+	if id := GetRequestID(r.c); id != "" {
+		ret["request_id"] = id
+	}
 	switch r.action {
 	case JSONAction:
 		r.c.JSON(r.httpCode, ret)
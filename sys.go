@@ -1,6 +1,7 @@
 package kuu
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
@@ -105,10 +106,40 @@ func initSys() {
 			PANIC("failed to initialize preset data: %s", err.Error())
 		}
 	}
+	// 初始化链路追踪
+	initTracing()
+	// 初始化监控指标
+	initMetrics()
+	// 同步定时任务定义及暂停状态
+	initJobStore()
+	// 初始化任务队列并启动消费者
+	initTaskQueue()
 	// 启动日志序列化任务
-	_, _ = AddJob("@every 5m", LogPersisJob)
+	_, _ = RegisterJob(&Job{
+		Code:      "LogPersisJob",
+		Name:      "日志持久化",
+		Spec:      "@every 5m",
+		Singleton: true,
+		Run: func(ctx context.Context) error {
+			return persistLogs()
+		},
+	})
 	// 启动历史日志清除任务
-	_, _ = AddJob("@midnight", LogCleanupJob)
+	_, _ = RegisterJob(&Job{
+		Code:      "LogCleanupJob",
+		Name:      "历史日志清除",
+		Spec:      "@midnight",
+		Singleton: true,
+		Run: func(ctx context.Context) error {
+			return cleanupLogs()
+		},
+	})
+	// 启动定时任务执行记录清除任务
+	_, _ = RegisterJob(&Job{Code: "JobRunCleanupJob", Name: "定时任务执行记录清除", Spec: "@midnight", Singleton: true, Cmd: JobRunCleanupJob})
+	// 启动回收站清理任务
+	_, _ = RegisterJob(&Job{Code: "TrashPurgeJob", Name: "回收站清理", Spec: C().DefaultGetString("trash:purgeSpec", "@midnight"), Singleton: true, Cmd: TrashPurgeJob})
+	// 启动审计记录持久化任务
+	_, _ = RegisterJob(&Job{Code: "AuditPersistJob", Name: "审计记录持久化", Spec: C().DefaultGetString("audit:persistSpec", "@every 1m"), Singleton: true, Cmd: AuditPersistJob})
 }
 
 func createRootUser(tx *gorm.DB) {
@@ -221,11 +252,24 @@ func createPresetLanguageMessages(tx *gorm.DB) {
 	register.SetKey("lang_list_save_failed").Add("Save languages failed", "保存语言列表失败", "保存語言列表失敗")
 	// Model RESTful
 	register.SetKey("rest_update_failed").Add("Update failed", "更新失败", "更新失敗")
+	register.SetKey("rest_update_conflict").Add("The record has been modified by someone else, please refresh and retry", "数据已被其他用户修改，请刷新后重试", "數據已被其他用戶修改，請刷新後重試")
+	register.SetKey("rest_idempotency_in_progress").Add("The same request is being processed, please try again later", "相同的请求正在处理中，请稍后重试", "相同的請求正在處理中，請稍後重試")
+	register.SetKey("rest_restore_failed").Add("Restore failed", "恢复失败", "恢復失敗")
+	register.SetKey("rest_history_failed").Add("Query history failed", "查询变更历史失败", "查詢變更歷史失敗")
+	register.SetKey("audit_export_failed").Add("Export audit trails failed", "导出审计记录失败", "導出審計記錄失敗")
+	register.SetKey("job_pause_failed").Add("Pause job failed", "暂停定时任务失败", "暫停定時任務失敗")
+	register.SetKey("job_resume_failed").Add("Resume job failed", "恢复定时任务失败", "恢復定時任務失敗")
+	register.SetKey("job_run_failed").Add("Run job failed", "执行定时任务失败", "執行定時任務失敗")
+	register.SetKey("job_runs_failed").Add("Query job runs failed", "查询定时任务执行记录失败", "查詢定時任務執行記錄失敗")
+	register.SetKey("task_dead_list_failed").Add("Query dead tasks failed", "查询死信任务失败", "查詢死信任務失敗")
+	register.SetKey("task_dead_retry_failed").Add("Retry dead task failed", "重试死信任务失败", "重試死信任務失敗")
+	register.SetKey("audit_verify_failed").Add("Verify audit trails failed", "校验审计记录失败", "校驗審計記錄失敗")
 	register.SetKey("rest_query_failed").Add("Query failed", "查询失败", "查詢失敗")
 	register.SetKey("rest_delete_failed").Add("Delete failed", "删除失败", "刪除失敗")
 	register.SetKey("rest_create_failed").Add("Create failed", "新增失败", "新增失敗")
 	register.SetKey("rest_import_failed").Add("Import failed", "导入失败", "導入失敗")
 	register.SetKey("rest_export_failed").Add("Export failed", "导出失败", "導出失敗")
+	register.SetKey("sys_not_ready").Add("Service is not ready", "服务未就绪", "服務未就緒")
 	// 菜单
 	register.SetKey("menu_default").Add("Default", "默认菜单", "默認菜單")
 	register.SetKey("menu_sys_mgr").Add("System Management", "系统管理", "系統管理")
@@ -886,14 +930,23 @@ var SetPayloadAttrs = func(payload jwt.MapClaims, user *User) jwt.MapClaims {
 
 // GetUserFromCache
 func GetUserFromCache(uid uint) (user User) {
+	if uid == 0 {
+		return
+	}
 	cacheKey := fmt.Sprintf("user_%d", uid)
-	if info := GetCacheString(cacheKey); info != "" {
-		_ = JSONParse(info, &user)
-	} else {
-		if err := DB().First(&user, &User{ID: uid}); err != nil {
-			SetCacheString(cacheKey, JSONStringify(user))
+	if v, ok := DefaultL1Cache.Get(cacheKey); ok {
+		return v.(User)
+	}
+	err := GetOrLoad(cacheKey, 0, &user, func(dest interface{}) error {
+		return DB().Where("id = ?", uid).First(dest).Error
+	})
+	if err != nil {
+		if err != ErrCacheNotFound {
+			ERROR("查询用户失败：%s", err.Error())
 		}
+		return User{}
 	}
+	DefaultL1Cache.Set(cacheKey, user)
 	return
 }
 
@@ -902,6 +955,8 @@ func Sys() *Mod {
 	return &Mod{
 		Code: "sys",
 		Middleware: gin.HandlersChain{
+			TraceMiddleware,
+			MetricsMiddleware,
 			LogMiddleware,
 		},
 		Models: []interface{}{
@@ -921,6 +976,11 @@ func Sys() *Mod {
 			&Language{},
 			&LanguageMessage{},
 			&Log{},
+			&RecordHistory{},
+			&AuditTrail{},
+			&Job{},
+			&JobRun{},
+			&Task{},
 		},
 		Routes: RoutesInfo{
 			OrgLoginableRoute,
@@ -944,6 +1004,21 @@ func Sys() *Mod {
 			LangtransImportRoute,
 			LangSwitchRoute,
 			LogOverviewRoute,
+			LogSinksRoute,
+			HealthzRoute,
+			ReadyzRoute,
+			MetricsRoute,
+			SlowQueryTopRoute,
+			SlowQueryResetRoute,
+			AuditExportRoute,
+			AuditVerifyRoute,
+			JobListRoute,
+			JobPauseRoute,
+			JobResumeRoute,
+			JobRunRoute,
+			JobRunsRoute,
+			TaskDeadListRoute,
+			TaskDeadRetryRoute,
 		},
 		AfterImport: initSys,
 	}
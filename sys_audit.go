@@ -23,7 +23,7 @@ func registerAuditCallbacks(callback *gorm.Callback) {
 		callback.Update().After("gorm:commit_or_rollback_transaction").Register("kuu:audit_update", AuditUpdateCallback)
 	}
 	if callback.Delete().Get("kuu:audit_delete") == nil {
-		callback.Update().After("gorm:commit_or_rollback_transaction").Register("kuu:audit_delete", AuditDeleteCallback)
+		callback.Delete().After("gorm:commit_or_rollback_transaction").Register("kuu:audit_delete", AuditDeleteCallback)
 	}
 }
 
@@ -49,7 +49,8 @@ func NewAuditLog(scope *gorm.Scope, auditType string) {
 	if meta := Meta(scope.Value); meta != nil {
 		info.AuditModel = meta.Name
 	}
-	info.Save2Cache()
+	info.Save()
+	NewAuditTrail(scope, auditType)
 }
 
 func auditCreateCallback(scope *gorm.Scope) {
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -51,6 +52,11 @@ type PrivilegesDesc struct {
 	ActOrgCode               string
 	ActOrgName               string
 	RolesCode                []string
+	TenantID                 uint
+	TenantCode               string
+	TenantDS                 string
+	TenantOrgIDs             []uint
+	TenantOrgIDMap           map[uint]Org
 }
 
 // IsWritableOrgID
@@ -360,7 +366,37 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 	} else if sign != nil {
 		uid = sign.UID
 	}
-	// 重新计算
+	// 缓存时长（单位：秒，默认60，小于等于0表示每次重新计算），相关模型变更后版本号递增
+	ttl := time.Duration(C().DefaultGetInt("cache:privilegesTTL", 60)) * time.Second
+	if ttl <= 0 {
+		if desc, _ = computePrivilegesDesc(uid); desc != nil {
+			desc.SignInfo = sign
+		}
+		return
+	}
+	var cached PrivilegesDesc
+	cacheKey := fmt.Sprintf("privileges_%d_%d", cacheVersion("privileges"), uid)
+	err := GetOrLoad(cacheKey, ttl, &cached, func(dest interface{}) error {
+		computed, err := computePrivilegesDesc(uid)
+		if err != nil {
+			return err
+		}
+		*dest.(*PrivilegesDesc) = *computed
+		return nil
+	})
+	if err != nil {
+		if err != ErrCacheNotFound {
+			ERROR("计算用户权限失败：%s", err.Error())
+		}
+		return
+	}
+	desc = &cached
+	desc.SignInfo = sign
+	return
+}
+
+// computePrivilegesDesc 根据角色分配及组织树计算权限，不含SignInfo
+func computePrivilegesDesc(uid uint) (desc *PrivilegesDesc, err error) {
 	user, err := GetUserWithRoles(uid)
 	if err != nil {
 		return
@@ -370,7 +406,6 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 		OrgID:         user.OrgID,
 		PermissionMap: make(map[string]int64),
 		Valid:         true,
-		SignInfo:      sign,
 	}
 	type orange struct {
 		readable string
@@ -432,9 +467,9 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 		}
 	}
 	var orgList []Org
-	if err := DB().Find(&orgList).Error; err != nil {
+	if err = DB().Find(&orgList).Error; err != nil {
 		ERROR("组织列表查询失败")
-		return
+		return nil, err
 	}
 	orgList = FillOrgFullInfo(orgList)
 	orgMap := OrgIDMap(orgList)
@@ -528,6 +563,10 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 	desc.ActOrgID = actOrg.ID
 	desc.ActOrgCode = actOrg.Code
 	desc.ActOrgName = actOrg.Name
+	// 计算所属租户
+	if TenantEnabled() {
+		desc.fillTenant(actOrg, orgMap)
+	}
 	// 限制读取组织为当前组织或当前组织及以下（不能跨组织树分支或上级组织）
 	filteredReadableOrgIDs := map[uint]Org{actOrg.ID: actOrg}
 	for itemID, itemOrg := range desc.FullReadableOrgIDMap {
@@ -31,7 +31,19 @@ var (
 )
 
 func registerCallbacks() {
-	callback := DB().Callback()
+	if TenantEnabled() {
+		// 租户可能路由到独立的数据源，所有数据源均需注册callback
+		dataSourcesMap.Range(func(_, value interface{}) bool {
+			registerDBCallbacks(value.(*gorm.DB))
+			return true
+		})
+	} else {
+		registerDBCallbacks(DB())
+	}
+}
+
+func registerDBCallbacks(db *gorm.DB) {
+	callback := db.Callback()
 	// 注册系统callback
 	if callback.Create().Get("kuu:uuid_create") == nil {
 		callback.Create().Before("gorm:begin_transaction").Register("kuu:uuid_create", uuidCreateCallback)
@@ -82,10 +94,35 @@ func registerCallbacks() {
 	if callback.Delete().Get("kuu:model_change") == nil {
 		callback.Delete().After("gorm:after_delete").Register("kuu:model_change", modelChangeCallback)
 	}
+	if callback.Create().Get("kuu:model_change_commit") == nil {
+		callback.Create().After("gorm:commit_or_rollback_transaction").Register("kuu:model_change_commit", modelChangeCommitCallback)
+	}
+	if callback.Update().Get("kuu:model_change_commit") == nil {
+		callback.Update().After("gorm:commit_or_rollback_transaction").Register("kuu:model_change_commit", modelChangeCommitCallback)
+	}
+	if callback.Delete().Get("kuu:model_change_commit") == nil {
+		callback.Delete().After("gorm:commit_or_rollback_transaction").Register("kuu:model_change_commit", modelChangeCommitCallback)
+	}
+	// 注册乐观锁callback
+	registerOptimisticLockCallbacks(callback)
+	// 注册数据变更历史callback
+	registerHistoryCallbacks(callback)
+	// 注册SQL注释callback
+	registerSQLCommentCallbacks(callback)
+	// 注册链路追踪callback
+	registerTraceCallbacks(callback)
+	// 注册监控指标callback
+	registerMetricsCallbacks(callback)
+	// 注册慢查询检测callback
+	registerSlowQueryCallbacks(callback)
 	// 注册审计callback
 	if C().DefaultGetBool("audit:callbacks", true) {
 		registerAuditCallbacks(callback)
 	}
+	// 注册租户隔离callback
+	if TenantEnabled() {
+		registerTenantCallbacks(callback)
+	}
 }
 
 func uuidCreateCallback(scope *gorm.Scope) {
@@ -251,15 +288,43 @@ func deleteCallback(scope *gorm.Scope) {
 	}
 }
 
+// modelChangeCallback 数据变更时使查询缓存及依赖该模型的缓存版本失效，提交前失效一次，事务提交后再失效一次，避免并发查询在提交前将旧数据以新版本写入缓存
 func modelChangeCallback(scope *gorm.Scope) {
 	if !scope.HasError() && scope.Value != nil {
 		meta := Meta(scope.Value)
 		if meta != nil {
-			NotifyModelChange(meta.Name)
+			table := scope.TableName()
+			invalidateRestCacheByMeta(meta, table)
+			bumpCacheVersions(meta.Name)
+			// gorm自动开启的事务在kuu:model_change_commit中提交后处理，WithTransaction的事务在提交后处理
+			if _, ok := scope.InstanceGet("gorm:started_transaction"); ok {
+				scope.InstanceSet("kuu:model_change_table", table)
+			} else if !afterCommit(scope.SQLDB(), func() { modelCommitted(meta, table) }) {
+				NotifyModelChange(meta.Name)
+			}
+		}
+	}
+}
+
+// modelChangeCommitCallback gorm自动开启的事务提交后处理数据变更
+func modelChangeCommitCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+	if table, ok := scope.InstanceGet("kuu:model_change_table"); ok {
+		if meta := Meta(scope.Value); meta != nil {
+			modelCommitted(meta, table.(string))
 		}
 	}
 }
 
+// modelCommitted 事务提交后再次使缓存失效并通知客户端
+func modelCommitted(meta *Metadata, table string) {
+	invalidateRestCacheByMeta(meta, table)
+	bumpCacheVersions(meta.Name)
+	NotifyModelChange(meta.Name)
+}
+
 func updateCallback(scope *gorm.Scope) {
 	if !scope.HasError() {
 		if desc := GetRoutinePrivilegesDesc(); desc.IsValid() {
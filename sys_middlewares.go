@@ -43,5 +43,5 @@ func LogMiddleware(c *gin.Context) {
 		log.RealName = user.Name
 	}
 
-	log.Save2Cache()
+	log.Save()
 }
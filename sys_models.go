@@ -105,7 +105,7 @@ func (u *User) BeforeSave(scope *gorm.Scope) (err error) {
 		}
 	}
 	if u.ID != 0 {
-		DelCache(fmt.Sprintf("user_%d", u.ID))
+		DelTieredCache(fmt.Sprintf("user_%d", u.ID))
 	}
 	return
 }
@@ -113,7 +113,7 @@ func (u *User) BeforeSave(scope *gorm.Scope) (err error) {
 // AfterDelete
 func (u *User) AfterDelete(tx *gorm.DB) (err error) {
 	if u.ID != 0 {
-		DelCache(fmt.Sprintf("user_%d", u.ID))
+		DelTieredCache(fmt.Sprintf("user_%d", u.ID))
 	}
 	return
 }
@@ -145,6 +145,8 @@ type Org struct {
 	FullName  string
 	Class     string
 	IsBuiltIn null.Bool `name:"是否内置"`
+	IsTenant  null.Bool `name:"是否租户"`
+	TenantDS  string    `name:"租户数据源名称"`
 }
 
 // BeforeCreate
@@ -284,6 +286,7 @@ type Menu struct {
 	Closeable     null.Bool   `name:"是否可关闭"`
 	LocaleKey     string      `name:"国际化语言键"`
 	IsVirtual     null.Bool   `name:"是否虚菜单"`
+	TenantID      uint        `name:"租户ID（为空表示全局菜单）"`
 }
 
 func updatePresetRolePrivileges(tx *gorm.DB, deleteBefore bool, ignoreAuth bool) {
@@ -363,4 +366,5 @@ type Param struct {
 	Value     string    `name:"参数值" gorm:"size:4096"`
 	Type      string    `name:"参数类型"`
 	IsBuiltIn null.Bool `name:"是否预置"`
+	TenantID  uint      `name:"租户ID（为空表示全局参数）"`
 }
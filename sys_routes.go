@@ -104,6 +104,9 @@ var UserMenusRoute = RouteInfo{
 			c.STDErr(failedMessage, err)
 			return
 		}
+		// 处理租户覆盖菜单
+		menus = overrideTenantMenus(menus)
+		total = overrideTenantMenus(total)
 		var (
 			totalMap  = make(map[uint]Menu)
 			existsMap = make(map[uint]bool)
@@ -862,6 +865,10 @@ var LangmsgsRoute = RouteInfo{
 			if ret[item.LangCode] == nil {
 				ret[item.LangCode] = make(map[string]string)
 			}
+			// 租户覆盖配置优先
+			if _, exists := ret[item.LangCode][item.Key]; exists && item.TenantID == 0 {
+				continue
+			}
 			ret[item.LangCode][item.Key] = item.Value
 		}
 		c.STD(ret)
@@ -983,6 +990,7 @@ var LangtransImportRoute = RouteInfo{
 				c.STDErr(failedMessage, err)
 				return
 			}
+			InvalidateL1(languageMessagesCacheKey)
 		}
 		c.STD(docs)
 	},
@@ -1026,6 +1034,14 @@ var LangtransPostRoute = RouteInfo{
 					case int64:
 						id = uint(langID.(int64))
 					}
+					if id != 0 && GetRoutineTenantID() != 0 {
+						// 租户修改全局配置时新增覆盖配置
+						var existing LanguageMessage
+						tx.Set(GormIgnoreTenantKey, true).Where("id = ?", id).First(&existing)
+						if existing.TenantID != GetRoutineTenantID() {
+							id = 0
+						}
+					}
 					if id != 0 {
 						// 修改
 						err = tx.Model(&LanguageMessage{}).Where("id = ?", id).Update("value", value).Error
@@ -1047,6 +1063,8 @@ var LangtransPostRoute = RouteInfo{
 		if err != nil {
 			c.STDErr(failedMessage, err)
 		} else {
+			// 事务提交后再次通知，避免其他节点在提交前重新加载旧数据
+			InvalidateL1(languageMessagesCacheKey)
 			c.STD("ok")
 		}
 	},
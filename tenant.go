@@ -0,0 +1,267 @@
+package kuu
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GormIgnoreTenantKey
+var GormIgnoreTenantKey = "kuu:ignore_tenant"
+
+// TenantEnabled
+func TenantEnabled() bool {
+	return C().GetBool("tenant:enable")
+}
+
+// IgnoreTenant 在当前请求中跳过租户隔离（仅限系统级代码使用）
+func IgnoreTenant(cancel ...bool) (success bool) {
+	caches := GetRoutineCaches()
+	if caches != nil {
+		if len(cancel) > 0 && cancel[0] == true {
+			delete(caches, GLSIgnoreTenantKey)
+		} else {
+			caches[GLSIgnoreTenantKey] = true
+		}
+		success = true
+	}
+	return
+}
+
+// GetRoutineTenantID
+func GetRoutineTenantID() uint {
+	if !TenantEnabled() {
+		return 0
+	}
+	if desc := GetRoutinePrivilegesDesc(); desc != nil {
+		return desc.TenantID
+	}
+	return 0
+}
+
+func routineDSName() string {
+	if !TenantEnabled() {
+		return ""
+	}
+	if desc := GetRoutinePrivilegesDesc(); desc != nil {
+		return desc.TenantDS
+	}
+	return ""
+}
+
+// fillTenant 计算当前组织所属租户（组织路径中最顶级的租户组织）
+func (desc *PrivilegesDesc) fillTenant(actOrg Org, orgMap map[uint]Org) {
+	if actOrg.FullPid == "" {
+		return
+	}
+	var tenant Org
+	for _, item := range strings.Split(actOrg.FullPid, ",") {
+		if org, has := orgMap[ParseID(item)]; has && org.IsTenant.Bool {
+			tenant = org
+			break
+		}
+	}
+	if tenant.ID == 0 {
+		return
+	}
+	desc.TenantID = tenant.ID
+	desc.TenantCode = tenant.Code
+	desc.TenantDS = tenant.TenantDS
+	desc.TenantOrgIDMap = make(map[uint]Org)
+	for _, org := range orgMap {
+		if org.FullPid == tenant.FullPid || strings.HasPrefix(org.FullPid, tenant.FullPid+",") {
+			desc.TenantOrgIDMap[org.ID] = org
+			desc.TenantOrgIDs = append(desc.TenantOrgIDs, org.ID)
+		}
+	}
+	sortIDs(&desc.TenantOrgIDs)
+}
+
+func registerTenantCallbacks(callback *gorm.Callback) {
+	if callback.Create().Get("kuu:tenant_create") == nil {
+		callback.Create().Before("gorm:create").Register("kuu:tenant_create", tenantCreateCallback)
+	}
+	if callback.Query().Get("kuu:tenant_query") == nil {
+		callback.Query().Before("gorm:query").Register("kuu:tenant_query", tenantQueryCallback)
+	}
+	if callback.RowQuery().Get("kuu:tenant_query") == nil {
+		callback.RowQuery().Before("gorm:row_query").Register("kuu:tenant_query", tenantQueryCallback)
+	}
+	if callback.Update().Get("kuu:tenant_update") == nil {
+		callback.Update().Before("gorm:update").Register("kuu:tenant_update", tenantWriteCallback)
+	}
+	if callback.Delete().Get("kuu:tenant_delete") == nil {
+		callback.Delete().Before("gorm:delete").Register("kuu:tenant_delete", tenantWriteCallback)
+	}
+}
+
+// tenantDesc 租户隔离不受IgnoreAuth影响，只能通过IgnoreTenant跳过
+func tenantDesc(scope *gorm.Scope) *PrivilegesDesc {
+	if !TenantEnabled() || scope.Value == nil {
+		return nil
+	}
+	if v, ok := scope.Get(GormIgnoreTenantKey); ok {
+		if ignore, ok := v.(bool); ok && ignore {
+			return nil
+		}
+	}
+	if caches := GetRoutineCaches(); caches != nil {
+		if _, ignoreTenant := caches[GLSIgnoreTenantKey]; ignoreTenant {
+			return nil
+		}
+	}
+	if desc := GetRoutinePrivilegesDesc(); desc.IsValid() && desc.TenantID != 0 {
+		return desc
+	}
+	return nil
+}
+
+func addTenantWheres(scope *gorm.Scope, desc *PrivilegesDesc, readable bool) {
+	if field, ok := scope.FieldByName("TenantID"); ok {
+		if readable {
+			// 租户可读取全局配置（TenantID为空）及本租户的覆盖配置
+			scope.Search.Where(fmt.Sprintf("(%v.%v IS NULL OR %v.%v IN (?))",
+				scope.QuotedTableName(),
+				scope.Quote(field.DBName),
+				scope.QuotedTableName(),
+				scope.Quote(field.DBName),
+			), []uint{0, desc.TenantID})
+		} else {
+			scope.Search.Where(fmt.Sprintf("%v.%v = ?",
+				scope.QuotedTableName(),
+				scope.Quote(field.DBName),
+			), desc.TenantID)
+		}
+		return
+	}
+	dbName := ""
+	if meta := Meta(scope.Value); meta != nil && meta.Name == "Org" {
+		dbName = "id"
+	} else if field, ok := scope.FieldByName("OrgID"); ok {
+		dbName = field.DBName
+	}
+	if dbName != "" {
+		scope.Search.Where(fmt.Sprintf("%v.%v IN (?)",
+			scope.QuotedTableName(),
+			scope.Quote(dbName),
+		), desc.TenantOrgIDs)
+	}
+}
+
+func tenantQueryCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		if desc := tenantDesc(scope); desc != nil {
+			addTenantWheres(scope, desc, true)
+		}
+	}
+}
+
+func tenantWriteCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		if desc := tenantDesc(scope); desc != nil {
+			addTenantWheres(scope, desc, false)
+		}
+	}
+}
+
+func tenantCreateCallback(scope *gorm.Scope) {
+	if !scope.HasError() {
+		desc := tenantDesc(scope)
+		if desc == nil {
+			return
+		}
+		if field, ok := scope.FieldByName("TenantID"); ok {
+			if field.IsBlank {
+				if err := scope.SetColumn(field.DBName, desc.TenantID); err != nil {
+					_ = scope.Err(fmt.Errorf("自动设置租户ID失败：%s", err.Error()))
+				}
+			} else if v, ok := field.Field.Interface().(uint); ok && v != desc.TenantID {
+				_ = scope.Err(fmt.Errorf("用户 %d 无法为租户 %d 创建数据", desc.UID, v))
+			}
+			return
+		}
+		if meta := Meta(scope.Value); meta != nil && meta.Name == "Org" {
+			return
+		}
+		if field, ok := scope.FieldByName("OrgID"); ok && !field.IsBlank {
+			if v, ok := field.Field.Interface().(uint); ok {
+				if _, has := desc.TenantOrgIDMap[v]; !has {
+					_ = scope.Err(fmt.Errorf("组织 %d 不属于租户 %s", v, desc.TenantCode))
+				}
+			}
+		}
+	}
+}
+
+// GetParam 查询参数，启用租户模式时优先返回当前租户的覆盖配置
+func GetParam(code string) (param Param) {
+	cacheKey := fmt.Sprintf("param_%d_%d_%s", cacheVersion("param"), GetRoutineTenantID(), code)
+	ttl := time.Duration(C().DefaultGetInt("cache:paramTTL", 300)) * time.Second
+	err := GetOrLoad(cacheKey, ttl, &param, func(dest interface{}) error {
+		var list []Param
+		if err := DB().Where("code = ?", code).Find(&list).Error; err != nil {
+			return err
+		}
+		p := dest.(*Param)
+		for _, item := range list {
+			if p.ID == 0 || item.TenantID != 0 {
+				*p = item
+			}
+		}
+		if p.ID == 0 {
+			return ErrCacheNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if err != ErrCacheNotFound {
+			ERROR(err)
+		}
+		return Param{}
+	}
+	return
+}
+
+// GetParamValue
+func GetParamValue(code string, defaultValue ...string) string {
+	if param := GetParam(code); param.ID != 0 {
+		return param.Value
+	}
+	if len(defaultValue) > 0 {
+		return defaultValue[0]
+	}
+	return ""
+}
+
+// overrideTenantMenus 租户菜单按编码覆盖全局菜单，保留全局菜单的ID和层级关系
+func overrideTenantMenus(list []Menu) []Menu {
+	var (
+		overrides = make(map[string]Menu)
+		globals   = make(map[string]bool)
+	)
+	for _, item := range list {
+		if item.TenantID != 0 {
+			overrides[item.Code] = item
+		} else {
+			globals[item.Code] = true
+		}
+	}
+	if len(overrides) == 0 {
+		return list
+	}
+	result := make([]Menu, 0, len(list))
+	for _, item := range list {
+		if item.TenantID != 0 && globals[item.Code] {
+			continue
+		}
+		if v, has := overrides[item.Code]; has && item.TenantID == 0 {
+			v.ID = item.ID
+			v.Pid = item.Pid
+			item = v
+		}
+		result = append(result, item)
+	}
+	return result
+}
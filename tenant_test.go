@@ -0,0 +1,210 @@
+package kuu
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/jinzhu/gorm"
+	"github.com/jtolds/gls"
+	"gopkg.in/guregu/null.v3"
+)
+
+type tenantTestDoc struct {
+	ID    uint
+	OrgID uint
+	Name  string
+}
+
+// testDriver 测试用数据库驱动，查询和执行结果由newTestDB指定
+type testDriver struct {
+	mu    sync.Mutex
+	query func(query string, args []driver.Value) ([]string, [][]driver.Value)
+	exec  func(query string, args []driver.Value) driver.Result
+}
+
+type testConn struct{ d *testDriver }
+
+type testStmt struct {
+	d     *testDriver
+	query string
+}
+
+type testRows struct {
+	columns []string
+	rows    [][]driver.Value
+	i       int
+}
+
+var testDrv = &testDriver{}
+
+func init() {
+	sql.Register("kuu_test", testDrv)
+}
+
+func (d *testDriver) Open(string) (driver.Conn, error) { return &testConn{d: d}, nil }
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return &testStmt{d: c.d, query: query}, nil
+}
+func (c *testConn) Close() error              { return nil }
+func (c *testConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *testConn) Commit() error             { return nil }
+func (c *testConn) Rollback() error           { return nil }
+
+func (s *testStmt) Close() error  { return nil }
+func (s *testStmt) NumInput() int { return -1 }
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if s.d.exec == nil {
+		return driver.RowsAffected(0), nil
+	}
+	return s.d.exec(s.query, args), nil
+}
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	rows := &testRows{}
+	if s.d.query != nil {
+		rows.columns, rows.rows = s.d.query(s.query, args)
+	}
+	return rows, nil
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+func (r *testRows) Close() error      { return nil }
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+// newTestDB 返回使用testDriver的连接，query和exec可为空
+func newTestDB(t *testing.T, query func(string, []driver.Value) ([]string, [][]driver.Value), exec func(string, []driver.Value) driver.Result) *gorm.DB {
+	return newTestDBWithDialect(t, "kuu_test", query, exec)
+}
+
+// newTestDBWithDialect 使用gorm内置的方言（如postgres、sqlite3）生成SQL
+func newTestDBWithDialect(t *testing.T, dialect string, query func(string, []driver.Value) ([]string, [][]driver.Value), exec func(string, []driver.Value) driver.Result) *gorm.DB {
+	testDrv.mu.Lock()
+	testDrv.query, testDrv.exec = query, exec
+	testDrv.mu.Unlock()
+	sqlDB, err := sql.Open("kuu_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(dialect, sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func containsDriverValue(values []driver.Value, v driver.Value) bool {
+	for _, item := range values {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// setTestConfig 修改配置项，返回恢复函数
+func setTestConfig(path, raw string) func() {
+	c := C()
+	old := c.data
+	data := []byte("{}")
+	if len(old) > 0 {
+		data = append([]byte(nil), old...)
+	}
+	c.data, _ = jsonparser.Set(data, []byte(raw), path)
+	return func() {
+		c.data = old
+	}
+}
+
+func tenantTestDesc(uid uint, actOrgID uint, orgMap map[uint]Org) *PrivilegesDesc {
+	desc := &PrivilegesDesc{
+		UID:      uid,
+		Valid:    true,
+		SignInfo: &SignContext{Token: "token", UID: uid, Secret: &SignSecret{}},
+	}
+	desc.fillTenant(orgMap[actOrgID], orgMap)
+	return desc
+}
+
+func TestTenantIsolation(t *testing.T) {
+	defer setTestConfig("tenant:enable", "true")()
+
+	orgMap := map[uint]Org{
+		1: {ID: 1, Code: "a", FullPid: "1", IsTenant: null.BoolFrom(true)},
+		2: {ID: 2, Code: "a_sales", FullPid: "1,2"},
+		3: {ID: 3, Code: "b", FullPid: "3", IsTenant: null.BoolFrom(true)},
+		4: {ID: 4, Code: "b_sales", FullPid: "3,4"},
+	}
+	rows := [][]driver.Value{
+		{int64(1), int64(2), "a1"},
+		{int64(2), int64(2), "a2"},
+		{int64(3), int64(4), "b1"},
+	}
+	// 模拟org_id IN (...)条件：按参数中的组织ID过滤
+	db := newTestDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		var result [][]driver.Value
+		for _, row := range rows {
+			if !strings.Contains(query, " IN ") || containsDriverValue(args, row[1]) {
+				result = append(result, row)
+			}
+		}
+		return []string{"id", "org_id", "name"}, result
+	}, nil)
+	registerTenantCallbacks(db.Callback())
+
+	var all []tenantTestDoc
+	if err := db.Find(&all).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 rows without a tenant, got %d", len(all))
+	}
+
+	descA := tenantTestDesc(10, 2, orgMap)
+	if descA.TenantID != 1 || len(descA.TenantOrgIDs) != 2 {
+		t.Fatalf("unexpected tenant of A: %d %v", descA.TenantID, descA.TenantOrgIDs)
+	}
+	SetGLSValues(gls.Values{GLSPrisDescKey: descA}, func() {
+		var list []tenantTestDoc
+		if err := db.Find(&list).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("expected tenant A to read 2 rows, got %d", len(list))
+		}
+		for _, item := range list {
+			if item.OrgID != 2 {
+				t.Fatalf("tenant A read a row of org %d", item.OrgID)
+			}
+		}
+		if err := db.Create(&tenantTestDoc{OrgID: 4, Name: "b2"}).Error; err == nil {
+			t.Fatal("expected tenant A to be rejected when creating rows for tenant B")
+		}
+	})
+
+	descB := tenantTestDesc(20, 4, orgMap)
+	SetGLSValues(gls.Values{GLSPrisDescKey: descB}, func() {
+		var list []tenantTestDoc
+		if err := db.Find(&list).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Name != "b1" {
+			t.Fatalf("expected tenant B to read only its own row, got %d", len(list))
+		}
+	})
+}
@@ -0,0 +1,742 @@
+package kuu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// SpanKindInternal
+	SpanKindInternal = 1
+	// SpanKindServer
+	SpanKindServer = 2
+	// SpanKindClient
+	SpanKindClient = 3
+
+	// SpanStatusUnset
+	SpanStatusUnset = 0
+	// SpanStatusOK
+	SpanStatusOK = 1
+	// SpanStatusError
+	SpanStatusError = 2
+
+	// SpanExporterOTLP OTLP/HTTP（JSON编码）
+	SpanExporterOTLP = "otlp"
+	// SpanExporterMemory 内存导出，用于测试
+	SpanExporterMemory = "memory"
+)
+
+var (
+	// SpanKey
+	SpanKey = "Span"
+	// GLSSpanKey
+	GLSSpanKey = "Span"
+
+	spanExporterFactories = map[string]func() (SpanExporter, error){
+		SpanExporterOTLP:   func() (SpanExporter, error) { return NewOTLPHTTPExporter(), nil },
+		SpanExporterMemory: func() (SpanExporter, error) { return NewMemorySpanExporter(), nil },
+	}
+	spanExporterFactoriesMu sync.RWMutex
+
+	activeSpanProcessor   *spanProcessor
+	activeSpanProcessorMu sync.RWMutex
+)
+
+// SpanData span的导出数据
+type SpanData struct {
+	TraceID       string
+	SpanID        string
+	ParentID      string
+	Name          string
+	Kind          int
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	Status        int
+	StatusMessage string
+}
+
+// Span 一次操作的耗时记录，所有方法均可在nil上调用，未启用链路追踪时StartSpan返回nil
+type Span struct {
+	SpanData
+	mu        sync.Mutex
+	ended     int32
+	processor *spanProcessor
+}
+
+// SetName
+func (s *Span) SetName(name string) *Span {
+	if s != nil {
+		s.mu.Lock()
+		s.Name = name
+		s.mu.Unlock()
+	}
+	return s
+}
+
+// SetAttribute
+func (s *Span) SetAttribute(key string, value interface{}) *Span {
+	if s != nil {
+		s.mu.Lock()
+		s.Attributes[key] = value
+		s.mu.Unlock()
+	}
+	return s
+}
+
+// SetError 标记为失败状态
+func (s *Span) SetError(err error) *Span {
+	if s != nil && err != nil {
+		s.mu.Lock()
+		s.Status = SpanStatusError
+		s.StatusMessage = err.Error()
+		s.mu.Unlock()
+	}
+	return s
+}
+
+// End 结束并提交导出，重复调用无效
+func (s *Span) End() {
+	if s == nil || !atomic.CompareAndSwapInt32(&s.ended, 0, 1) {
+		return
+	}
+	s.mu.Lock()
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	s.processor.push(s)
+}
+
+// snapshot 导出时复制字段，避免与未完成的写入竞争
+func (s *Span) snapshot() SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.SpanData
+	data.Attributes = make(map[string]interface{}, len(s.Attributes))
+	for k, v := range s.Attributes {
+		data.Attributes[k] = v
+	}
+	return data
+}
+
+// SpanExporter span导出器，ExportSpans按批次调用
+type SpanExporter interface {
+	ExportSpans(spans []SpanData) error
+	Shutdown() error
+}
+
+// RegisterSpanExporter 注册自定义导出器，可在trace:exporter中按名称引用
+func RegisterSpanExporter(name string, factory func() (SpanExporter, error)) {
+	spanExporterFactoriesMu.Lock()
+	defer spanExporterFactoriesMu.Unlock()
+	spanExporterFactories[name] = factory
+}
+
+// SetSpanExporter 设置导出器并启用链路追踪，传入nil时关闭
+func SetSpanExporter(exporter SpanExporter) {
+	var p *spanProcessor
+	if exporter != nil {
+		p = newSpanProcessor(exporter)
+	}
+	activeSpanProcessorMu.Lock()
+	old := activeSpanProcessor
+	activeSpanProcessor = p
+	activeSpanProcessorMu.Unlock()
+	if old != nil {
+		old.shutdown()
+	}
+}
+
+// TracingEnabled
+func TracingEnabled() bool {
+	return getSpanProcessor() != nil
+}
+
+// FlushSpans 立即导出已结束的span
+func FlushSpans() {
+	if p := getSpanProcessor(); p != nil {
+		p.forceFlush()
+	}
+}
+
+func getSpanProcessor() *spanProcessor {
+	activeSpanProcessorMu.RLock()
+	defer activeSpanProcessorMu.RUnlock()
+	return activeSpanProcessor
+}
+
+// initTracing 根据trace:exporter创建导出器，未配置时保留代码中设置的导出器
+func initTracing() {
+	name := C().GetString("trace:exporter")
+	if name == "" {
+		return
+	}
+	spanExporterFactoriesMu.RLock()
+	factory := spanExporterFactories[name]
+	spanExporterFactoriesMu.RUnlock()
+	if factory == nil {
+		ERROR("Unknown span exporter: %s", name)
+		return
+	}
+	exporter, err := factory()
+	if err != nil {
+		ERROR("Create span exporter %s failed: %s", name, err.Error())
+		return
+	}
+	SetSpanExporter(exporter)
+}
+
+func shutdownTracing() {
+	SetSpanExporter(nil)
+}
+
+// sampleTrace 按trace:sampleRatio决定新链路是否采样
+func sampleTrace() bool {
+	p := getSpanProcessor()
+	if p == nil || p.sampleRatio >= 1 {
+		return true
+	}
+	return rand.Float64() < p.sampleRatio
+}
+
+func newSpan(p *spanProcessor, name string, kind int, traceID, spanID, parentID string) *Span {
+	return &Span{
+		SpanData: SpanData{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			ParentID:   parentID,
+			Name:       name,
+			Kind:       kind,
+			StartTime:  time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+		processor: p,
+	}
+}
+
+// StartSpan 创建span，未指定parent时使用当前协程的span，均不存在时开启新链路；显式传入nil时总是开启新链路
+func StartSpan(name string, parent ...*Span) *Span {
+	p := getSpanProcessor()
+	if p == nil {
+		return nil
+	}
+	var ps *Span
+	if len(parent) > 0 {
+		ps = parent[0]
+	} else {
+		ps = GetRoutineSpan()
+		// 当前请求未被采样
+		if trace := GetRoutineTraceContext(); ps == nil && trace != nil && !trace.Sampled() {
+			return nil
+		}
+	}
+	if ps != nil {
+		return newSpan(p, name, SpanKindInternal, ps.TraceID, randomHex(8), ps.SpanID)
+	}
+	if !sampleTrace() {
+		return nil
+	}
+	return newSpan(p, name, SpanKindInternal, randomHex(16), randomHex(8), "")
+}
+
+// startChildSpan 仅在存在父span时创建，避免后台的缓存和数据库操作产生大量孤立链路
+func startChildSpan(name string, kind int) *Span {
+	p := getSpanProcessor()
+	if p == nil {
+		return nil
+	}
+	parent := GetRoutineSpan()
+	if parent == nil {
+		return nil
+	}
+	return newSpan(p, name, kind, parent.TraceID, randomHex(8), parent.SpanID)
+}
+
+// startServerSpan 以请求的TraceContext创建服务端span，上游未采样时不记录
+func startServerSpan(c *gin.Context, trace *TraceContext) *Span {
+	p := getSpanProcessor()
+	if p == nil || trace == nil || !trace.Sampled() {
+		return nil
+	}
+	span := newSpan(p, fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path), SpanKindServer, trace.TraceID, trace.SpanID, trace.ParentID)
+	span.SetAttribute("http.method", c.Request.Method)
+	span.SetAttribute("http.target", c.Request.URL.Path)
+	span.SetAttribute("http.client_ip", c.ClientIP())
+	if id := GetRequestID(c); id != "" {
+		span.SetAttribute("request_id", id)
+	}
+	return span
+}
+
+// endServerSpan
+func endServerSpan(c *gin.Context, span *Span) {
+	if span == nil {
+		return
+	}
+	status := c.Writer.Status()
+	span.SetAttribute("http.status_code", status)
+	if name := c.GetString(RouteNameKey); name != "" {
+		span.SetAttribute("http.route", name)
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+	span.End()
+}
+
+// GetSpan
+func GetSpan(c *gin.Context) *Span {
+	if c == nil {
+		return nil
+	}
+	if v, exists := c.Get(SpanKey); exists {
+		if span, ok := v.(*Span); ok {
+			return span
+		}
+	}
+	return nil
+}
+
+// GetRoutineSpan
+func GetRoutineSpan() *Span {
+	if raw, ok := GetGLSValue(GLSSpanKey); ok {
+		if span, ok := raw.(*Span); ok {
+			return span
+		}
+	}
+	return nil
+}
+
+// WithSpan 以span作为当前协程的span执行fn
+func WithSpan(span *Span, fn func()) {
+	if span == nil {
+		fn()
+		return
+	}
+	SetGLSValues(map[interface{}]interface{}{GLSSpanKey: span}, fn)
+}
+
+// GoWithSpan 在新协程中执行fn，并延续当前协程的span
+func GoWithSpan(fn func()) {
+	span := GetRoutineSpan()
+	go WithSpan(span, fn)
+}
+
+type spanProcessor struct {
+	exporter      SpanExporter
+	queue         chan *Span
+	flushCh       chan chan struct{}
+	done          chan struct{}
+	batchSize     int
+	flushInterval time.Duration
+	sampleRatio   float64
+	dropped       int64
+	closeMu       sync.RWMutex
+	closed        bool
+}
+
+func newSpanProcessor(exporter SpanExporter) *spanProcessor {
+	p := &spanProcessor{
+		exporter:      exporter,
+		queue:         make(chan *Span, C().DefaultGetInt("trace:queueSize", 2048)),
+		flushCh:       make(chan chan struct{}),
+		done:          make(chan struct{}),
+		batchSize:     C().DefaultGetInt("trace:batchSize", 512),
+		flushInterval: time.Duration(C().DefaultGetInt("trace:flushInterval", 5000)) * time.Millisecond,
+		sampleRatio:   C().DefaultGetFloat64("trace:sampleRatio", 1),
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = 512
+	}
+	if p.flushInterval <= 0 {
+		p.flushInterval = 5 * time.Second
+	}
+	go p.run()
+	return p
+}
+
+// push 队列已满时直接丢弃，不阻塞业务
+func (p *spanProcessor) push(span *Span) {
+	if p == nil {
+		return
+	}
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- span:
+	default:
+		if atomic.AddInt64(&p.dropped, 1)%1000 == 1 {
+			fmt.Fprintf(os.Stderr, "[KUU-WARN] Span queue is full, spans are being dropped\n")
+		}
+	}
+}
+
+func (p *spanProcessor) run() {
+	defer close(p.done)
+	var (
+		ticker = time.NewTicker(p.flushInterval)
+		batch  = make([]SpanData, 0, p.batchSize)
+	)
+	defer ticker.Stop()
+	flush := func() {
+		if len(batch) > 0 {
+			p.export(batch)
+			batch = make([]SpanData, 0, p.batchSize)
+		}
+	}
+	for {
+		select {
+		case span, ok := <-p.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span.snapshot())
+			if len(batch) >= p.batchSize {
+				flush()
+			}
+		case ack := <-p.flushCh:
+			for len(p.queue) > 0 {
+				batch = append(batch, (<-p.queue).snapshot())
+			}
+			flush()
+			close(ack)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (p *spanProcessor) export(batch []SpanData) {
+	if err := p.exporter.ExportSpans(batch); err != nil {
+		// 避免导出失败时递归产生日志和span
+		fmt.Fprintf(os.Stderr, "[KUU-ERROR] Export %d spans failed: %s\n", len(batch), err.Error())
+	}
+}
+
+func (p *spanProcessor) forceFlush() {
+	ack := make(chan struct{})
+	select {
+	case p.flushCh <- ack:
+		<-ack
+	case <-p.done:
+	}
+}
+
+func (p *spanProcessor) shutdown() {
+	p.closeMu.Lock()
+	if p.closed {
+		p.closeMu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	p.closeMu.Unlock()
+	<-p.done
+	if err := p.exporter.Shutdown(); err != nil {
+		ERROR("Shutdown span exporter failed: %s", err.Error())
+	}
+}
+
+// MemorySpanExporter 将span保存在内存中，用于测试
+type MemorySpanExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewMemorySpanExporter
+func NewMemorySpanExporter() *MemorySpanExporter {
+	return &MemorySpanExporter{}
+}
+
+// ExportSpans
+func (e *MemorySpanExporter) ExportSpans(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans
+func (e *MemorySpanExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset
+func (e *MemorySpanExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// Shutdown
+func (e *MemorySpanExporter) Shutdown() error {
+	return nil
+}
+
+// OTLPHTTPExporter 以OTLP/HTTP JSON格式导出，配置项为trace:otlp
+type OTLPHTTPExporter struct {
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	client      *http.Client
+}
+
+// NewOTLPHTTPExporter
+func NewOTLPHTTPExporter() *OTLPHTTPExporter {
+	var opts struct {
+		Endpoint string
+		Headers  map[string]string
+		Timeout  int
+	}
+	C().GetInterface("trace:otlp", &opts)
+	if opts.Endpoint == "" {
+		opts.Endpoint = "http://127.0.0.1:4318/v1/traces"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10
+	}
+	return &OTLPHTTPExporter{
+		Endpoint:    opts.Endpoint,
+		Headers:     opts.Headers,
+		ServiceName: C().DefaultGetString("trace:serviceName", GetAppName()),
+		client:      &http.Client{Timeout: time.Duration(opts.Timeout) * time.Second},
+	}
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue 按OTLP JSON规范，int64以字符串编码
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpValue(value interface{}) otlpAnyValue {
+	var v otlpAnyValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s := fmt.Sprintf("%d", x)
+		v.IntValue = &s
+	case float32:
+		f := float64(x)
+		v.DoubleValue = &f
+	case float64:
+		v.DoubleValue = &x
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return v
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpValue(attrs[k])})
+	}
+	return kvs
+}
+
+func (e *OTLPHTTPExporter) buildRequest(spans []SpanData) otlpExportRequest {
+	list := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		list = append(list, otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		})
+	}
+	return otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource:   otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": e.ServiceName})},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "kuu"}, Spans: list}},
+			},
+		},
+	}
+}
+
+// ExportSpans
+func (e *OTLPHTTPExporter) ExportSpans(spans []SpanData) error {
+	data, err := json.Marshal(e.buildRequest(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Shutdown
+func (e *OTLPHTTPExporter) Shutdown() error {
+	return nil
+}
+
+const traceSpanInstanceKey = "kuu:trace_span"
+
+func registerTraceCallbacks(callback *gorm.Callback) {
+	if callback.Create().Get("kuu:trace_begin") == nil {
+		callback.Create().Before("gorm:begin_transaction").Register("kuu:trace_begin", traceBeginCallback("create"))
+		callback.Create().After("gorm:commit_or_rollback_transaction").Register("kuu:trace_end", traceEndCallback)
+	}
+	if callback.Update().Get("kuu:trace_begin") == nil {
+		callback.Update().Before("gorm:begin_transaction").Register("kuu:trace_begin", traceBeginCallback("update"))
+		callback.Update().After("gorm:commit_or_rollback_transaction").Register("kuu:trace_end", traceEndCallback)
+	}
+	if callback.Delete().Get("kuu:trace_begin") == nil {
+		callback.Delete().Before("gorm:begin_transaction").Register("kuu:trace_begin", traceBeginCallback("delete"))
+		callback.Delete().After("gorm:commit_or_rollback_transaction").Register("kuu:trace_end", traceEndCallback)
+	}
+	if callback.Query().Get("kuu:trace_begin") == nil {
+		callback.Query().Before("gorm:query").Register("kuu:trace_begin", traceBeginCallback("query"))
+		callback.Query().After("gorm:after_query").Register("kuu:trace_end", traceEndCallback)
+	}
+	if callback.RowQuery().Get("kuu:trace_begin") == nil {
+		callback.RowQuery().Before("gorm:row_query").Register("kuu:trace_begin", traceBeginCallback("row_query"))
+		callback.RowQuery().After("gorm:row_query").Register("kuu:trace_end", traceEndCallback)
+	}
+}
+
+func traceBeginCallback(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		if !TracingEnabled() {
+			return
+		}
+		var table string
+		if scope.Value != nil {
+			table = scope.TableName()
+		}
+		span := startChildSpan(strings.TrimSpace(fmt.Sprintf("gorm:%s %s", operation, table)), SpanKindClient)
+		if span == nil {
+			return
+		}
+		span.SetAttribute("db.system", scope.Dialect().GetName())
+		span.SetAttribute("db.operation", operation)
+		if table != "" {
+			span.SetAttribute("db.table", table)
+		}
+		scope.InstanceSet(traceSpanInstanceKey, span)
+	}
+}
+
+func traceEndCallback(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(traceSpanInstanceKey)
+	if !ok {
+		return
+	}
+	span, _ := v.(*Span)
+	if span == nil {
+		return
+	}
+	span.SetAttribute("db.statement", scope.SQL)
+	span.SetAttribute("db.rows_affected", scope.DB().RowsAffected)
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// startCacheSpan
+func startCacheSpan(system, operation string, keys ...string) *Span {
+	if !TracingEnabled() {
+		return nil
+	}
+	span := startChildSpan(fmt.Sprintf("cache:%s", operation), SpanKindClient)
+	if span != nil {
+		span.SetAttribute("db.system", system)
+		span.SetAttribute("db.operation", operation)
+		span.SetAttribute("cache.key", strings.Join(keys, ","))
+	}
+	return span
+}
@@ -0,0 +1,273 @@
+package kuu
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// RequestIDHeaderKey
+	RequestIDHeaderKey = "X-Request-ID"
+	// TraceparentHeaderKey W3C Trace Context请求头
+	TraceparentHeaderKey = "traceparent"
+	// RequestIDKey
+	RequestIDKey = "RequestID"
+	// TraceContextKey
+	TraceContextKey = "TraceContext"
+	// GLSRequestIDKey
+	GLSRequestIDKey = "RequestID"
+	// GLSTraceContextKey
+	GLSTraceContextKey = "TraceContext"
+)
+
+// TraceContext W3C Trace Context，SpanID为当前服务生成的span，ParentID为上游传入的span
+type TraceContext struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Flags    string
+}
+
+// Traceparent 序列化为traceparent请求头
+func (t *TraceContext) Traceparent() string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, t.SpanID, t.Flags)
+}
+
+// Sampled
+func (t *TraceContext) Sampled() bool {
+	if t == nil || len(t.Flags) != 2 {
+		return false
+	}
+	b, err := hex.DecodeString(t.Flags)
+	return err == nil && b[0]&0x01 == 0x01
+}
+
+// NewTraceContext 生成新的链路，按trace:sampleRatio设置采样标记
+func NewTraceContext() *TraceContext {
+	trace := &TraceContext{
+		TraceID: randomHex(16),
+		SpanID:  randomHex(8),
+		Flags:   "00",
+	}
+	if sampleTrace() {
+		trace.Flags = "01"
+	}
+	return trace
+}
+
+// ParseTraceparent 解析traceparent请求头，并为当前服务生成新的SpanID
+func ParseTraceparent(value string) (*TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return nil, false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return nil, false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return nil, false
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return nil, false
+	}
+	if !isLowerHex(flags, 2) {
+		return nil, false
+	}
+	return &TraceContext{
+		TraceID:  traceID,
+		SpanID:   randomHex(8),
+		ParentID: parentID,
+		Flags:    flags,
+	}, true
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Replace(uuid.NewV4().String(), "-", "", -1)[:n*2]
+	}
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID 客户端传入的请求ID会写入日志和SQL注释，仅允许安全字符
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// TraceMiddleware 接收或生成请求ID和traceparent，并在响应头中回传
+func TraceMiddleware(c *gin.Context) {
+	requestID := c.GetHeader(RequestIDHeaderKey)
+	if !isValidRequestID(requestID) {
+		requestID = uuid.NewV4().String()
+	}
+	trace, ok := ParseTraceparent(c.GetHeader(TraceparentHeaderKey))
+	if !ok {
+		trace = NewTraceContext()
+	}
+	c.Set(RequestIDKey, requestID)
+	c.Set(TraceContextKey, trace)
+	c.Header(RequestIDHeaderKey, requestID)
+	c.Header(TraceparentHeaderKey, trace.Traceparent())
+	span := startServerSpan(c, trace)
+	if span != nil {
+		c.Set(SpanKey, span)
+	}
+	c.Next()
+	endServerSpan(c, span)
+}
+
+// GetRequestID
+func GetRequestID(c *gin.Context) string {
+	if c == nil {
+		return ""
+	}
+	if id := c.GetString(RequestIDKey); id != "" {
+		return id
+	}
+	// 未启用TraceMiddleware时使用请求头
+	if c.Request != nil {
+		if id := c.GetHeader(RequestIDHeaderKey); isValidRequestID(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// GetTraceContext
+func GetTraceContext(c *gin.Context) *TraceContext {
+	if c == nil {
+		return nil
+	}
+	if v, exists := c.Get(TraceContextKey); exists {
+		if trace, ok := v.(*TraceContext); ok {
+			return trace
+		}
+	}
+	return nil
+}
+
+// GetRoutineRequestID
+func GetRoutineRequestID() string {
+	if raw, ok := GetGLSValue(GLSRequestIDKey); ok {
+		if id, ok := raw.(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// GetRoutineTraceContext
+func GetRoutineTraceContext() *TraceContext {
+	if raw, ok := GetGLSValue(GLSTraceContextKey); ok {
+		if trace, ok := raw.(*TraceContext); ok {
+			return trace
+		}
+	}
+	return nil
+}
+
+// LogTraceHook 为日志附加请求ID和链路ID
+type LogTraceHook struct{}
+
+// Levels
+func (h *LogTraceHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire
+func (h *LogTraceHook) Fire(entry *logrus.Entry) error {
+	if id := GetRoutineRequestID(); id != "" {
+		entry.Data["request_id"] = id
+	}
+	if trace := GetRoutineTraceContext(); trace != nil {
+		entry.Data["trace_id"] = trace.TraceID
+		entry.Data["span_id"] = trace.SpanID
+	}
+	return nil
+}
+
+// sqlComment 按sqlcommenter格式生成SQL注释
+func sqlComment() string {
+	var pairs []string
+	if id := GetRoutineRequestID(); id != "" {
+		pairs = append(pairs, fmt.Sprintf("request_id='%s'", id))
+	}
+	if trace := GetRoutineTraceContext(); trace != nil {
+		pairs = append(pairs, fmt.Sprintf("traceparent='%s'", trace.Traceparent()))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/*%s*/", strings.Join(pairs, ","))
+}
+
+func registerSQLCommentCallbacks(callback *gorm.Callback) {
+	if !C().DefaultGetBool("sql:comments", true) {
+		return
+	}
+	if callback.Query().Get("kuu:sql_comment") == nil {
+		callback.Query().Before("gorm:query").Register("kuu:sql_comment", sqlCommentCallback("gorm:query_option"))
+	}
+	if callback.RowQuery().Get("kuu:sql_comment") == nil {
+		callback.RowQuery().Before("gorm:row_query").Register("kuu:sql_comment", sqlCommentCallback("gorm:query_option"))
+	}
+	if callback.Create().Get("kuu:sql_comment") == nil {
+		callback.Create().Before("gorm:create").Register("kuu:sql_comment", sqlCommentCallback("gorm:insert_option"))
+	}
+	if callback.Update().Get("kuu:sql_comment") == nil {
+		callback.Update().Before("gorm:update").Register("kuu:sql_comment", sqlCommentCallback("gorm:update_option"))
+	}
+	if callback.Delete().Get("kuu:sql_comment") == nil {
+		callback.Delete().Before("gorm:delete").Register("kuu:sql_comment", sqlCommentCallback("gorm:delete_option"))
+	}
+}
+
+// sqlCommentCallback 通过gorm的*_option追加注释，已有的选项（如FOR UPDATE）保留在注释之前
+func sqlCommentCallback(option string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		comment := sqlComment()
+		if comment == "" {
+			return
+		}
+		if v, ok := scope.Get(option); ok {
+			str := fmt.Sprint(v)
+			// 关联保存等嵌套scope会继承父级选项
+			if strings.Contains(str, comment) {
+				return
+			}
+			comment = str + " " + comment
+		}
+		scope.Set(option, comment)
+	}
+}
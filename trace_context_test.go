@@ -0,0 +1,89 @@
+package kuu
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtolds/gls"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	trace, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected valid traceparent")
+	}
+	if trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("unexpected trace context: %+v", trace)
+	}
+	if trace.SpanID == trace.ParentID || len(trace.SpanID) != 16 {
+		t.Errorf("expected new span id, got %s", trace.SpanID)
+	}
+	if !trace.Sampled() {
+		t.Error("expected sampled")
+	}
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, item := range invalid {
+		if _, ok := ParseTraceparent(item); ok {
+			t.Errorf("expected invalid traceparent: %q", item)
+		}
+	}
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); !ok {
+		t.Error("future versions may carry extra fields")
+	}
+}
+
+func TestTraceMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TraceMiddleware)
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, GetRequestID(c))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeaderKey, "abc-123")
+	req.Header.Set(TraceparentHeaderKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Body.String() != "abc-123" || w.Header().Get(RequestIDHeaderKey) != "abc-123" {
+		t.Errorf("request id not propagated: %q", w.Body.String())
+	}
+	if tp := w.Header().Get(TraceparentHeaderKey); !strings.HasPrefix(tp, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("trace id not propagated: %s", tp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeaderKey, "x*/ DROP TABLE users; /*")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if id := w.Body.String(); id == "" || strings.Contains(id, "*") {
+		t.Errorf("unsafe request id should be replaced: %q", id)
+	}
+	if _, ok := ParseTraceparent(w.Header().Get(TraceparentHeaderKey)); !ok {
+		t.Error("expected generated traceparent")
+	}
+}
+
+func TestSQLComment(t *testing.T) {
+	if comment := sqlComment(); comment != "" {
+		t.Errorf("expected empty comment outside request, got %s", comment)
+	}
+	trace := &TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Flags: "01"}
+	SetGLSValues(gls.Values{GLSRequestIDKey: "abc-123", GLSTraceContextKey: trace}, func() {
+		want := "/*request_id='abc-123',traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/"
+		if comment := sqlComment(); comment != want {
+			t.Errorf("got %s, want %s", comment, want)
+		}
+	})
+}
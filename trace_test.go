@@ -0,0 +1,128 @@
+package kuu
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSpans(t *testing.T) {
+	if span := StartSpan("disabled"); span != nil {
+		t.Fatal("expected nil span when tracing is disabled")
+	}
+	exporter := NewMemorySpanExporter()
+	SetSpanExporter(exporter)
+	defer SetSpanExporter(nil)
+
+	root := StartSpan("root")
+	WithSpan(root, func() {
+		child := startCacheSpan("bolt", "GetString", "foo")
+		child.SetError(errors.New("boom"))
+		child.End()
+	})
+	if span := startCacheSpan("bolt", "GetString", "bar"); span != nil {
+		t.Error("cache spans without a parent should be skipped")
+	}
+	root.End()
+	root.End()
+	FlushSpans()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if child.TraceID != parent.TraceID || child.ParentID != parent.SpanID {
+		t.Errorf("child is not linked to parent: %+v %+v", child, parent)
+	}
+	if child.Status != SpanStatusError || child.StatusMessage != "boom" {
+		t.Errorf("unexpected child status: %d %s", child.Status, child.StatusMessage)
+	}
+	if child.Attributes["cache.key"] != "foo" || child.Kind != SpanKindClient {
+		t.Errorf("unexpected child attributes: %v", child.Attributes)
+	}
+}
+
+func TestServerSpan(t *testing.T) {
+	exporter := NewMemorySpanExporter()
+	SetSpanExporter(exporter)
+	defer SetSpanExporter(nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(TraceMiddleware)
+	r.GET("/", func(c *gin.Context) {
+		if parent := GetSpan(c); parent != nil {
+			StartSpan("handler", parent).End()
+		}
+		c.Status(http.StatusInternalServerError)
+	})
+
+	for _, flags := range []string{"01", "00"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceparentHeaderKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-"+flags)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	FlushSpans()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans from the sampled request, got %d", len(spans))
+	}
+	server := spans[1]
+	if server.Kind != SpanKindServer || server.ParentID != "00f067aa0ba902b7" || server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected server span: %+v", server)
+	}
+	if server.Status != SpanStatusError || server.Attributes["http.status_code"] != http.StatusInternalServerError {
+		t.Errorf("unexpected server status: %+v", server)
+	}
+	if spans[0].ParentID != server.SpanID {
+		t.Error("handler span is not linked to server span")
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	exporter := &OTLPHTTPExporter{
+		Endpoint:    srv.URL,
+		Headers:     map[string]string{"Authorization": "token"},
+		ServiceName: "test",
+		client:      srv.Client(),
+	}
+	span := newSpan(nil, "gorm:query user", SpanKindClient, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "")
+	span.SetAttribute("db.rows_affected", int64(3))
+	if err := exporter.ExportSpans([]SpanData{span.snapshot()}); err != nil {
+		t.Fatal(err)
+	}
+	var req otlpExportRequest
+	if err := JSONParse(string(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %s", body)
+	}
+	if v := req.ResourceSpans[0].Resource.Attributes[0]; v.Key != "service.name" || *v.Value.StringValue != "test" {
+		t.Errorf("unexpected resource: %s", body)
+	}
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got.TraceID != span.TraceID || got.Kind != SpanKindClient || *got.Attributes[0].Value.IntValue != "3" {
+		t.Errorf("unexpected span: %s", body)
+	}
+
+	exporter.Headers = nil
+	if err := exporter.ExportSpans([]SpanData{span.snapshot()}); err == nil {
+		t.Error("expected error on unauthorized response")
+	}
+}
@@ -0,0 +1,141 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+)
+
+// softDeletable 模型是否支持软删除
+func softDeletable(reflectType reflect.Type) bool {
+	_, ok := reflectType.FieldByName("DeletedAt")
+	return ok
+}
+
+// TrashScope 仅查询已软删除的数据，数据权限仍然生效
+func TrashScope(db *gorm.DB, model interface{}) (*gorm.DB, error) {
+	scope := db.NewScope(model)
+	field, ok := scope.FieldByName("DeletedAt")
+	if !ok {
+		return db, fmt.Errorf("model %s does not support soft delete", scope.GetModelStruct().ModelType.Name())
+	}
+	return db.Unscoped().Where(fmt.Sprintf("%v.%v IS NOT NULL", scope.QuotedTableName(), scope.Quote(field.DBName))), nil
+}
+
+func restRestoreHandler(reflectType reflect.Type) func(c *Context) {
+	return func(c *Context) {
+		var (
+			result     interface{}
+			err        error
+			modelValue = reflect.New(reflectType).Elem().Addr().Interface()
+		)
+		// 事务执行
+		err = c.WithTransaction(func(tx *gorm.DB) error {
+			var params struct {
+				All   bool
+				Multi bool
+				Cond  map[string]interface{}
+			}
+			if err := c.ShouldBindBodyWith(&params, binding.JSON); err != nil {
+				return err
+			}
+			if IsBlank(params.Cond) {
+				return errors.New("'cond' is required")
+			}
+			queryDB, err := TrashScope(tx, modelValue)
+			if err != nil {
+				return err
+			}
+			_, queryDB = ParseCond(params.Cond, modelValue, queryDB)
+			if params.Multi || params.All {
+				result = reflect.New(reflect.SliceOf(reflectType)).Interface()
+				queryDB = queryDB.Find(result)
+			} else {
+				result = reflect.New(reflectType).Interface()
+				queryDB = queryDB.First(result)
+			}
+			if queryDB.RowsAffected < 1 {
+				return ErrAffectedSaveToken
+			}
+			restore := func(value interface{}) error {
+				updates := map[string]interface{}{"DeletedAt": nil}
+				if _, ok := reflectType.FieldByName("DeletedByID"); ok {
+					updates["DeletedByID"] = 0
+				}
+				db := tx.Unscoped().Model(value).Updates(updates)
+				if db.Error != nil {
+					return db.Error
+				}
+				if db.RowsAffected < 1 {
+					return ErrAffectedSaveToken
+				}
+				return nil
+			}
+			if indirectScopeValue := indirectValue(result); indirectScopeValue.Kind() == reflect.Slice {
+				for i := 0; i < indirectScopeValue.Len(); i++ {
+					if err := restore(indirectScopeValue.Index(i).Addr().Interface()); err != nil {
+						return err
+					}
+				}
+			} else if err := restore(result); err != nil {
+				return err
+			}
+			return tx.Error
+		})
+		// 响应结果
+		if err != nil {
+			if cusErr, ok := ErrOut(err); ok {
+				c.STDErr(c.L("kuu_error_"+fmt.Sprintf("%v", cusErr.Code), ErrMsgs(err)[0]), err)
+			} else {
+				c.STDErr(c.L("rest_restore_failed", "Restore failed"), err)
+			}
+		} else {
+			result = Meta(reflect.New(reflectType).Interface()).OmitPassword(result)
+			c.STD(result)
+		}
+	}
+}
+
+// trashRetentionDays 读取trash:retention配置（单位：天），支持按模型名称配置，default为默认值
+func trashRetentionDays(modelName string) int {
+	var retention map[string]int
+	C().GetInterface("trash:retention", &retention)
+	if v, ok := retention[modelName]; ok {
+		return v
+	}
+	return retention["default"]
+}
+
+// TrashPurgeJob 物理删除超过保留期限的软删除数据
+func TrashPurgeJob() {
+	for _, meta := range Metalist() {
+		if meta.ModCode == "" || meta.reflectType == nil || !softDeletable(meta.reflectType) {
+			continue
+		}
+		days := trashRetentionDays(meta.Name)
+		if days <= 0 {
+			continue
+		}
+		var (
+			value  = meta.NewValue()
+			scope  = DB().NewScope(value)
+			field  = "deleted_at"
+			cutoff = time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		)
+		if f, ok := scope.FieldByName("DeletedAt"); ok {
+			field = f.DBName
+		}
+		db := DB().Unscoped().
+			Where(fmt.Sprintf("%v IS NOT NULL AND %v < ?", scope.Quote(field), scope.Quote(field)), cutoff).
+			Delete(value)
+		if db.Error != nil {
+			ERROR("Purge trash of %s failed: %s", meta.Name, db.Error.Error())
+		} else if db.RowsAffected > 0 {
+			INFO("Purged %d soft-deleted records of %s", db.RowsAffected, meta.Name)
+		}
+	}
+}
@@ -32,11 +32,13 @@ var ModelWSRoute = RouteInfo{
 		defer func() {
 			if _, ok := wsConns.Load(conn); ok {
 				wsConns.Delete(conn)
+				websocketConnections.Dec()
 			}
 			conn.Close()
 			INFO("websocket.close: %p", conn)
 		}()
 		wsConns.Store(conn, conn)
+		websocketConnections.Inc()
 		INFO("websocket.connect: %p", conn)
 		for {
 			mt, message, err := conn.ReadMessage()